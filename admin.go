@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AdminConfig configures the admin API used to control the bot while it is running.
+type AdminConfig struct {
+	// Address to listen on, e.g. "localhost:6061", the API is disabled if empty.
+	Listen string `json:"listen"`
+
+	// Every request must have an "Authorization: Bearer <token>" header with this token.
+	Token string `json:"token"`
+}
+
+// How long an admin request may wait for the pipeline, e.g. to flush.
+const adminRequestTimeout = 10 * time.Second
+
+type (
+	// adminServer serves the admin API.
+	adminServer struct {
+		token      string
+		publishers *publisherSet
+		filter     *liquidationFilter
+		control    *liquidatorControl
+		state      *State
+	}
+
+	// adminPublisher is the status of a publisher.
+	adminPublisher struct {
+		Name   string      `json:"name"`
+		Paused bool        `json:"paused"`
+		Queued int         `json:"queued"`
+		Held   []adminPost `json:"held"`
+		Digest digest      `json:"digest"`
+	}
+
+	// adminPost is a post waiting to be published.
+	adminPost struct {
+		Timestamp time.Time `json:"timestamp"`
+		USDValue  float64   `json:"usd_value"`
+		Status    string    `json:"status"`
+	}
+
+	// adminThresholds are the minimum USD values of the filter.
+	adminThresholds struct {
+		MinUSD       float64            `json:"min_usd"`
+		SymbolMinUSD map[string]float64 `json:"symbol_min_usd"`
+	}
+
+	// adminManualPost is a message to post as is.
+	adminManualPost struct {
+		Status string `json:"status"`
+
+		// Publishers to post to, all of them if empty.
+		Publishers []string `json:"publishers"`
+	}
+
+	// adminHighScores are the records and kill streak of a symbol.
+	adminHighScores struct {
+		Scores Scores `json:"scores"`
+		Kill   Kill   `json:"kill"`
+	}
+)
+
+// Handler returns the routes of the admin API.
+func (a *adminServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /publishers", a.listPublishers)
+	mux.HandleFunc("POST /publishers/{name}/pause", a.pausePublisher)
+	mux.HandleFunc("POST /publishers/{name}/resume", a.resumePublisher)
+	mux.HandleFunc("GET /publishers/{name}/queue", a.getQueue)
+	mux.HandleFunc("DELETE /publishers/{name}/queue", a.purgeQueue)
+
+	mux.HandleFunc("GET /thresholds", a.getThresholds)
+	mux.HandleFunc("PUT /thresholds", a.setThresholds)
+
+	mux.HandleFunc("POST /flush", a.flush)
+	mux.HandleFunc("POST /post", a.post)
+
+	mux.HandleFunc("GET /highscores/{symbol}", a.getHighScores)
+	mux.HandleFunc("PUT /highscores/{symbol}", a.setHighScores)
+	mux.HandleFunc("DELETE /highscores/{symbol}", a.resetHighScores)
+
+	return a.authenticate(mux)
+}
+
+// authenticate rejects requests without the token.
+func (a *adminServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || a.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// runAdmin serves the admin API until the context is done.
+func runAdmin(ctx context.Context, cfg AdminConfig, a *adminServer) {
+	srv := &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           a.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	adminLog.Info("Listening (admin)", "addr", cfg.Listen)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		adminLog.Error("Admin API stopped", "err", err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+
+	return nil
+}
+
+// publisher finds the publisher named in the path.
+func (a *adminServer) publisher(w http.ResponseWriter, r *http.Request) (*publisherWorker, bool) {
+	name := r.PathValue("name")
+	if p := a.publishers.Find(name); p != nil {
+		return p, true
+	}
+
+	writeError(w, http.StatusNotFound, fmt.Errorf("unknown publisher %q", name))
+	return nil, false
+}
+
+func publisherStatus(p *publisherWorker) adminPublisher {
+	held, queued := p.Held()
+
+	status := adminPublisher{
+		Name:   p.name,
+		Paused: p.Paused(),
+		Queued: queued,
+		Held:   []adminPost{},
+		Digest: p.Digest(),
+	}
+
+	for _, post := range held {
+		status.Held = append(status.Held, adminPost{
+			Timestamp: post.timestamp,
+			USDValue:  post.usdValue,
+			Status:    post.status,
+		})
+	}
+
+	return status
+}
+
+func (a *adminServer) listPublishers(w http.ResponseWriter, r *http.Request) {
+	var statuses []adminPublisher
+	for _, p := range a.publishers.List() {
+		statuses = append(statuses, publisherStatus(p))
+	}
+
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func (a *adminServer) pausePublisher(w http.ResponseWriter, r *http.Request) {
+	p, ok := a.publisher(w, r)
+	if !ok {
+		return
+	}
+
+	p.Pause()
+	adminLog.Info("Paused publisher", "publisher", p.name)
+	writeJSON(w, http.StatusOK, publisherStatus(p))
+}
+
+func (a *adminServer) resumePublisher(w http.ResponseWriter, r *http.Request) {
+	p, ok := a.publisher(w, r)
+	if !ok {
+		return
+	}
+
+	p.Resume()
+	adminLog.Info("Resumed publisher", "publisher", p.name)
+	writeJSON(w, http.StatusOK, publisherStatus(p))
+}
+
+func (a *adminServer) getQueue(w http.ResponseWriter, r *http.Request) {
+	p, ok := a.publisher(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, publisherStatus(p))
+}
+
+func (a *adminServer) purgeQueue(w http.ResponseWriter, r *http.Request) {
+	p, ok := a.publisher(w, r)
+	if !ok {
+		return
+	}
+
+	n := p.Purge()
+	adminLog.Info("Purged posts", "publisher", p.name, "posts", n)
+	writeJSON(w, http.StatusOK, map[string]int{"purged": n})
+}
+
+func (a *adminServer) getThresholds(w http.ResponseWriter, r *http.Request) {
+	minUSD, symbolMinUSD := a.filter.Thresholds()
+	writeJSON(w, http.StatusOK, adminThresholds{MinUSD: minUSD, SymbolMinUSD: symbolMinUSD})
+}
+
+func (a *adminServer) setThresholds(w http.ResponseWriter, r *http.Request) {
+	var t adminThresholds
+	if err := readJSON(w, r, &t); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := a.filter.SetThresholds(t.MinUSD, t.SymbolMinUSD); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	adminLog.Info("Thresholds set", "thresholds", fmt.Sprintf("%+v", t))
+	a.getThresholds(w, r)
+}
+
+func (a *adminServer) flush(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), adminRequestTimeout)
+	defer cancel()
+
+	n, err := a.control.Flush(ctx)
+	if err != nil {
+		writeError(w, http.StatusGatewayTimeout, err)
+		return
+	}
+
+	adminLog.Info("Flushed symbol liquidators", "flushed", n)
+	writeJSON(w, http.StatusOK, map[string]int{"flushed": n})
+}
+
+func (a *adminServer) post(w http.ResponseWriter, r *http.Request) {
+	var m adminManualPost
+	if err := readJSON(w, r, &m); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if strings.TrimSpace(m.Status) == "" {
+		writeError(w, http.StatusBadRequest, errors.New("status is required"))
+		return
+	}
+
+	targets := a.publishers.List()
+	if len(m.Publishers) > 0 {
+		targets = nil
+		for _, name := range m.Publishers {
+			p := a.publishers.Find(name)
+			if p == nil {
+				writeError(w, http.StatusNotFound, fmt.Errorf("unknown publisher %q", name))
+				return
+			}
+			targets = append(targets, p)
+		}
+	}
+
+	queued := []string{}
+	for _, p := range targets {
+		if err := p.Post(m.Status); err != nil {
+			adminLog.Error("Failed to queue manual post", "publisher", p.name, "err", err)
+			continue
+		}
+		queued = append(queued, p.name)
+	}
+
+	adminLog.Info("Manual post queued", "publishers", queued, "status", m.Status)
+	writeJSON(w, http.StatusAccepted, map[string][]string{"queued": queued})
+}
+
+func (a *adminServer) getHighScores(w http.ResponseWriter, r *http.Request) {
+	scores, kill := a.state.SymbolScores(Symbol(r.PathValue("symbol")))
+	writeJSON(w, http.StatusOK, adminHighScores{Scores: scores, Kill: kill})
+}
+
+func (a *adminServer) setHighScores(w http.ResponseWriter, r *http.Request) {
+	symbol := Symbol(r.PathValue("symbol"))
+
+	var scores Scores
+	if err := readJSON(w, r, &scores); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := a.state.SetSymbolScores(symbol, scores); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	adminLog.Info("High scores set", "symbol", symbol)
+	a.getHighScores(w, r)
+}
+
+func (a *adminServer) resetHighScores(w http.ResponseWriter, r *http.Request) {
+	symbol := Symbol(r.PathValue("symbol"))
+	if err := a.state.ResetSymbolScores(symbol); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	adminLog.Info("High scores reset", "symbol", symbol)
+	w.WriteHeader(http.StatusNoContent)
+}
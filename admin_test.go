@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func testAdminServer(t *testing.T) (*adminServer, chan preparedTweet) {
+	s, err := newSchedule(ScheduleConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	published := make(chan preparedTweet, 10)
+	w := &publisherWorker{
+		name:      "test",
+		publisher: publisherFunc(func(post preparedTweet) { published <- post }),
+		schedule:  s,
+		queue:     make(chan preparedTweet, 10),
+		done:      make(chan struct{}),
+		wake:      make(chan struct{}, 1),
+		manual:    make(chan preparedTweet, 10),
+		limiter:   rate.NewLimiter(rate.Inf, 50),
+	}
+
+	filter, err := newLiquidationFilter(FilterConfig{MinUSD: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state := &State{
+		SaveFile:   filepath.Join(t.TempDir(), "high_scores.json"),
+		HighScores: newHighScores(),
+		Corpus:     Corpus{MultiKill: []string{"Double kill"}},
+		Medals:     testMedalEngine(t),
+	}
+
+	return &adminServer{
+		token:      "secret",
+		publishers: newPublisherSet([]*publisherWorker{w}),
+		filter:     filter,
+		control:    newLiquidatorControl(),
+		state:      state,
+	}, published
+}
+
+func adminRequest(t *testing.T, h http.Handler, method, path, body string, out any) int {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatal(method, path, err, rec.Body.String())
+		}
+	}
+
+	return rec.Code
+}
+
+func TestAdminAuthentication(t *testing.T) {
+	a, _ := testAdminServer(t)
+	h := a.Handler()
+
+	for _, auth := range []string{"", "Bearer", "Bearer wrong", "secret"} {
+		req := httptest.NewRequest("GET", "/publishers", nil)
+		req.Header.Set("Authorization", auth)
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%q: expected unauthorized got %v", auth, rec.Code)
+		}
+	}
+
+	if code := adminRequest(t, h, "GET", "/publishers", "", nil); code != http.StatusOK {
+		t.Fatal("expected ok", code)
+	}
+}
+
+func TestAdminPublishers(t *testing.T) {
+	a, published := testAdminServer(t)
+	h := a.Handler()
+	w := a.publishers.List()[0]
+
+	// The digest of what was held back during quiet hours
+	w.digest.Add(preparedTweet{usdValue: 5000})
+
+	var status adminPublisher
+	if adminRequest(t, h, "GET", "/publishers/test/queue", "", &status); status.Digest.Count != 1 || status.Digest.USDValue != 5000 {
+		t.Fatal("expected the digest", status)
+	}
+	w.digest = digest{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.run(ctx)
+
+	if code := adminRequest(t, h, "POST", "/publishers/test/pause", "", &status); code != http.StatusOK || !status.Paused {
+		t.Fatal("expected the publisher to be paused", code, status)
+	}
+
+	w.queue <- preparedTweet{timestamp: time.Now(), usdValue: 1000000, status: "held"}
+
+	// Manual posts are published even when paused
+	var queued map[string][]string
+	if code := adminRequest(t, h, "POST", "/post", `{"status": "maintenance"}`, &queued); code != http.StatusAccepted || len(queued["queued"]) != 1 {
+		t.Fatal("expected the post to be queued", code, queued)
+	}
+
+	if post := <-published; post.status != "maintenance" {
+		t.Fatal("unexpected post", post)
+	}
+
+	adminRequest(t, h, "GET", "/publishers/test/queue", "", &status)
+	if len(status.Held) != 1 || status.Held[0].Status != "held" {
+		t.Fatal("expected a held post", status)
+	}
+
+	adminRequest(t, h, "POST", "/publishers/test/resume", "", &status)
+	if post := <-published; post.status != "held" {
+		t.Fatal("expected the held post to be published on resume", post)
+	}
+
+	// Purge
+	adminRequest(t, h, "POST", "/publishers/test/pause", "", nil)
+	w.queue <- preparedTweet{timestamp: time.Now(), usdValue: 1000000, status: "purged"}
+	for held, _ := w.Held(); len(held) == 0; held, _ = w.Held() {
+		time.Sleep(time.Millisecond)
+	}
+
+	var purged map[string]int
+	if adminRequest(t, h, "DELETE", "/publishers/test/queue", "", &purged); purged["purged"] != 1 {
+		t.Fatal("expected a post to be purged", purged)
+	}
+
+	if code := adminRequest(t, h, "POST", "/publishers/nope/pause", "", nil); code != http.StatusNotFound {
+		t.Fatal("expected not found", code)
+	}
+
+	if code := adminRequest(t, h, "POST", "/post", `{"status": "x", "publishers": ["nope"]}`, nil); code != http.StatusNotFound {
+		t.Fatal("expected not found", code)
+	}
+}
+
+func TestAdminThresholds(t *testing.T) {
+	a, _ := testAdminServer(t)
+	h := a.Handler()
+
+	var thresholds adminThresholds
+	if adminRequest(t, h, "PUT", "/thresholds", `{"min_usd": 5000, "symbol_min_usd": {"XBT*": 100000}}`, &thresholds); thresholds.MinUSD != 5000 {
+		t.Fatal("unexpected thresholds", thresholds)
+	}
+
+	if a.filter.MinUSD("XBTUSD") != 100000 || a.filter.MinUSD("ETHUSD") != 5000 {
+		t.Fatal("thresholds not applied")
+	}
+
+	if code := adminRequest(t, h, "PUT", "/thresholds", `{"symbol_min_usd": {"re:(": 1}}`, nil); code != http.StatusBadRequest {
+		t.Fatal("expected bad request", code)
+	}
+
+	for _, body := range []string{`{"min_usd": -1}`, `{"symbol_min_usd": {"XBT*": -1}}`} {
+		if code := adminRequest(t, h, "PUT", "/thresholds", body, nil); code != http.StatusBadRequest {
+			t.Error("expected negative thresholds to be refused", body, code)
+		}
+	}
+
+	if code := adminRequest(t, h, "PUT", "/thresholds", `{"minimum": 1}`, nil); code != http.StatusBadRequest {
+		t.Fatal("expected bad request", code)
+	}
+}
+
+func TestAdminFlush(t *testing.T) {
+	a, _ := testAdminServer(t)
+	h := a.Handler()
+
+	liqChan := make(chan Liquidation)
+	tweetChan := make(chan preparedTweet, 1)
+	flushChan := a.control.add()
+	go symbolLiquidator(context.Background(), newLiveConfig(BotConfig{}), a.state, liqChan, flushChan, tweetChan)
+
+	// Held back for combining, until flushed. The channel is unbuffered so the liquidation is taken before the flush
+	liqChan <- testLiquidation("XBTUSD", "Sell", 5000)
+
+	var flushed map[string]int
+	if adminRequest(t, h, "POST", "/flush", "", &flushed); flushed["flushed"] != 1 {
+		t.Fatal("unexpected flush", flushed)
+	}
+
+	select {
+	case post := <-tweetChan:
+		if post.usdValue != 5000 {
+			t.Fatal("unexpected post", post)
+		}
+	default:
+		t.Fatal("expected the liquidation to be posted")
+	}
+
+	close(liqChan)
+}
+
+func TestAdminHighScores(t *testing.T) {
+	a, _ := testAdminServer(t)
+	h := a.Handler()
+
+	a.state.Decorate(testLiquidation("XBTUSD", "Sell", 5000).ToCombined())
+
+	var hs adminHighScores
+	if adminRequest(t, h, "GET", "/highscores/XBTUSD", "", &hs); hs.Scores.Long.Day.USDValue != 5000 || hs.Kill.Count != 1 {
+		t.Fatal("unexpected high scores", hs)
+	}
+
+	if adminRequest(t, h, "PUT", "/highscores/XBTUSD", `{"long": {"all_time": {"usd_value": 1000000}}}`, &hs); hs.Scores.Long.AllTime.USDValue != 1000000 {
+		t.Fatal("unexpected high scores", hs)
+	}
+
+	if code := adminRequest(t, h, "DELETE", "/highscores/XBTUSD", "", nil); code != http.StatusNoContent {
+		t.Fatal("expected no content", code)
+	}
+
+	if scores, kill := a.state.SymbolScores("XBTUSD"); scores.Long.AllTime.USDValue != 0 || kill.Count != 0 {
+		t.Fatal("expected the high scores to be reset", scores, kill)
+	}
+}
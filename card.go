@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strings"
+)
+
+// Card dimensions, Twitter displays 16:9 images without cropping.
+const (
+	cardWidth   = 1200
+	cardHeight  = 675
+	cardMargin  = 60
+	cardAltSize = 1000 // Twitter's alt text limit
+)
+
+var (
+	cardBackground = color.RGBA{0x15, 0x17, 0x1c, 0xff}
+	cardText       = color.RGBA{0xf2, 0xf2, 0xf2, 0xff}
+	cardMuted      = color.RGBA{0x9a, 0xa0, 0xa6, 0xff}
+	cardUSD        = color.RGBA{0xf5, 0xc5, 0x42, 0xff}
+	cardLong       = color.RGBA{0xe0, 0x3c, 0x3c, 0xff}
+	cardShort      = color.RGBA{0x2e, 0xc2, 0x7e, 0xff}
+)
+
+// badgeLabel is the text drawn on the medal badge.
+func (m Medal) badgeLabel() string {
+	if m.Label != "" {
+		return m.Label
+	}
+
+	return strings.ToUpper(m.Name)
+}
+
+// badgeColor is the colour of the medal badge.
+func (m Medal) badgeColor() color.RGBA {
+	if c, err := parseHexColor(m.Color); err == nil {
+		return c
+	}
+
+	return cardMuted
+}
+
+// description of the medal for the alt text.
+func (m Medal) description() string {
+	if m.Description != "" {
+		return m.Description
+	}
+
+	return strings.ReplaceAll(m.Name, "_", " ")
+}
+
+// textWidth returns the width in pixels of text drawn at a scale.
+func textWidth(text string, scale int) int {
+	n := len([]rune(text))
+	if n == 0 {
+		return 0
+	}
+
+	return (n*(glyphWidth+1) - 1) * scale
+}
+
+// fitScale returns the largest scale up to max that fits text into width.
+func fitScale(text string, width, max int) int {
+	for scale := max; scale > 1; scale-- {
+		if textWidth(text, scale) <= width {
+			return scale
+		}
+	}
+
+	return 1
+}
+
+// drawText draws text with its top left corner at x, y.
+func drawText(img draw.Image, x, y, scale int, text string, c color.Color) {
+	src := image.NewUniform(c)
+	for _, r := range text {
+		g := glyph(r)
+		for row, line := range g {
+			for col, px := range line {
+				if px != '#' {
+					continue
+				}
+
+				rect := image.Rect(x+col*scale, y+row*scale, x+(col+1)*scale, y+(row+1)*scale)
+				draw.Draw(img, rect, src, image.Point{}, draw.Src)
+			}
+		}
+		x += (glyphWidth + 1) * scale
+	}
+}
+
+// drawCircle draws a filled circle.
+func drawCircle(img *image.RGBA, cx, cy, radius int, c color.RGBA) {
+	for y := -radius; y <= radius; y++ {
+		for x := -radius; x <= radius; x++ {
+			if x*x+y*y <= radius*radius {
+				img.SetRGBA(cx+x, cy+y, c)
+			}
+		}
+	}
+}
+
+// uniqueMedals returns the medals in order of first appearance along with how many times they were awarded.
+func uniqueMedals(medals []Medal) (order []Medal, counts map[Medal]int) {
+	counts = make(map[Medal]int)
+	for _, m := range medals {
+		if counts[m] == 0 {
+			order = append(order, m)
+		}
+		counts[m]++
+	}
+
+	return order, counts
+}
+
+// RenderCard draws a PNG card for a liquidation, in English if the card font can't draw the locale.
+func RenderCard(loc Locale, cl CombinedLiquidation, d Decoration) ([]byte, error) {
+	if loc.CardHeading == "" {
+		loc = englishLocale
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, cardWidth, cardHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(cardBackground), image.Point{}, draw.Src)
+
+	accent := cardLong
+	if positionName(cl.Side) == "short" {
+		accent = cardShort
+	}
+	draw.Draw(img, image.Rect(0, 0, cardWidth, 24), image.NewUniform(accent), image.Point{}, draw.Src)
+
+	width := cardWidth - 2*cardMargin
+	y := 64
+
+	heading := strings.ToUpper(fmt.Sprintf(loc.CardHeading, loc.Position(cl.Side)))
+	drawText(img, cardMargin, y, 6, heading, accent)
+	y += glyphHeight*6 + 32
+
+	symbol := cl.Name()
+	scale := fitScale(symbol, width, 16)
+	drawText(img, cardMargin, y, scale, symbol, cardText)
+	y += glyphHeight*scale + 40
+
+	fills := loc.Side(cl.Side) + " " + cl.contractFills(loc)
+	scale = fitScale(fills, width, 6)
+	drawText(img, cardMargin, y, scale, fills, cardMuted)
+	y += glyphHeight*scale + 40
+
+	usd := "$" + loc.Number(displayUSD(cl.USDValue()))
+	scale = fitScale(usd, width, 10)
+	drawText(img, cardMargin, y, scale, usd, cardUSD)
+
+	// Medals are drawn along the bottom as badges
+	order, counts := uniqueMedals(d.Medals)
+	x := cardMargin + 40
+	for _, m := range order {
+		if x > cardWidth-cardMargin-40 {
+			continue
+		}
+
+		drawCircle(img, x, cardHeight-110, 36, m.badgeColor())
+		if counts[m] > 1 {
+			count := fmt.Sprintf("%d", counts[m])
+			drawText(img, x-textWidth(count, 4)/2, cardHeight-110-glyphHeight*2, 4, count, cardBackground)
+		}
+
+		label := m.badgeLabel()
+		drawText(img, x-textWidth(label, 3)/2, cardHeight-60, 3, label, cardText)
+		x += 140
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// CardAltText describes the contents of a card for screen readers.
+func CardAltText(loc Locale, cl CombinedLiquidation, d Decoration) string {
+	alt := fmt.Sprintf(loc.CardAlt, loc.Position(cl.Side), cl.Name(), loc.Side(cl.Side), cl.contractFills(loc), loc.Number(displayUSD(cl.USDValue())))
+
+	order, counts := uniqueMedals(d.Medals)
+	var medals []string
+	for _, m := range order {
+		if counts[m] > 1 {
+			medals = append(medals, fmt.Sprintf("%v (x%d)", m.description(), counts[m]))
+		} else {
+			medals = append(medals, m.description())
+		}
+	}
+
+	if len(medals) > 0 {
+		alt += fmt.Sprintf(loc.CardMedals, strings.Join(medals, ", "))
+	}
+
+	if runes := []rune(alt); len(runes) > cardAltSize {
+		alt = string(runes[:cardAltSize])
+	}
+
+	return alt
+}
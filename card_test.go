@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestRenderCard(t *testing.T) {
+	cl := CombinedLiquidation{
+		Symbol: "XBTUSD",
+		Side:   "Sell",
+		Liquidations: []PriceQuantity{
+			{Price: 60000, Quantity: 500000, Currency: "USD", TotalUSDValue: 500000, MinStep: 100, MinTick: 0.5},
+			{Price: 60000.5, Quantity: 700000, Currency: "USD", TotalUSDValue: 700000, MinStep: 100, MinTick: 0.5},
+		},
+	}
+	week := Medal{Name: "largest_week", Label: "WEEK", Description: "largest this week", Color: "#cd7f32"}
+	hundred := Medal{Name: "100k"}
+	d := Decoration{Medals: []Medal{week, hundred, hundred}}
+
+	card, err := RenderCard(englishLocale, cl, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(card))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b := img.Bounds(); b.Dx() != cardWidth || b.Dy() != cardHeight {
+		t.Fatal("unexpected card size", b)
+	}
+
+	alt := CardAltText(englishLocale, cl, d)
+	if alt == "" || len([]rune(alt)) > cardAltSize || !strings.HasSuffix(alt, "Medals: largest this week, 100k (x2).") {
+		t.Fatal("bad alt text", alt)
+	}
+	t.Log(alt)
+}
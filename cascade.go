@@ -0,0 +1,186 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+type (
+	// Cascade summarises a burst of liquidations on one side of a symbol.
+	Cascade struct {
+		Symbol Symbol
+		Side   string
+
+		Count    int
+		Quantity float64
+		Currency string
+		USDValue float64
+
+		MinPrice float64
+		MaxPrice float64
+		MinStep  float64
+		MinTick  float64
+
+		Start time.Time
+		End   time.Time
+	}
+
+	// cascadeKey identifies the liquidations a cascade can cover.
+	cascadeKey struct {
+		Symbol Symbol
+		Side   string
+	}
+
+	// cascadeEvent is a liquidation seen by the detector.
+	cascadeEvent struct {
+		Time        time.Time
+		Liquidation Liquidation
+	}
+
+	// cascadeDetector watches a sliding window of liquidations for each symbol and side.
+	cascadeDetector struct {
+		cfg CascadeConfig
+
+		recent map[cascadeKey][]cascadeEvent
+		active map[cascadeKey]*Cascade
+	}
+)
+
+func newCascadeDetector(cfg CascadeConfig) *cascadeDetector {
+	return &cascadeDetector{
+		cfg:    cfg,
+		recent: make(map[cascadeKey][]cascadeEvent),
+		active: make(map[cascadeKey]*Cascade),
+	}
+}
+
+// add a liquidation to the cascade.
+func (c *Cascade) add(at time.Time, pq PriceQuantity) {
+	if c.Count == 0 {
+		c.Start = at
+		c.End = at
+		c.MinPrice = pq.Price
+		c.MaxPrice = pq.Price
+		c.Currency = pq.Currency
+		c.MinStep = pq.MinStep
+		c.MinTick = pq.MinTick
+	}
+
+	c.Count++
+	c.Quantity += pq.Quantity
+	c.USDValue += pq.TotalUSDValue
+	c.MinPrice = math.Min(c.MinPrice, pq.Price)
+	c.MaxPrice = math.Max(c.MaxPrice, pq.Price)
+
+	if at.Before(c.Start) {
+		c.Start = at
+	}
+	if at.After(c.End) {
+		c.End = at
+	}
+}
+
+// Duration between the first and last liquidation of the cascade.
+func (c Cascade) Duration() time.Duration {
+	return c.End.Sub(c.Start)
+}
+
+// String implements Stringer.
+func (c Cascade) String() string {
+	return englishLocale.FormatCascade(c)
+}
+
+// Observe records a liquidation, returning true if it is part of a cascade and should not be posted on its own.
+// The liquidations in the window only count towards starting a cascade, as they have already been posted or
+// are waiting to be combined, those which are waiting are added with Absorb.
+func (cd *cascadeDetector) Observe(l Liquidation, now time.Time) bool {
+	if cd.cfg.Window <= 0 {
+		return false
+	}
+
+	key := cascadeKey{l.Symbol, l.Side}
+
+	if c, ok := cd.active[key]; ok {
+		c.add(now, l.PriceQuantity)
+		return true
+	}
+
+	// Slide the window along
+	events := cd.recent[key]
+	cutoff := now.Add(-time.Duration(cd.cfg.Window))
+	for len(events) > 0 && events[0].Time.Before(cutoff) {
+		events = events[1:]
+	}
+	events = append(events, cascadeEvent{now, l})
+
+	var usdValue float64
+	for _, e := range events {
+		usdValue += e.Liquidation.TotalUSDValue
+	}
+
+	if len(events) < cd.cfg.MinCount || usdValue < cd.cfg.MinUSD {
+		cd.recent[key] = events
+		return false
+	}
+
+	// The cascade starts with this liquidation
+	c := &Cascade{
+		Symbol: l.Symbol,
+		Side:   l.Side,
+	}
+	c.add(now, l.PriceQuantity)
+
+	cd.active[key] = c
+	delete(cd.recent, key)
+	return true
+}
+
+// Absorb adds a liquidation which was waiting to be combined to the active cascade of its symbol and side.
+func (cd *cascadeDetector) Absorb(cl CombinedLiquidation, at time.Time) {
+	c, ok := cd.active[cascadeKey{cl.Symbol, cl.Side}]
+	if !ok {
+		return
+	}
+
+	for _, pq := range cl.Liquidations {
+		c.add(at, pq)
+	}
+}
+
+// Active returns true if there is an ongoing cascade for the symbol and side.
+func (cd *cascadeDetector) Active(symbol Symbol, side string) bool {
+	_, ok := cd.active[cascadeKey{symbol, side}]
+	return ok
+}
+
+// Finished returns the cascades which have gone quiet or run for too long.
+func (cd *cascadeDetector) Finished(now time.Time) []Cascade {
+	return cd.take(func(c *Cascade) bool {
+		quiet := now.Sub(c.End) >= time.Duration(cd.cfg.Quiet)
+		tooLong := cd.cfg.MaxDuration > 0 && now.Sub(c.Start) >= time.Duration(cd.cfg.MaxDuration)
+
+		return quiet || tooLong
+	})
+}
+
+// Flush returns all the active cascades, used when shutting down.
+func (cd *cascadeDetector) Flush() []Cascade {
+	return cd.take(func(*Cascade) bool { return true })
+}
+
+// take removes the active cascades matching f, oldest first.
+func (cd *cascadeDetector) take(f func(c *Cascade) bool) (finished []Cascade) {
+	for key, c := range cd.active {
+		if f(c) {
+			finished = append(finished, *c)
+			delete(cd.active, key)
+		}
+	}
+
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].Start.Before(finished[j].Start)
+	})
+
+	return finished
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCascadeDetector(t *testing.T) {
+	cd := newCascadeDetector(CascadeConfig{
+		Window:      Duration(time.Minute),
+		MinCount:    5,
+		MinUSD:      500000,
+		Quiet:       Duration(time.Minute),
+		MaxDuration: Duration(10 * time.Minute),
+	})
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	liq := func(price, quantity float64, side string) Liquidation {
+		return Liquidation{
+			PriceQuantity: PriceQuantity{Price: price, Quantity: quantity, Currency: "USD", TotalUSDValue: quantity, MinStep: 100, MinTick: 0.5},
+			Symbol:        "XBTUSD",
+			Side:          side,
+		}
+	}
+
+	// Spread out liquidations never make a cascade
+	for i := 0; i < 10; i++ {
+		if cd.Observe(liq(60000, 200000, "Sell"), start.Add(time.Duration(i)*time.Minute)) {
+			t.Fatal("unexpected cascade")
+		}
+	}
+
+	start = start.Add(time.Hour)
+	for i := 0; i < 4; i++ {
+		if cd.Observe(liq(60000-float64(i)*100, 200000, "Sell"), start.Add(time.Duration(i)*time.Second)) {
+			t.Fatal("cascade started too early")
+		}
+	}
+
+	// The fifth one crosses both thresholds
+	if !cd.Observe(liq(59500, 200000, "Sell"), start.Add(5*time.Second)) {
+		t.Fatal("expected cascade")
+	}
+
+	// The other side is unaffected
+	if cd.Observe(liq(59500, 200000, "Buy"), start.Add(6*time.Second)) {
+		t.Fatal("unexpected cascade on the other side")
+	}
+
+	if !cd.Observe(liq(59000, 200000, "Sell"), start.Add(30*time.Second)) {
+		t.Fatal("expected liquidation to be covered by the cascade")
+	}
+
+	// The earlier liquidations were posted, apart from the last which was waiting to be combined
+	cd.Absorb(liq(59700, 200000, "Sell").ToCombined(), start.Add(3*time.Second))
+
+	if finished := cd.Finished(start.Add(60 * time.Second)); len(finished) != 0 {
+		t.Fatal("cascade finished too early", finished)
+	}
+
+	finished := cd.Finished(start.Add(90 * time.Second))
+	if len(finished) != 1 {
+		t.Fatal("expected a finished cascade", finished)
+	}
+
+	c := finished[0]
+	if c.Count != 3 || c.USDValue != 600000 || c.MinPrice != 59000 || c.MaxPrice != 59700 || c.Duration() != 27*time.Second {
+		t.Fatal("unexpected cascade", c)
+	}
+
+	if s := c.String(); s != "Liquidation cascade on XBTUSD: 3 longs liquidated in 27s, Sell 600,000 @ 59,000 - 59,700 (≈ $600,000)" {
+		t.Fatal("unexpected format", s)
+	}
+
+	if cd.Active("XBTUSD", "Sell") {
+		t.Fatal("cascade should no longer be active")
+	}
+}
+
+func TestRenderCascade(t *testing.T) {
+	c := Cascade{
+		Symbol: "XBTUSD", Side: "Sell", Count: 3, Quantity: 600000, Currency: "USD", USDValue: 600000,
+		MinPrice: 59000, MaxPrice: 59700, MinStep: 100, MinTick: 0.5,
+		Start: time.Unix(0, 0), End: time.Unix(27, 0),
+	}
+
+	f, err := newPostFormatter(FormatConfig{MaxLength: 40}, englishLocale)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cascades are held to the publisher's length limit too
+	if s := f.RenderCascade(c); s != "Liquidation cascade on XBTUSD: 3 longs…" {
+		t.Fatal("unexpected cascade", s)
+	}
+}
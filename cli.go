@@ -0,0 +1,672 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"golang.org/x/time/rate"
+)
+
+// command is a subcommand of the binary.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string, stdout io.Writer) error
+}
+
+// commands of the binary, the first is the default when none is given.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"run", "connect to BitMex and post liquidations", runCommand},
+		{"replay", "feed frames recorded with run -record through the bot, printing the posts", replayCommand},
+		{"render", "print what a raw liquidation would be posted as", renderCommand},
+		{"validate-config", "check the config and text files", validateConfigCommand},
+		{"stats", "summarize the high scores and saved history", statsCommand},
+		{"help", "show this help", helpCommand},
+	}
+}
+
+// runCLI runs the subcommand named by the first argument, running the bot if there is none.
+func runCLI(args []string, stdout io.Writer) error {
+	cmd := commands[0]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		var ok bool
+		if cmd, ok = findCommand(args[0]); !ok {
+			helpCommand(nil, os.Stderr)
+			return fmt.Errorf("unknown command %q", args[0])
+		}
+		args = args[1:]
+	}
+
+	if err := cmd.run(args, stdout); err != nil {
+		return fmt.Errorf("%v: %w", cmd.name, err)
+	}
+
+	return nil
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+
+	return command{}, false
+}
+
+func helpCommand(args []string, stdout io.Writer) error {
+	fmt.Fprintf(stdout, "Usage: %v [command] [flags]\n\nCommands:\n", os.Args[0])
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %v\t%v\n", cmd.name, cmd.summary)
+	}
+	w.Flush()
+
+	fmt.Fprintf(stdout, "\nThe default command is %v, use %v <command> -h for its flags.\n", commands[0].name, os.Args[0])
+	return nil
+}
+
+// configFlags are the flags shared by the commands which load the config, set flags override its values.
+type configFlags struct {
+	fs   *flag.FlagSet
+	path string
+
+	bitmexHost      string
+	cardMinUSD      float64
+	minUSD          float64
+	recordsTimezone string
+	adminListen     string
+	debugListen     string
+	stateDir        string
+	textDir         string
+	logLevel        string
+	logFormat       string
+	reloadInterval  Duration
+	shutdownTimeout Duration
+}
+
+func addConfigFlags(fs *flag.FlagSet) *configFlags {
+	c := configFlags{fs: fs}
+	fs.StringVar(&c.path, "config", configPath(), "config file, defaults to $CONFIG")
+	fs.StringVar(&c.bitmexHost, "bitmex-host", "", "override bitmex_host")
+	fs.Float64Var(&c.cardMinUSD, "card-min-usd", 0, "override card_min_usd")
+	fs.Float64Var(&c.minUSD, "min-usd", 0, "override filter.min_usd")
+	fs.StringVar(&c.recordsTimezone, "records-timezone", "", "override records.timezone")
+	fs.StringVar(&c.adminListen, "admin-listen", "", "override admin.listen")
+	fs.StringVar(&c.debugListen, "debug-listen", "", "override debug_listen")
+	fs.StringVar(&c.stateDir, "state-dir", "", "override state_dir")
+	fs.StringVar(&c.textDir, "text-dir", "", "override text_dir")
+	fs.StringVar(&c.logLevel, "log-level", "", "override log.level")
+	fs.StringVar(&c.logFormat, "log-format", "", "override log.format")
+	fs.Var(&c.reloadInterval, "reload-interval", "override reload_interval")
+	fs.Var(&c.shutdownTimeout, "shutdown-timeout", "override shutdown_timeout")
+
+	return &c
+}
+
+// override applies the flags which were set to the config.
+func (c *configFlags) override(cfg *BotConfig) {
+	c.fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "bitmex-host":
+			cfg.BitMexHost = c.bitmexHost
+		case "card-min-usd":
+			cfg.CardMinUSD = c.cardMinUSD
+		case "min-usd":
+			cfg.Filter.MinUSD = c.minUSD
+		case "records-timezone":
+			cfg.Records.Timezone = c.recordsTimezone
+		case "admin-listen":
+			cfg.Admin.Listen = c.adminListen
+		case "debug-listen":
+			cfg.DebugListen = c.debugListen
+		case "state-dir":
+			cfg.StateDir = c.stateDir
+		case "text-dir":
+			cfg.TextDir = c.textDir
+		case "log-level":
+			cfg.Log.Level = c.logLevel
+		case "log-format":
+			cfg.Log.Format = c.logFormat
+		case "reload-interval":
+			cfg.ReloadInterval = c.reloadInterval
+		case "shutdown-timeout":
+			cfg.ShutdownTimeout = c.shutdownTimeout
+		}
+	})
+}
+
+// load reads the config file, applies the flags and sets up the logging.
+func (c *configFlags) load() (BotConfig, error) {
+	cfg, err := loadConfigFile(c.path)
+	if err != nil {
+		return cfg, err
+	}
+
+	c.override(&cfg)
+
+	if err := configureLogging(cfg.Log); err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+// newStateFor loads the state with the text for every publisher's locale.
+func newStateFor(cfg BotConfig, paths Paths) (*State, error) {
+	loc, err := cfg.Records.Location()
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	state, err := NewState(loc, paths)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state: %w", err)
+	}
+
+	for _, pc := range cfg.publisherConfigs() {
+		locale, err := findLocale(pc.Locale)
+		if err != nil {
+			return nil, fmt.Errorf("publisher %v: %w", pc.Name, err)
+		}
+
+		if locale.Name != englishLocale.Name && state.Translations[locale.Name] == nil {
+			if err := state.LoadTranslation(locale.Name); err != nil {
+				return nil, fmt.Errorf("failed to load %v text for publisher %v: %w", locale.Name, pc.Name, err)
+			}
+		}
+	}
+
+	return state, nil
+}
+
+// offlinePublisher stands in for a publisher without connecting to anything, so posts are formatted the same.
+func offlinePublisher(pc PublisherConfig) Publisher {
+	if pc.Type == PublisherTwitter {
+		return &twitterPublisher{}
+	}
+
+	return logPublisher{}
+}
+
+// checkConfig checks everything the bot needs from the config to start, without connecting to anything.
+func checkConfig(cfg BotConfig) error {
+	errs := []error{cfg.Validate()}
+
+	if _, err := newLiquidationFilter(cfg.Filter); err != nil {
+		errs = append(errs, fmt.Errorf("filter: %w", err))
+	}
+
+	paths, err := cfg.Paths()
+	if err != nil {
+		return err
+	}
+	text := paths.Text()
+
+	if _, err := loadCorpus(text, snarkFile, multiKillFile); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := loadMedals(text); err != nil {
+		errs = append(errs, err)
+	}
+
+	seen := make(map[string]bool)
+	for _, pc := range cfg.publisherConfigs() {
+		if seen[pc.Name] {
+			errs = append(errs, fmt.Errorf("duplicate publisher name %q", pc.Name))
+			continue
+		}
+		seen[pc.Name] = true
+
+		if pc.Type != PublisherTwitter && pc.Type != PublisherLog {
+			errs = append(errs, fmt.Errorf("publisher %v: unknown type %q", pc.Name, pc.Type))
+			continue
+		}
+
+		if _, format, err := publisherSettings(pc, offlinePublisher(pc)); err != nil {
+			errs = append(errs, err)
+		} else if name := format.locale.Name; name != englishLocale.Name {
+			if _, err := loadTranslation(text, name); err != nil {
+				errs = append(errs, fmt.Errorf("publisher %v: %w", pc.Name, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateConfigCommand(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("validate-config", flag.ExitOnError)
+	flags := addConfigFlags(fs)
+	fs.Parse(args)
+
+	cfg, err := flags.load()
+	if err != nil {
+		return fmt.Errorf("unable to load config: %w", err)
+	}
+
+	if err := checkConfig(cfg); err != nil {
+		return fmt.Errorf("%v is invalid:\n%w", flags.path, err)
+	}
+
+	fmt.Fprintln(stdout, flags.path, "is valid")
+	return nil
+}
+
+// printPublisher prints the posts, used to replay recordings.
+type printPublisher struct {
+	name string
+	w    io.Writer
+	mu   *sync.Mutex
+}
+
+// Publish implements Publisher.
+func (p printPublisher) Publish(ctx context.Context, post preparedTweet) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, err := fmt.Fprintf(p.w, "%v: %v\n", p.name, post.status)
+	return err
+}
+
+func replayCommand(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	flags := addConfigFlags(fs)
+	delay := fs.Duration("delay", 0, "pause after each liquidation frame, so liquidations are combined as they were live")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("usage: replay [flags] <file>")
+	}
+
+	cfg, err := flags.load()
+	if err != nil {
+		return fmt.Errorf("unable to load config: %w", err)
+	}
+
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Nothing is saved or published, the posts are printed without rate limits
+	var mu sync.Mutex
+	var publishers []*publisherWorker
+	for _, pc := range cfg.publisherConfigs() {
+		sched, format, err := publisherSettings(pc, offlinePublisher(pc))
+		if err != nil {
+			return err
+		}
+
+		w := newPublisherWorker(pc.Name, printPublisher{name: pc.Name, w: stdout, mu: &mu}, sched, format)
+		w.limiter = rate.NewLimiter(rate.Inf, w.limiter.Burst())
+		publishers = append(publishers, w)
+	}
+
+	paths, err := cfg.Paths()
+	if err != nil {
+		return err
+	}
+
+	state, err := newStateFor(cfg, paths)
+	if err != nil {
+		return err
+	}
+	state.SaveFile = ""
+
+	filter, err := newLiquidationFilter(cfg.Filter)
+	if err != nil {
+		return fmt.Errorf("invalid filter: %w", err)
+	}
+
+	orders, err := NewOrderStore("", orderStoreTTL)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	live := newLiveConfig(cfg)
+	liqChan := make(chan Liquidation, 1024)
+	liquidatorDone := make(chan struct{})
+
+	go func() {
+		defer close(liquidatorDone)
+		liquidator(ctx, live, filter, newLiquidatorControl(), liqChan, state, newPublisherSet(publishers))
+	}()
+
+	err = replayFrames(ctx, file, &feed{cfg: live, orders: orders}, liqChan, *delay)
+
+	// Post everything still being combined
+	close(liqChan)
+	<-liquidatorDone
+	for _, w := range publishers {
+		<-w.done
+	}
+
+	return err
+}
+
+// replayFrames feeds the recorded frames to the feed until the end of the recording.
+func replayFrames(ctx context.Context, r io.Reader, f *feed, liqChan chan<- Liquidation, delay time.Duration) error {
+	dec := json.NewDecoder(r)
+	for n := 1; ; n++ {
+		var data frame
+		if err := dec.Decode(&data); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("frame %v: %w", n, err)
+		}
+
+		if err := f.handle(ctx, data, liqChan); err != nil {
+			return fmt.Errorf("frame %v: %w", n, err)
+		}
+
+		if delay > 0 && data.Table == "liquidation" {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// loadInstruments reads an instrument table saved from the instrument partial frame, or a list of instruments.
+func loadInstruments(path string) (*InstrumentTable, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var instruments []Instrument
+	if err := json.Unmarshal(raw, &instruments); err != nil {
+		var data frame
+		if err := json.Unmarshal(raw, &data); err != nil {
+			return nil, fmt.Errorf("%v: %w", path, err)
+		}
+
+		if err := json.Unmarshal(data.Data, &instruments); err != nil {
+			return nil, fmt.Errorf("%v: %w", path, err)
+		}
+	}
+
+	return NewInstrumentTable(instruments), nil
+}
+
+// parseRawLiquidations reads a raw liquidation, or a list of them as in the data of a liquidation frame.
+func parseRawLiquidations(raw []byte) ([]RawLiquidation, error) {
+	var liquidations []RawLiquidation
+	if err := json.Unmarshal(raw, &liquidations); err == nil {
+		return liquidations, nil
+	}
+
+	var l RawLiquidation
+	if err := json.Unmarshal(raw, &l); err != nil {
+		return nil, err
+	}
+
+	return []RawLiquidation{l}, nil
+}
+
+func renderCommand(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	flags := addConfigFlags(fs)
+	instruments := fs.String("instruments", "instruments.json", "instrument table, as sent in the instrument partial frame")
+	cardFile := fs.String("card", "", "write the image card to this file in the first publisher's locale, even if the liquidation would not get one")
+	fs.Parse(args)
+
+	var input io.Reader = os.Stdin
+	if fs.NArg() > 1 {
+		return errors.New("usage: render [flags] [file], the raw liquidation JSON is read from stdin without a file")
+	} else if fs.NArg() == 1 && fs.Arg(0) != "-" {
+		file, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		input = file
+	}
+
+	raw, err := io.ReadAll(input)
+	if err != nil {
+		return err
+	}
+
+	liquidations, err := parseRawLiquidations(raw)
+	if err != nil {
+		return fmt.Errorf("invalid raw liquidation: %w", err)
+	}
+
+	cfg, err := flags.load()
+	if err != nil {
+		return fmt.Errorf("unable to load config: %w", err)
+	}
+
+	it, err := loadInstruments(*instruments)
+	if err != nil {
+		return err
+	}
+
+	type output struct {
+		name   string
+		format *postFormatter
+	}
+
+	var outputs []output
+	for _, pc := range cfg.publisherConfigs() {
+		_, format, err := publisherSettings(pc, offlinePublisher(pc))
+		if err != nil {
+			return err
+		}
+		outputs = append(outputs, output{pc.Name, format})
+	}
+
+	filter, err := newLiquidationFilter(cfg.Filter)
+	if err != nil {
+		return fmt.Errorf("invalid filter: %w", err)
+	}
+
+	// The records and snark are only changed in memory
+	paths, err := cfg.Paths()
+	if err != nil {
+		return err
+	}
+
+	state, err := newStateFor(cfg, paths)
+	if err != nil {
+		return err
+	}
+	state.SaveFile = ""
+
+	var wroteCard bool
+	for _, v := range liquidations {
+		l, err := it.Process(v)
+		if err != nil {
+			return fmt.Errorf("failed to process %+v: %w", v, err)
+		}
+
+		if reason := filter.Check(l); reason != "" {
+			fmt.Fprintf(stdout, "%v: filtered: %v\n", l, reason)
+			continue
+		}
+
+		cl := l.ToCombined()
+		if cfg.Combining.GroupByUnderlying {
+			cl.Underlying = l.Underlying
+		}
+
+		d := state.Decorate(cl)
+		withCard := *cardFile != "" || cfg.CardMinUSD > 0 && cl.USDValue() >= cfg.CardMinUSD
+		for _, o := range outputs {
+			text := o.format.Render(cl, d)
+			fmt.Fprintf(stdout, "--- %v (%v, %v characters)\n%v\n", o.name, o.format.locale.Name, o.format.length(text), text)
+
+			if withCard {
+				fmt.Fprintf(stdout, "--- %v card\n%v\n", o.name, CardAltText(o.format.locale, cl, d))
+			}
+		}
+
+		if *cardFile != "" {
+			loc := englishLocale
+			if len(outputs) > 0 {
+				loc = outputs[0].format.locale
+			}
+
+			card, err := RenderCard(loc, cl, d)
+			if err != nil {
+				return err
+			}
+
+			if err := os.WriteFile(*cardFile, card, 0644); err != nil {
+				return err
+			}
+			wroteCard = true
+		}
+	}
+
+	if *cardFile != "" && !wroteCard {
+		return fmt.Errorf("no card written to %v, every liquidation was filtered", *cardFile)
+	}
+
+	return nil
+}
+
+func statsCommand(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	flags := addConfigFlags(fs)
+	fs.Parse(args)
+
+	cfg, err := flags.load()
+	if err != nil {
+		return fmt.Errorf("unable to load config: %w", err)
+	}
+
+	loc, err := cfg.Records.Location()
+	if err != nil {
+		return err
+	}
+
+	paths, err := cfg.Paths()
+	if err != nil {
+		return err
+	}
+
+	hs, err := loadHighScores(paths.State(highScoresFile), loc)
+	if err != nil {
+		return err
+	}
+
+	orders, err := NewOrderStore(paths.State(orderStoreFile), orderStoreTTL)
+	if err != nil {
+		return err
+	}
+
+	var queues map[string]savedQueue
+	if raw, err := os.ReadFile(paths.State(publisherQueueFile)); err == nil {
+		if err := json.Unmarshal(raw, &queues); err != nil {
+			return fmt.Errorf("%v: %w", publisherQueueFile, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	printStats(stdout, hs, orders.Len(), queues, loc)
+	return nil
+}
+
+// printStats summarizes the high scores and saved history.
+func printStats(stdout io.Writer, hs HighScores, seenOrders int, queues map[string]savedQueue, loc *time.Location) {
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	record := func(r Record, period string) string {
+		if r.USDValue == 0 {
+			return "-"
+		}
+
+		if period == "" {
+			period = time.Unix(r.UnixTime, 0).In(loc).Format("2006-01-02")
+		}
+
+		return fmt.Sprintf("$%v (%v)", humanize.Comma(int64(math.Round(r.USDValue))), period)
+	}
+
+	symbols := make([]string, 0, len(hs.Scores))
+	for symbol := range hs.Scores {
+		symbols = append(symbols, string(symbol))
+	}
+	sort.Strings(symbols)
+
+	fmt.Fprintln(w, "SYMBOL\tSIDE\tDAY\tWEEK\tMONTH\tYEAR\tALL TIME")
+	for _, symbol := range symbols {
+		scores := hs.Scores[Symbol(symbol)]
+		for _, side := range []struct {
+			name   string
+			scores SideScores
+		}{{"long", scores.Long}, {"short", scores.Short}} {
+			s := side.scores
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", symbol, side.name,
+				record(s.Day, s.DayKey), record(s.Week, s.WeekKey), record(s.Month, s.MonthKey), record(s.Year, s.YearKey), record(s.AllTime, ""))
+		}
+	}
+
+	symbols = symbols[:0]
+	for symbol := range hs.Kills {
+		symbols = append(symbols, string(symbol))
+	}
+	sort.Strings(symbols)
+
+	fmt.Fprintln(w, "\nSYMBOL\tKILL STREAK\tLAST KILL")
+	for _, symbol := range symbols {
+		kill := hs.Kills[Symbol(symbol)]
+		fmt.Fprintf(w, "%v\t%v\t%v\n", symbol, kill.Count, time.Unix(kill.UnixTime, 0).In(loc).Format(time.DateTime))
+	}
+
+	fmt.Fprintln(w)
+
+	locales := make([]string, 0, len(hs.Snark))
+	for locale := range hs.Snark {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+
+	for _, locale := range locales {
+		fmt.Fprintf(w, "Snark left in the %v shuffle:\t%v\n", locale, len(hs.Snark[locale]))
+	}
+
+	fmt.Fprintf(w, "Orders seen in the last %v hours:\t%v\n", orderStoreTTL.Hours(), seenOrders)
+
+	names := make([]string, 0, len(queues))
+	for name := range queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		q := queues[name]
+		fmt.Fprintf(w, "Unpublished posts for %v:\t%v, %v in the digest\n", name, len(q.Posts), q.Digest.Count)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestConfig writes a config file to a temporary directory.
+func writeTestConfig(t *testing.T, cfg string) string {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestConfigFlags(t *testing.T) {
+	path := writeTestConfig(t, `{"bitmex_host": "www.bitmex.com", "card_min_usd": 1000000, "filter": {"min_usd": 1000}}`)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := addConfigFlags(fs)
+	if err := fs.Parse([]string{"-config", path, "-min-usd", "5000", "-shutdown-timeout", "5s"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := flags.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Filter.MinUSD != 5000 || time.Duration(cfg.ShutdownTimeout) != 5*time.Second {
+		t.Fatalf("flags not applied: %+v", cfg)
+	}
+
+	// Flags which were not set leave the config alone
+	if cfg.BitMexHost != "www.bitmex.com" || cfg.CardMinUSD != 1000000 {
+		t.Fatalf("config overridden by unset flags: %+v", cfg)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	path := writeTestConfig(t, `{"publishers": [{"name": "printed", "type": "log"}]}`)
+
+	raw, err := os.ReadFile("instruments.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var instruments frame
+	if err := json.Unmarshal(raw, &instruments); err != nil {
+		t.Fatal(err)
+	}
+	instruments.Table = "instrument"
+
+	liquidation := frame{
+		Table:  "liquidation",
+		Action: "insert",
+		Data:   json.RawMessage(`[{"orderID": "a", "price": 60000, "symbol": "XBTUSD", "leavesQty": 500000, "side": "Sell"}]`),
+	}
+
+	// The insert is repeated, as BitMex does when it finds a better price
+	var recording bytes.Buffer
+	enc := json.NewEncoder(&recording)
+	for _, f := range []frame{liquidation, instruments, liquidation, liquidation} {
+		if err := enc.Encode(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "frames.json")
+	if err := os.WriteFile(file, recording.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := runCLI([]string{"replay", "-config", path, file}, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	// Posted once, liquidations before the instruments are loaded are skipped
+	if n := strings.Count(out.String(), "printed: Liquidated long on XBTUSD: Sell 500,000 @ 60,000"); n != 1 {
+		t.Fatalf("expected one post, got %v:\n%v", n, out.String())
+	}
+}
+
+func TestRender(t *testing.T) {
+	path := writeTestConfig(t, `{"publishers": [{"name": "en", "type": "twitter"}, {"name": "es", "type": "log", "locale": "es"}]}`)
+
+	input := filepath.Join(t.TempDir(), "liquidation.json")
+	if err := os.WriteFile(input, []byte(`{"orderID": "a", "price": 60000, "symbol": "XBTUSD", "leavesQty": 500000, "side": "Buy"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := runCLI([]string{"render", "-config", path, input}, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"--- en (en, ",
+		"Liquidated short on XBTUSD: Buy 500,000 @ 60,000",
+		"--- es (es, ",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("expected %q in:\n%v", expected, out.String())
+		}
+	}
+
+	if err := runCLI([]string{"render", "-config", path, "-min-usd", "1000000", input}, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), "filtered: ") {
+		t.Fatalf("expected the liquidation to be filtered:\n%v", out.String())
+	}
+
+	// Cards are drawn when asked for, whatever card_min_usd is
+	card := filepath.Join(t.TempDir(), "card.png")
+	if err := runCLI([]string{"render", "-config", path, "-card", card, input}, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if png, err := os.ReadFile(card); err != nil || !bytes.HasPrefix(png, []byte("\x89PNG")) {
+		t.Fatal("expected a card", err)
+	}
+
+	if err := runCLI([]string{"render", "-config", path, "-min-usd", "1000000", "-card", card, input}, &out); err == nil {
+		t.Fatal("expected an error when no card is written")
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	path := writeTestConfig(t, `{"admin": {"listen": "localhost:6061"}, "filter": {"include": ["re:("]}, "publishers": [{"name": "a", "type": "carrier pigeon"}]}`)
+
+	err := runCLI([]string{"validate-config", "-config", path}, &bytes.Buffer{})
+	if err == nil {
+		t.Fatal("expected the config to be invalid")
+	}
+
+	// Every problem is reported at once
+	for _, expected := range []string{"admin", "filter", "carrier pigeon"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Fatalf("expected %q in %v", expected, err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+type (
+	// CombiningPolicy decides which liquidations are combined into a single post.
+	// All values are in USD so that it works the same regardless of how the contract is quoted.
+	CombiningPolicy struct {
+		// Caps the number of liquidations that can be combined into a single post.
+		MaxPositions int `json:"max_positions"`
+
+		// Liquidations worth more than this are always posted on their own.
+		MaxUSDValue float64 `json:"max_usd_value"`
+
+		// Boundaries between groups of similarly sized liquidations, only liquidations in the same group are combined.
+		MergeGroups []float64 `json:"merge_groups"`
+
+		// How long to wait for another liquidation to combine with, smaller liquidations wait longer.
+		Delays []CombiningDelay `json:"delays"`
+
+		// Delay for liquidations larger than every tier in Delays.
+		Delay Duration `json:"delay"`
+	}
+
+	// CombiningDelay is the time to wait for liquidations worth less than BelowUSD.
+	CombiningDelay struct {
+		BelowUSD float64  `json:"below_usd"`
+		Delay    Duration `json:"delay"`
+	}
+
+	// CombiningConfig selects the combining policy for each liquidation.
+	// Symbol policies take precedence over instrument type policies, unset fields fall back to the default policy.
+	CombiningConfig struct {
+		// Combine liquidations across contracts with the same underlying, e.g. XBTUSD, XBTUSDT and XBT futures.
+		GroupByUnderlying bool `json:"group_by_underlying"`
+
+		Default         CombiningPolicy                    `json:"default"`
+		InstrumentTypes map[InstrumentType]CombiningPolicy `json:"instrument_types"`
+		Symbols         map[Symbol]CombiningPolicy         `json:"symbols"`
+	}
+)
+
+// DefaultCombiningPolicy is used when nothing is configured.
+var DefaultCombiningPolicy = CombiningPolicy{
+	MaxPositions: 3,
+	MaxUSDValue:  250000,
+	MergeGroups:  []float64{1, 24999, 250000},
+	Delays: []CombiningDelay{
+		{BelowUSD: 1000, Delay: Duration(30 * time.Second)},
+		{BelowUSD: 25000, Delay: Duration(20 * time.Second)},
+		{BelowUSD: 125000, Delay: Duration(15 * time.Second)},
+	},
+	Delay: Duration(10 * time.Second),
+}
+
+// withDefaults fills in the unset fields of a policy.
+func (p CombiningPolicy) withDefaults(d CombiningPolicy) CombiningPolicy {
+	if p.MaxPositions == 0 {
+		p.MaxPositions = d.MaxPositions
+	}
+	if p.MaxUSDValue == 0 {
+		p.MaxUSDValue = d.MaxUSDValue
+	}
+	if p.MergeGroups == nil {
+		p.MergeGroups = d.MergeGroups
+	}
+	if p.Delays == nil {
+		p.Delays = d.Delays
+	}
+	if p.Delay == 0 {
+		p.Delay = d.Delay
+	}
+
+	return p
+}
+
+// Policy returns the combining policy for a liquidation.
+func (c CombiningConfig) Policy(l Liquidation) CombiningPolicy {
+	def := c.Default.withDefaults(DefaultCombiningPolicy)
+
+	if p, ok := c.Symbols[l.Symbol]; ok {
+		return p.withDefaults(def)
+	}
+
+	if p, ok := c.InstrumentTypes[l.Type]; ok {
+		return p.withDefaults(def)
+	}
+
+	return def
+}
+
+// mergeGroup returns the group a liquidation of the given value belongs to.
+func (p CombiningPolicy) mergeGroup(usdValue float64) int {
+	return sort.SearchFloat64s(p.MergeGroups, usdValue)
+}
+
+// CombiningDelay is the minimum time to wait for another liquidation to combine with.
+func (p CombiningPolicy) CombiningDelay(usdValue float64) time.Duration {
+	delays := make([]CombiningDelay, len(p.Delays))
+	copy(delays, p.Delays)
+	sort.Slice(delays, func(i, j int) bool {
+		return delays[i].BelowUSD < delays[j].BelowUSD
+	})
+
+	for _, d := range delays {
+		if usdValue < d.BelowUSD {
+			return time.Duration(d.Delay)
+		}
+	}
+
+	return time.Duration(p.Delay)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func testLiquidation(symbol Symbol, side string, usdValue float64) Liquidation {
+	return Liquidation{
+		PriceQuantity: PriceQuantity{
+			Price:         50000,
+			Quantity:      usdValue,
+			Currency:      "USD",
+			TotalUSDValue: usdValue,
+		},
+		Symbol: symbol,
+		Side:   side,
+		Type:   ITPerpetualContracts,
+	}
+}
+
+func TestCombiningPolicyCanCombine(t *testing.T) {
+	custom := CombiningPolicy{
+		MaxPositions: 5,
+		MaxUSDValue:  1000000,
+		MergeGroups:  []float64{10000},
+	}.withDefaults(DefaultCombiningPolicy)
+
+	table := []struct {
+		Name     string
+		Policy   CombiningPolicy
+		Existing []float64
+		Next     Liquidation
+		Expected bool
+	}{
+		{"same group", DefaultCombiningPolicy, []float64{100}, testLiquidation("XBTUSD", "Buy", 200), true},
+		{"different symbol", DefaultCombiningPolicy, []float64{100}, testLiquidation("ETHUSD", "Buy", 200), false},
+		{"different side", DefaultCombiningPolicy, []float64{100}, testLiquidation("XBTUSD", "Sell", 200), false},
+		{"ones only combine with ones", DefaultCombiningPolicy, []float64{1}, testLiquidation("XBTUSD", "Buy", 2), false},
+		{"ones", DefaultCombiningPolicy, []float64{1, 1}, testLiquidation("XBTUSD", "Buy", 1), true},
+		{"different group", DefaultCombiningPolicy, []float64{20000}, testLiquidation("XBTUSD", "Buy", 30000), false},
+		{"too many", DefaultCombiningPolicy, []float64{100, 100, 100}, testLiquidation("XBTUSD", "Buy", 100), false},
+		{"next too large", DefaultCombiningPolicy, []float64{200000}, testLiquidation("XBTUSD", "Buy", 260000), false},
+		{"existing too large", DefaultCombiningPolicy, []float64{300000}, testLiquidation("XBTUSD", "Buy", 100), false},
+		{"custom more positions", custom, []float64{100, 100, 100, 100}, testLiquidation("XBTUSD", "Buy", 100), true},
+		{"custom larger group", custom, []float64{20000}, testLiquidation("XBTUSD", "Buy", 900000), true},
+		{"custom groups", custom, []float64{5000}, testLiquidation("XBTUSD", "Buy", 20000), false},
+	}
+
+	for _, v := range table {
+		t.Run(v.Name, func(t *testing.T) {
+			cl := testLiquidation("XBTUSD", "Buy", v.Existing[0]).ToCombined()
+			for _, usd := range v.Existing[1:] {
+				cl.Liquidations = append(cl.Liquidations, testLiquidation("XBTUSD", "Buy", usd).PriceQuantity)
+			}
+
+			if result := cl.CanCombine(v.Next, v.Policy); result != v.Expected {
+				t.Fatalf("expected %v got %v", v.Expected, result)
+			}
+
+			err := cl.Combine(v.Next, v.Policy)
+			if (err == nil) != v.Expected {
+				t.Fatalf("expected combine to succeed: %v got %v", v.Expected, err)
+			}
+		})
+	}
+}
+
+func TestCombiningPolicyDelay(t *testing.T) {
+	custom := CombiningPolicy{
+		Delays: []CombiningDelay{
+			{BelowUSD: 100000, Delay: Duration(time.Minute)},
+			{BelowUSD: 10000, Delay: Duration(2 * time.Minute)},
+		},
+		Delay: Duration(5 * time.Second),
+	}
+
+	table := []struct {
+		Policy   CombiningPolicy
+		USDValue float64
+		Expected time.Duration
+	}{
+		{DefaultCombiningPolicy, 1, 30 * time.Second},
+		{DefaultCombiningPolicy, 999, 30 * time.Second},
+		{DefaultCombiningPolicy, 1000, 20 * time.Second},
+		{DefaultCombiningPolicy, 24999, 20 * time.Second},
+		{DefaultCombiningPolicy, 25000, 15 * time.Second},
+		{DefaultCombiningPolicy, 125000, 10 * time.Second},
+		{DefaultCombiningPolicy, 10000000, 10 * time.Second},
+		{custom, 5000, 2 * time.Minute},
+		{custom, 50000, time.Minute},
+		{custom, 500000, 5 * time.Second},
+	}
+
+	for _, v := range table {
+		if result := v.Policy.CombiningDelay(v.USDValue); result != v.Expected {
+			t.Errorf("%v: expected %v got %v", v.USDValue, v.Expected, result)
+		}
+	}
+}
+
+func TestCombiningConfigPolicy(t *testing.T) {
+	cfg := CombiningConfig{
+		Default: CombiningPolicy{MaxPositions: 4},
+		InstrumentTypes: map[InstrumentType]CombiningPolicy{
+			ITFutures: {MaxUSDValue: 50000},
+		},
+		Symbols: map[Symbol]CombiningPolicy{
+			"ETHUSDT": {MaxPositions: 10},
+		},
+	}
+
+	futures := testLiquidation("XBTZ24", "Buy", 100)
+	futures.Type = ITFutures
+
+	table := []struct {
+		Liq          Liquidation
+		MaxPositions int
+		MaxUSDValue  float64
+	}{
+		{testLiquidation("XBTUSD", "Buy", 100), 4, 250000},
+		{futures, 4, 50000},
+		{testLiquidation("ETHUSDT", "Buy", 100), 10, 250000},
+	}
+
+	for _, v := range table {
+		p := cfg.Policy(v.Liq)
+		if p.MaxPositions != v.MaxPositions || p.MaxUSDValue != v.MaxUSDValue {
+			t.Errorf("%v: unexpected policy %+v", v.Liq.Symbol, p)
+		}
+
+		if p.CombiningDelay(1) != 30*time.Second {
+			t.Errorf("%v: expected default delays", v.Liq.Symbol)
+		}
+	}
+
+	if p := (CombiningConfig{}).Policy(testLiquidation("XBTUSD", "Buy", 100)); p.MaxPositions != DefaultCombiningPolicy.MaxPositions {
+		t.Error("expected the default policy", p)
+	}
+}
+
+func TestCombineByUnderlying(t *testing.T) {
+	xbtusd := testLiquidation("XBTUSD", "Sell", 100000)
+	xbtusd.Underlying = "XBT"
+
+	xbtusdt := testLiquidation("XBTUSDT", "Sell", 120000)
+	xbtusdt.Underlying = "XBT"
+	xbtusdt.Quantity = 2
+	xbtusdt.Currency = "XBT"
+
+	ethusd := testLiquidation("ETHUSD", "Sell", 100000)
+	ethusd.Underlying = "ETH"
+
+	// Without grouping the symbols must match
+	cl := xbtusd.ToCombined()
+	if cl.CanCombine(xbtusdt, DefaultCombiningPolicy) {
+		t.Fatal("expected ungrouped liquidations not to combine")
+	}
+
+	cl.Underlying = "XBT"
+	if cl.CanCombine(ethusd, DefaultCombiningPolicy) {
+		t.Fatal("expected different underlyings not to combine")
+	}
+
+	if err := cl.Combine(xbtusdt, DefaultCombiningPolicy); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cl.Combine(xbtusd, DefaultCombiningPolicy); err != nil {
+		t.Fatal(err)
+	}
+
+	// The policy caps the fills across all contracts
+	if cl.CanCombine(xbtusdt, DefaultCombiningPolicy) {
+		t.Fatal("expected too many positions")
+	}
+
+	if len(cl.Liquidations) != 2 || len(cl.Related) != 1 || cl.USDValue() != 320000 {
+		t.Fatal("unexpected combined liquidation", cl)
+	}
+
+	if s := cl.String(); s != "Liquidated long on XBT: Sell XBTUSD 100,000 + 100,000 @ 50,000; XBTUSDT 2 XBT @ 50,000 (≈ $320,000)" {
+		t.Fatal("unexpected format", s)
+	}
+}
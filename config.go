@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BotConfig store the bot configuration.
+type BotConfig struct {
+	BitMexHost            string `json:"bitmex_host"`
+	TwitterConsumerKey    string `json:"twitter_consumer_key"`
+	TwitterConsumerSecret string `json:"twitter_consumer_secret"`
+	TwitterAccessToken    string `json:"twitter_access_token"`
+	TwitterTokenSecret    string `json:"twitter_token_secret"`
+
+	// Liquidations worth at least this much get an image card attached, 0 disables cards.
+	CardMinUSD float64 `json:"card_min_usd"`
+
+	PriceContext PriceContextConfig `json:"price_context"`
+	Cascade      CascadeConfig      `json:"cascade"`
+	Combining    CombiningConfig    `json:"combining"`
+	Filter       FilterConfig       `json:"filter"`
+	Records      RecordsConfig      `json:"records"`
+
+	// Outputs to post to, defaults to Twitter using the credentials above or logging if there are none.
+	Publishers []PublisherConfig `json:"publishers"`
+
+	// Admin API to pause publishers, adjust thresholds and so on while running, on its own listener.
+	Admin AdminConfig `json:"admin"`
+
+	Log    LogConfig    `json:"log"`
+	Health HealthConfig `json:"health"`
+
+	// Address to serve pprof, expvar and the health checks on, defaults to localhost:6060, "off" disables it.
+	DebugListen string `json:"debug_listen"`
+
+	// Where the high scores, seen orders and unpublished posts are kept, and the snark, kill streaks and
+	// medals are loaded from, see Paths for the defaults.
+	StateDir string `json:"state_dir"`
+	TextDir  string `json:"text_dir"`
+
+	// Check the config and text files for changes this often and reload them, 0 only reloads on SIGHUP.
+	ReloadInterval Duration `json:"reload_interval"`
+
+	// How long to wait for pending liquidations to be flushed and saved on shutdown, defaults to 20s.
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+}
+
+// PriceContextConfig controls the "(-4.2% in 15m)" price move shown alongside liquidations.
+type PriceContextConfig struct {
+	// Window to measure the price move over, 0 disables price context.
+	Window Duration `json:"window"`
+
+	// Minimum absolute percentage move worth mentioning.
+	MinMove float64 `json:"min_move"`
+}
+
+// CascadeConfig controls when a burst of liquidations is posted as a single cascade.
+type CascadeConfig struct {
+	// Sliding window liquidations are counted over, 0 disables cascade detection.
+	Window Duration `json:"window"`
+
+	// Thresholds within the window to start a cascade.
+	MinCount int     `json:"min_count"`
+	MinUSD   float64 `json:"min_usd"`
+
+	// A cascade is posted once there have been no liquidations for this long.
+	Quiet Duration `json:"quiet"`
+
+	// Long cascades are posted after this long and a new one started, 0 means no limit.
+	MaxDuration Duration `json:"max_duration"`
+}
+
+// RecordsConfig controls the daily, weekly, monthly and yearly records.
+type RecordsConfig struct {
+	// IANA timezone the periods roll over in, e.g. "America/New_York", defaults to UTC.
+	Timezone string `json:"timezone"`
+}
+
+// Location returns the timezone the records roll over in.
+func (cfg RecordsConfig) Location() (*time.Location, error) {
+	if cfg.Timezone == "" {
+		return time.UTC, nil
+	}
+
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid records timezone %q: %w", cfg.Timezone, err)
+	}
+
+	return loc, nil
+}
+
+// Duration is a time.Duration that is written as a string such as "15m" in JSON.
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"15m\": %w", err)
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// String implements flag.Value.
+func (d *Duration) String() string {
+	return time.Duration(*d).String()
+}
+
+// Set implements flag.Value.
+func (d *Duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+	return nil
+}
+
+// configPath is the default config file, set by the CONFIG environment variable.
+func configPath() string {
+	if path := os.Getenv("CONFIG"); path != "" {
+		return path
+	}
+
+	return "config.json"
+}
+
+// loadConfigFile reads the config, the environment overrides the paths and listeners.
+// Secret references are left for resolveSecrets, so commands which do not post can run without the credentials.
+// Unknown keys are an error, so a typo does not quietly fall back to the default.
+func loadConfigFile(path string) (config BotConfig, err error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&config); err != nil {
+		return config, decodeError(path, raw, dec.InputOffset(), err)
+	}
+
+	if dec.More() {
+		return config, decodeError(path, raw, dec.InputOffset(), errors.New("unexpected data after the config"))
+	}
+
+	config.applyEnv()
+
+	return config, nil
+}
+
+// Matches the error for an unknown field.
+var unknownFieldError = regexp.MustCompile(`^json: unknown field "(.*)"$`)
+
+// decodeError adds the line and column to a decoding error, and a suggestion for unknown fields.
+func decodeError(path string, raw []byte, offset int64, err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+		err = fmt.Errorf("%v must be %v, not %v", typeErr.Field, typeErr.Type, typeErr.Value)
+
+	default:
+		if m := unknownFieldError.FindStringSubmatch(err.Error()); m != nil {
+			// The decoder does not say where, so point at the first use of the key
+			if i := bytes.Index(raw, []byte(`"`+m[1]+`"`)); i >= 0 {
+				offset = int64(i) + 1
+			}
+
+			if suggestion := closestConfigKey(m[1]); suggestion != "" {
+				err = fmt.Errorf("unknown field %q, did you mean %q?", m[1], suggestion)
+			} else {
+				err = fmt.Errorf("unknown field %q", m[1])
+			}
+		}
+	}
+
+	if offset > int64(len(raw)) {
+		offset = int64(len(raw))
+	}
+
+	before := raw[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n') - 1
+
+	return fmt.Errorf("%v:%v:%v: %w", path, line, column, err)
+}
+
+// closestConfigKey returns the config key closest to a misspelt one, empty if none are close.
+func closestConfigKey(key string) string {
+	keys := make(map[string]bool)
+	configKeys(reflect.TypeOf(BotConfig{}), keys)
+
+	best, bestDistance := "", 3
+	for k := range keys {
+		if d := editDistance(strings.ToLower(key), k); d < bestDistance || (d == bestDistance && k < best) {
+			best, bestDistance = k, d
+		}
+	}
+
+	if best == "" || bestDistance >= 3 {
+		return ""
+	}
+
+	return best
+}
+
+// configKeys collects the JSON keys of a config type and everything inside it.
+func configKeys(t reflect.Type, keys map[string]bool) {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+		configKeys(t.Elem(), keys)
+
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+
+			if name, _, _ := strings.Cut(f.Tag.Get("json"), ","); name != "" && name != "-" {
+				keys[name] = true
+			}
+			configKeys(f.Type, keys)
+		}
+	}
+}
+
+// editDistance is the Levenshtein distance between two strings.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
+
+// Environment variables which override the config.
+var configEnv = []struct {
+	name  string
+	value func(*BotConfig) *string
+}{
+	{"REKT_STATE_DIR", func(cfg *BotConfig) *string { return &cfg.StateDir }},
+	{"REKT_TEXT_DIR", func(cfg *BotConfig) *string { return &cfg.TextDir }},
+	{"REKT_DEBUG_LISTEN", func(cfg *BotConfig) *string { return &cfg.DebugListen }},
+	{"REKT_ADMIN_LISTEN", func(cfg *BotConfig) *string { return &cfg.Admin.Listen }},
+}
+
+func (cfg *BotConfig) applyEnv() {
+	for _, env := range configEnv {
+		if v, ok := os.LookupEnv(env.name); ok {
+			*env.value(cfg) = v
+		}
+	}
+}
+
+// debugListen returns the pprof and expvar address, empty if disabled.
+func (cfg BotConfig) debugListen() string {
+	switch cfg.DebugListen {
+	case "":
+		return defaultDebugListen
+	case "off":
+		return ""
+	}
+
+	return cfg.DebugListen
+}
+
+// Shortest admin token accepted, so it cannot be guessed.
+const minAdminTokenLength = 16
+
+// Validate checks that the settings make sense, reporting every problem at once.
+func (cfg BotConfig) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	switch {
+	case cfg.BitMexHost == "":
+		invalid("bitmex_host is empty, set it to www.bitmex.com or testnet.bitmex.com")
+	case strings.ContainsAny(cfg.BitMexHost, "/:"):
+		invalid("bitmex_host should be a host name such as www.bitmex.com, not %q", cfg.BitMexHost)
+	}
+
+	for _, v := range []struct {
+		name  string
+		value float64
+	}{
+		{"card_min_usd", cfg.CardMinUSD},
+		{"price_context.min_move", cfg.PriceContext.MinMove},
+		{"cascade.min_count", float64(cfg.Cascade.MinCount)},
+		{"cascade.min_usd", cfg.Cascade.MinUSD},
+		{"filter.min_usd", cfg.Filter.MinUSD},
+		{"health.max_queued", float64(cfg.Health.MaxQueued)},
+		{"health.max_failures", float64(cfg.Health.MaxFailures)},
+	} {
+		if v.value < 0 {
+			invalid("%v must not be negative, got %v", v.name, v.value)
+		}
+	}
+
+	for pattern, minUSD := range cfg.Filter.SymbolMinUSD {
+		if minUSD < 0 {
+			invalid("filter.symbol_min_usd[%q] must not be negative, got %v", pattern, minUSD)
+		}
+	}
+
+	for _, v := range []struct {
+		name  string
+		value Duration
+	}{
+		{"price_context.window", cfg.PriceContext.Window},
+		{"cascade.window", cfg.Cascade.Window},
+		{"cascade.quiet", cfg.Cascade.Quiet},
+		{"cascade.max_duration", cfg.Cascade.MaxDuration},
+		{"reload_interval", cfg.ReloadInterval},
+		{"shutdown_timeout", cfg.ShutdownTimeout},
+		{"health.max_disconnected", cfg.Health.MaxDisconnected},
+		{"health.max_instrument_age", cfg.Health.MaxInstrumentAge},
+	} {
+		if v.value < 0 {
+			invalid("%v must not be negative, got %v", v.name, time.Duration(v.value))
+		}
+	}
+
+	if cfg.Cascade.Window > 0 {
+		if cfg.Cascade.MinCount == 0 && cfg.Cascade.MinUSD == 0 {
+			invalid("cascade.window is set without cascade.min_count or cascade.min_usd, every liquidation would be a cascade")
+		}
+		if cfg.Cascade.Quiet == 0 {
+			invalid("cascade.quiet must be set when cascade.window is, it is how long without liquidations ends a cascade")
+		}
+	}
+
+	if interval := time.Duration(cfg.ReloadInterval); interval > 0 && interval < time.Second {
+		invalid("reload_interval of %v would check the files constantly, use at least 1s, or 0 to only reload on SIGHUP", interval)
+	}
+
+	validatePolicy := func(name string, p CombiningPolicy) {
+		if p.MaxPositions < 0 {
+			invalid("%v.max_positions must not be negative, got %v", name, p.MaxPositions)
+		}
+		if p.MaxUSDValue < 0 {
+			invalid("%v.max_usd_value must not be negative, got %v", name, p.MaxUSDValue)
+		}
+		if !sort.Float64sAreSorted(p.MergeGroups) {
+			invalid("%v.merge_groups must be in ascending order, got %v", name, p.MergeGroups)
+		}
+		for i, d := range p.Delays {
+			if d.Delay < 0 {
+				invalid("%v.delays[%v].delay must not be negative, got %v", name, i, time.Duration(d.Delay))
+			}
+		}
+		if p.Delay < 0 {
+			invalid("%v.delay must not be negative, got %v", name, time.Duration(p.Delay))
+		}
+	}
+
+	validatePolicy("combining.default", cfg.Combining.Default)
+	for t, p := range cfg.Combining.InstrumentTypes {
+		validatePolicy(fmt.Sprintf("combining.instrument_types[%q]", t), p)
+	}
+	for symbol, p := range cfg.Combining.Symbols {
+		validatePolicy(fmt.Sprintf("combining.symbols[%q]", symbol), p)
+	}
+
+	if _, err := cfg.Records.Location(); err != nil {
+		invalid("records.timezone: %w", err)
+	}
+
+	for i, pc := range cfg.publisherConfigs() {
+		name := pc.Name
+		if name == "" {
+			invalid("publishers[%v] has no name", i)
+			name = fmt.Sprintf("publishers[%v]", i)
+		}
+
+		if pc.Type == PublisherTwitter {
+			creds, from := pc.twitterCredentials(), "publisher "+name
+			if pc.TwitterConsumerKey == "" {
+				if creds != [4]string{} {
+					invalid("publisher %v: twitter_consumer_key is empty, so its other credentials are ignored in favour of the top level ones", name)
+				}
+				creds, from = cfg.twitterCredentials(), "the top level"
+			}
+
+			for i, key := range twitterCredentialKeys {
+				if creds[i] == "" {
+					invalid("publisher %v: %v is missing from %v", name, key, from)
+				}
+			}
+		}
+
+		if pc.PostsPerDay < 0 {
+			invalid("publisher %v: posts_per_day must not be negative, got %v", name, pc.PostsPerDay)
+		}
+
+		if pc.Format.MaxLength < -1 {
+			invalid("publisher %v: format.max_length must be -1 for no limit or more, got %v", name, pc.Format.MaxLength)
+		}
+	}
+
+	if err := cfg.checkSecretRefs(); err != nil {
+		invalid("%w", err)
+	}
+
+	if cfg.Admin.Listen != "" {
+		if cfg.Admin.Token == "" {
+			invalid("admin.token is needed when admin.listen is set")
+		} else if !isSecretRef(cfg.Admin.Token) && len(cfg.Admin.Token) < minAdminTokenLength {
+			invalid("admin.token is too short, use at least %v characters, e.g. from openssl rand -hex 32", minAdminTokenLength)
+		}
+	}
+
+	for _, v := range []struct {
+		name string
+		addr string
+	}{{"admin.listen", cfg.Admin.Listen}, {"debug_listen", cfg.debugListen()}, {"health.listen", cfg.Health.Listen}} {
+		if v.addr == "" {
+			continue
+		}
+
+		if _, _, err := net.SplitHostPort(v.addr); err != nil {
+			invalid("%v should be a host and port such as localhost:6060: %w", v.name, err)
+		}
+	}
+
+	if err := cfg.Log.validate(); err != nil {
+		invalid("%w", err)
+	}
+
+	if addr := cfg.debugListen(); addr != "" && addr == cfg.Admin.Listen {
+		invalid("debug_listen and admin.listen are both %v, they need their own addresses", addr)
+	}
+
+	if addr := cfg.Health.Listen; addr != "" && (addr == cfg.Admin.Listen || addr == cfg.debugListen()) {
+		invalid("health.listen is %v, which is already used by admin.listen or debug_listen, leave it empty to serve the health checks on debug_listen", addr)
+	}
+
+	return errors.Join(errs...)
+}
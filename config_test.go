@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigStrict(t *testing.T) {
+	for _, c := range []struct {
+		config   string
+		expected string
+	}{
+		{"{\n    \"bitmex_host\": \"www.bitmex.com\",\n    \"filter\": {\"min_usdd\": 1000}\n}", `:3:16: unknown field "min_usdd", did you mean "min_usd"?`},
+		{`{"zzzzzzzz": 1}`, `:1:2: unknown field "zzzzzzzz"`},
+		{`{"card_min_usd": "lots"}`, `:1:23: card_min_usd must be float64, not string`},
+		{`{"cascade": {"window": "soon"}}`, `time: invalid duration "soon"`},
+		{`{} {}`, `unexpected data after the config`},
+		{`{"bitmex_host": }`, `:1:17: invalid character '}'`},
+	} {
+		_, err := loadConfigFile(writeTestConfig(t, c.config))
+		if err == nil || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("%v: expected an error containing %q, got %v", c.config, c.expected, err)
+		}
+	}
+
+	// The example must keep up with the config, and can be checked without its credentials
+	t.Setenv("CREDENTIALS_DIRECTORY", "")
+
+	cfg, err := loadConfigFile("config.json.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := BotConfig{BitMexHost: "www.bitmex.com"}
+	if err := valid.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name     string
+		change   func(*BotConfig)
+		expected []string
+	}{
+		{"url", func(cfg *BotConfig) { cfg.BitMexHost = "wss://www.bitmex.com/realtime" }, []string{"bitmex_host should be a host name"}},
+		{"negative", func(cfg *BotConfig) {
+			cfg.Filter.MinUSD = -1
+			cfg.Cascade.Quiet = Duration(-1)
+		}, []string{"filter.min_usd must not be negative", "cascade.quiet must not be negative"}},
+		{"cascade", func(cfg *BotConfig) { cfg.Cascade.Window = Duration(60e9) }, []string{"without cascade.min_count or cascade.min_usd", "cascade.quiet must be set"}},
+		{"merge groups", func(cfg *BotConfig) {
+			cfg.Combining.Symbols = map[Symbol]CombiningPolicy{"XBTUSD": {MergeGroups: []float64{100, 10}}}
+		}, []string{`combining.symbols["XBTUSD"].merge_groups must be in ascending order`}},
+		{"twitter", func(cfg *BotConfig) {
+			cfg.TwitterConsumerKey = "key"
+			cfg.Publishers = []PublisherConfig{{Name: "es", Type: PublisherTwitter, TwitterAccessToken: "token"}}
+		}, []string{"its other credentials are ignored", "twitter_consumer_secret is missing from the top level"}},
+		{"posts per day", func(cfg *BotConfig) {
+			cfg.Publishers = []PublisherConfig{{Name: "log", Type: PublisherLog, PostsPerDay: -1}}
+		}, []string{"posts_per_day must not be negative"}},
+		{"admin", func(cfg *BotConfig) { cfg.Admin = AdminConfig{Listen: "localhost:6060", Token: "hunter2"} }, []string{"admin.token is too short", "both localhost:6060"}},
+		{"listen", func(cfg *BotConfig) { cfg.DebugListen = "6060" }, []string{"debug_listen should be a host and port"}},
+		{"reload", func(cfg *BotConfig) { cfg.ReloadInterval = Duration(1e6) }, []string{"use at least 1s"}},
+	} {
+		cfg := valid
+		c.change(&cfg)
+
+		err := cfg.Validate()
+		if err == nil {
+			t.Errorf("%v: expected an error", c.name)
+			continue
+		}
+
+		for _, expected := range c.expected {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("%v: expected %q in %v", c.name, expected, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// OrderStore remembers the liquidation order IDs that have been seen so that they are only posted once.
+// It is shared across websocket connections and persisted to disk so it also survives restarts.
+type OrderStore struct {
+	path string
+	ttl  time.Duration
+
+	mu    sync.Mutex
+	seen  map[string]time.Time
+	dirty bool
+}
+
+// NewOrderStore loads the order IDs seen within the TTL, an empty path keeps the store in memory.
+func NewOrderStore(path string, ttl time.Duration) (*OrderStore, error) {
+	s := OrderStore{
+		path: path,
+		ttl:  ttl,
+		seen: make(map[string]time.Time),
+	}
+
+	if path == "" {
+		return &s, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &s, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var stored map[string]int64
+	if err := json.Unmarshal(raw, &stored); err != nil {
+		// The history is only used to prevent duplicates, so start afresh rather than refusing to run
+		stateLog.Warn("Ignoring corrupt order store", "file", path, "err", err)
+		return &s, nil
+	}
+
+	for id, unix := range stored {
+		s.seen[id] = time.Unix(unix, 0)
+	}
+	s.Sweep(time.Now())
+
+	return &s, nil
+}
+
+// Touch marks an order ID as seen now.
+func (s *OrderStore) Touch(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seen[id] = time.Now()
+	s.dirty = true
+}
+
+// Seen returns true if the order ID has been seen before, and marks it as seen now.
+func (s *OrderStore) Seen(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.seen[id]
+	s.seen[id] = time.Now()
+	s.dirty = true
+
+	return ok
+}
+
+// Len returns the number of order IDs in the store.
+func (s *OrderStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.seen)
+}
+
+// Sweep removes the order IDs which have not been seen within the TTL.
+func (s *OrderStore) Sweep(now time.Time) (removed int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, t := range s.seen {
+		if now.Sub(t) > s.ttl {
+			delete(s.seen, id)
+			removed++
+		}
+	}
+
+	if removed > 0 {
+		s.dirty = true
+	}
+
+	return removed
+}
+
+// Save writes the store to disk if it has changed.
+func (s *OrderStore) Save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+
+	stored := make(map[string]int64, len(s.seen))
+	for id, t := range s.seen {
+		stored[id] = t.Unix()
+	}
+	s.dirty = false
+	s.mu.Unlock()
+
+	if err := s.write(stored); err != nil {
+		// Try again next time
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+
+		return err
+	}
+
+	return nil
+}
+
+func (s *OrderStore) write(stored map[string]int64) error {
+	raw, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.path, raw)
+}
+
+// RunSweeper periodically expires old order IDs and saves the store until the context is done.
+func (s *OrderStore) RunSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		var now time.Time
+		select {
+		case now = <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		if removed := s.Sweep(now); removed > 0 {
+			stateLog.Debug("Expired liquidation order IDs", "removed", removed, "remaining", s.Len())
+		}
+
+		if err := s.Save(); err != nil {
+			stateLog.Error("Failed to save order store", "err", err)
+		}
+	}
+}
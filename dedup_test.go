@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOrderStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seen_orders.json")
+
+	s, err := NewOrderStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Seen("a") {
+		t.Fatal("new order reported as seen")
+	}
+	if !s.Seen("a") {
+		t.Fatal("order not remembered")
+	}
+	s.Touch("b")
+
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restarting keeps the history
+	s, err = NewOrderStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.Seen("a") || !s.Seen("b") {
+		t.Fatal("orders not persisted")
+	}
+
+	if removed := s.Sweep(time.Now().Add(2 * time.Hour)); removed != 2 || s.Len() != 0 {
+		t.Fatal("expected orders to expire", removed, s.Len())
+	}
+
+	// A corrupt file is not fatal
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if s, err = NewOrderStore(path, time.Hour); err != nil || s.Len() != 0 {
+		t.Fatal("expected an empty store", err)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// expr is a compiled arithmetic expression such as "usd / 100000".
+type expr func(vars map[string]float64) float64
+
+// exprParser is a recursive descent parser for expressions.
+//
+//	expr   = term { ("+" | "-") term }
+//	term   = factor { ("*" | "/") factor }
+//	factor = number | variable | function "(" expr { "," expr } ")" | "(" expr ")" | "-" factor
+type exprParser struct {
+	tokens []string
+	pos    int
+	vars   map[string]bool
+}
+
+// Functions available in expressions.
+var exprFuncs = map[string]func(args []float64) (float64, error){
+	"floor": func(args []float64) (float64, error) {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("floor takes 1 argument")
+		}
+		return math.Floor(args[0]), nil
+	},
+	"min": func(args []float64) (float64, error) {
+		if len(args) != 2 {
+			return 0, fmt.Errorf("min takes 2 arguments")
+		}
+		return math.Min(args[0], args[1]), nil
+	},
+	"max": func(args []float64) (float64, error) {
+		if len(args) != 2 {
+			return 0, fmt.Errorf("max takes 2 arguments")
+		}
+		return math.Max(args[0], args[1]), nil
+	},
+}
+
+// compileExpr parses an expression which may only use the given variables.
+func compileExpr(s string, vars []string) (expr, error) {
+	tokens, err := tokenizeExpr(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := exprParser{tokens: tokens, vars: make(map[string]bool)}
+	for _, v := range vars {
+		p.vars[v] = true
+	}
+
+	e, err := p.expr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", s, err)
+	}
+
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("invalid expression %q: unexpected %q", s, p.tokens[p.pos])
+	}
+
+	return e, nil
+}
+
+func tokenizeExpr(s string) (tokens []string, err error) {
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case strings.ContainsRune("+-*/(),", r):
+			tokens = append(tokens, string(r))
+			i++
+
+		case unicode.IsDigit(r) || r == '.':
+			j := i
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.' || runes[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+
+		case unicode.IsLetter(r) || r == '_':
+			j := i
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+
+		default:
+			return nil, fmt.Errorf("invalid expression %q: unexpected %q", s, r)
+		}
+	}
+
+	return tokens, nil
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *exprParser) expr() (expr, error) {
+	left, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek() == "+" || p.peek() == "-" {
+		op := p.next()
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+
+		l := left
+		if op == "+" {
+			left = func(vars map[string]float64) float64 { return l(vars) + right(vars) }
+		} else {
+			left = func(vars map[string]float64) float64 { return l(vars) - right(vars) }
+		}
+	}
+
+	return left, nil
+}
+
+func (p *exprParser) term() (expr, error) {
+	left, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek() == "*" || p.peek() == "/" {
+		op := p.next()
+		right, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+
+		l := left
+		if op == "*" {
+			left = func(vars map[string]float64) float64 { return l(vars) * right(vars) }
+		} else {
+			left = func(vars map[string]float64) float64 { return l(vars) / right(vars) }
+		}
+	}
+
+	return left, nil
+}
+
+func (p *exprParser) factor() (expr, error) {
+	t := p.next()
+	switch {
+	case t == "":
+		return nil, fmt.Errorf("unexpected end")
+
+	case t == "-":
+		f, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		return func(vars map[string]float64) float64 { return -f(vars) }, nil
+
+	case t == "(":
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		return e, nil
+
+	case unicode.IsDigit([]rune(t)[0]) || t[0] == '.':
+		v, err := strconv.ParseFloat(t, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", t)
+		}
+		return func(map[string]float64) float64 { return v }, nil
+
+	case p.peek() == "(":
+		f, ok := exprFuncs[t]
+		if !ok {
+			return nil, fmt.Errorf("unknown function %q", t)
+		}
+		p.next()
+
+		var args []expr
+		for {
+			arg, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+
+			if sep := p.next(); sep == ")" {
+				break
+			} else if sep != "," {
+				return nil, fmt.Errorf("expected , or ) in call to %v", t)
+			}
+		}
+
+		// Check the number of arguments now rather than when it is evaluated
+		if _, err := f(make([]float64, len(args))); err != nil {
+			return nil, err
+		}
+
+		return func(vars map[string]float64) float64 {
+			values := make([]float64, len(args))
+			for i, arg := range args {
+				values[i] = arg(vars)
+			}
+			v, _ := f(values)
+			return v
+		}, nil
+
+	case p.vars[t]:
+		return func(vars map[string]float64) float64 { return vars[t] }, nil
+
+	default:
+		return nil, fmt.Errorf("unknown variable %q", t)
+	}
+}
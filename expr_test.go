@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestExpr(t *testing.T) {
+	vars := map[string]float64{"usd": 250000, "streak": 3}
+
+	table := []struct {
+		Expr     string
+		Expected float64
+	}{
+		{"1", 1},
+		{"usd / 100000", 2.5},
+		{"usd / 100_000", 2.5},
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"10 - 4 - 3", 3},
+		{"-streak + 5", 2},
+		{"floor(usd / 100000)", 2},
+		{"min(streak, 2) + max(1, 0.5)", 3},
+	}
+
+	for _, v := range table {
+		e, err := compileExpr(v.Expr, []string{"usd", "streak"})
+		if err != nil {
+			t.Fatal(v.Expr, err)
+		}
+
+		if result := e(vars); result != v.Expected {
+			t.Errorf("%v: expected %v got %v", v.Expr, v.Expected, result)
+		}
+	}
+
+	for _, invalid := range []string{"", "usd +", "(1", "1)", "quantity", "sqrt(1)", "min(1)", "1 $ 2", "1 2"} {
+		if _, err := compileExpr(invalid, []string{"usd", "streak"}); err == nil {
+			t.Errorf("%q: expected an error", invalid)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FilterConfig selects which liquidations are posted, applied before combining.
+//
+// Symbol patterns are globs such as "XBT*", or regular expressions prefixed with "re:" such as "re:^(XBT|ETH)USD$".
+type FilterConfig struct {
+	// Only symbols matching one of these patterns are posted, all symbols if empty.
+	Include []string `json:"include"`
+
+	// Symbols matching one of these patterns are never posted.
+	Exclude []string `json:"exclude"`
+
+	// Only these instrument types are posted, all types if empty.
+	InstrumentTypes []InstrumentType `json:"instrument_types"`
+
+	// These instrument types are never posted.
+	ExcludeInstrumentTypes []InstrumentType `json:"exclude_instrument_types"`
+
+	// Only instruments settled in these currencies are posted, all currencies if empty.
+	SettleCurrencies []string `json:"settle_currencies"`
+
+	// Minimum USD value of a liquidation to be posted.
+	MinUSD float64 `json:"min_usd"`
+
+	// Minimum USD value for symbols matching a pattern, an exact symbol match takes precedence
+	// otherwise the highest minimum of the matching patterns is used.
+	SymbolMinUSD map[string]float64 `json:"symbol_min_usd"`
+}
+
+// symbolPattern matches symbols using a glob or regular expression.
+type symbolPattern struct {
+	raw string
+	re  *regexp.Regexp
+}
+
+// liquidationFilter is a compiled FilterConfig, which can be changed while running.
+type liquidationFilter struct {
+	mu  sync.RWMutex
+	cfg FilterConfig
+
+	include []symbolPattern
+	exclude []symbolPattern
+	minUSD  map[string]symbolPattern
+}
+
+// Metrics for the filtered liquidations, exported on /debug/vars.
+var filteredLiquidations = expvar.NewMap("filtered_liquidations")
+
+// How often the filtered liquidations are summarised in the log.
+const filterSummaryInterval = time.Hour
+
+func compileSymbolPattern(raw string) (symbolPattern, error) {
+	if expr, ok := strings.CutPrefix(raw, "re:"); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return symbolPattern{}, fmt.Errorf("invalid symbol regular expression %q: %w", raw, err)
+		}
+
+		return symbolPattern{raw: raw, re: re}, nil
+	}
+
+	if _, err := path.Match(raw, ""); err != nil {
+		return symbolPattern{}, fmt.Errorf("invalid symbol glob %q: %w", raw, err)
+	}
+
+	return symbolPattern{raw: raw}, nil
+}
+
+// Match returns true if the symbol matches the pattern.
+func (p symbolPattern) Match(symbol Symbol) bool {
+	if p.re != nil {
+		return p.re.MatchString(string(symbol))
+	}
+
+	ok, _ := path.Match(p.raw, string(symbol))
+	return ok
+}
+
+func compileSymbolPatterns(raw []string) ([]symbolPattern, error) {
+	var patterns []symbolPattern
+	for _, v := range raw {
+		p, err := compileSymbolPattern(v)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, p)
+	}
+
+	return patterns, nil
+}
+
+func matchAny(patterns []symbolPattern, symbol Symbol) bool {
+	for _, p := range patterns {
+		if p.Match(symbol) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newLiquidationFilter compiles the filter configuration.
+func newLiquidationFilter(cfg FilterConfig) (*liquidationFilter, error) {
+	f := liquidationFilter{
+		cfg: cfg,
+	}
+
+	var err error
+	if f.include, err = compileSymbolPatterns(cfg.Include); err != nil {
+		return nil, err
+	}
+
+	if f.exclude, err = compileSymbolPatterns(cfg.Exclude); err != nil {
+		return nil, err
+	}
+
+	if f.minUSD, err = compileMinUSD(cfg.SymbolMinUSD); err != nil {
+		return nil, err
+	}
+
+	return &f, nil
+}
+
+// Replace swaps in a new filter configuration.
+func (f *liquidationFilter) Replace(cfg FilterConfig) error {
+	n, err := newLiquidationFilter(cfg)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.cfg = n.cfg
+	f.include = n.include
+	f.exclude = n.exclude
+	f.minUSD = n.minUSD
+
+	return nil
+}
+
+func compileMinUSD(symbolMinUSD map[string]float64) (map[string]symbolPattern, error) {
+	patterns := make(map[string]symbolPattern)
+	for k := range symbolMinUSD {
+		p, err := compileSymbolPattern(k)
+		if err != nil {
+			return nil, err
+		}
+		patterns[k] = p
+	}
+
+	return patterns, nil
+}
+
+// Thresholds returns the minimum USD values.
+func (f *liquidationFilter) Thresholds() (minUSD float64, symbolMinUSD map[string]float64) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	symbolMinUSD = make(map[string]float64, len(f.cfg.SymbolMinUSD))
+	for k, v := range f.cfg.SymbolMinUSD {
+		symbolMinUSD[k] = v
+	}
+
+	return f.cfg.MinUSD, symbolMinUSD
+}
+
+// SetThresholds replaces the minimum USD values.
+func (f *liquidationFilter) SetThresholds(minUSD float64, symbolMinUSD map[string]float64) error {
+	if minUSD < 0 {
+		return fmt.Errorf("min_usd must not be negative, got %v", minUSD)
+	}
+
+	thresholds := make(map[string]float64, len(symbolMinUSD))
+	for pattern, v := range symbolMinUSD {
+		if v < 0 {
+			return fmt.Errorf("symbol_min_usd[%q] must not be negative, got %v", pattern, v)
+		}
+		thresholds[pattern] = v
+	}
+
+	patterns, err := compileMinUSD(thresholds)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.cfg.MinUSD = minUSD
+	f.cfg.SymbolMinUSD = thresholds
+	f.minUSD = patterns
+
+	return nil
+}
+
+// MinUSD returns the minimum USD value for a symbol.
+func (f *liquidationFilter) MinUSD(symbol Symbol) float64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.minUSDLocked(symbol)
+}
+
+func (f *liquidationFilter) minUSDLocked(symbol Symbol) float64 {
+	if v, ok := f.cfg.SymbolMinUSD[string(symbol)]; ok {
+		return v
+	}
+
+	min := f.cfg.MinUSD
+	found := false
+	for k, p := range f.minUSD {
+		if !p.Match(symbol) {
+			continue
+		}
+
+		if v := f.cfg.SymbolMinUSD[k]; !found || v > min {
+			min = v
+			found = true
+		}
+	}
+
+	return min
+}
+
+// Check returns the reason the liquidation should not be posted, or an empty string if it should be posted.
+func (f *liquidationFilter) Check(l Liquidation) string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if len(f.include) > 0 && !matchAny(f.include, l.Symbol) {
+		return "symbol_not_included"
+	}
+
+	if matchAny(f.exclude, l.Symbol) {
+		return "symbol_excluded"
+	}
+
+	if len(f.cfg.InstrumentTypes) > 0 && !containsType(f.cfg.InstrumentTypes, l.Type) {
+		return "instrument_type_not_included"
+	}
+
+	if containsType(f.cfg.ExcludeInstrumentTypes, l.Type) {
+		return "instrument_type_excluded"
+	}
+
+	if len(f.cfg.SettleCurrencies) > 0 && !containsFold(f.cfg.SettleCurrencies, l.SettleCurrency) {
+		return "settle_currency"
+	}
+
+	if l.TotalUSDValue < f.minUSDLocked(l.Symbol) {
+		return "min_usd"
+	}
+
+	return ""
+}
+
+// Allow checks a liquidation and records the result in the metrics.
+func (f *liquidationFilter) Allow(l Liquidation) (string, bool) {
+	reason := f.Check(l)
+	if reason == "" {
+		filteredLiquidations.Add("passed", 1)
+		return "", true
+	}
+
+	filteredLiquidations.Add(reason, 1)
+	return reason, false
+}
+
+// logFilterSummary logs how many liquidations passed and were filtered for each reason every interval,
+// until the context is done. Nothing is logged if there were no liquidations.
+func logFilterSummary(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := make(map[string]int64)
+	for {
+		select {
+		case <-ticker.C:
+			if counts := filterSummary(last); len(counts) > 0 {
+				pipelineLog.Info("Filtered liquidations", append([]any{"interval", interval}, counts...)...)
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// filterSummary returns the count of each reason since the last summary as log attributes, updating last.
+func filterSummary(last map[string]int64) []any {
+	var counts []any
+	filteredLiquidations.Do(func(kv expvar.KeyValue) {
+		n := kv.Value.(*expvar.Int).Value()
+		if n > last[kv.Key] {
+			counts = append(counts, kv.Key, n-last[kv.Key])
+		}
+		last[kv.Key] = n
+	})
+
+	return counts
+}
+
+func containsType(types []InstrumentType, t InstrumentType) bool {
+	for _, v := range types {
+		if v == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+
+	return false
+}
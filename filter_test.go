@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestLiquidationFilter(t *testing.T) {
+	f, err := newLiquidationFilter(FilterConfig{
+		Include:                []string{"XBT*", "re:^(ETH|SOL)USD"},
+		Exclude:                []string{"XBT*EUR"},
+		ExcludeInstrumentTypes: []InstrumentType{ITSpot},
+		SettleCurrencies:       []string{"XBT", "USDT"},
+		MinUSD:                 100,
+		SymbolMinUSD: map[string]float64{
+			"XBTUSD":  1000,
+			"XBT*":    500,
+			"re:USDT": 50,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	liq := func(symbol Symbol, usd float64, typ InstrumentType, settle string) Liquidation {
+		l := testLiquidation(symbol, "Buy", usd)
+		l.Type = typ
+		l.SettleCurrency = settle
+		return l
+	}
+
+	table := []struct {
+		Liq    Liquidation
+		Reason string
+	}{
+		{liq("XBTUSD", 1000, ITPerpetualContracts, "XBt"), ""},
+		{liq("XBTUSD", 999, ITPerpetualContracts, "XBt"), "min_usd"},
+		{liq("XBTUSDT", 500, ITPerpetualContracts, "USDt"), ""},
+		{liq("XBTUSDT", 499, ITPerpetualContracts, "USDt"), "min_usd"},
+		{liq("ETHUSDT", 50, ITPerpetualContracts, "USDt"), ""},
+		{liq("ETHUSD", 99, ITPerpetualContracts, "XBt"), "min_usd"},
+		{liq("SOLUSD", 100, ITPerpetualContracts, "XBt"), ""},
+		{liq("DOGEUSD", 100000, ITPerpetualContracts, "XBt"), "symbol_not_included"},
+		{liq("XBTEUR", 100000, ITPerpetualContracts, "XBt"), "symbol_excluded"},
+		{liq("XBT_USDT", 100000, ITSpot, "USDt"), "instrument_type_excluded"},
+		{liq("XBTUSDC", 100000, ITPerpetualContracts, "USDC"), "settle_currency"},
+	}
+
+	for _, v := range table {
+		if reason := f.Check(v.Liq); reason != v.Reason {
+			t.Errorf("%v: expected %q got %q", v.Liq.Symbol, v.Reason, reason)
+		}
+	}
+
+	if _, err := newLiquidationFilter(FilterConfig{Include: []string{"re:("}}); err == nil {
+		t.Error("expected an invalid regular expression error")
+	}
+
+	if _, err := newLiquidationFilter(FilterConfig{Exclude: []string{"XBT["}}); err == nil {
+		t.Error("expected an invalid glob error")
+	}
+}
+
+func TestFilterSetThresholds(t *testing.T) {
+	f, err := newLiquidationFilter(FilterConfig{MinUSD: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	thresholds := map[string]float64{"XBT*": 1000}
+	if err := f.SetThresholds(500, thresholds); err != nil {
+		t.Fatal(err)
+	}
+
+	// The caller's map is copied
+	thresholds["XBT*"] = 1
+	if _, current := f.Thresholds(); current["XBT*"] != 1000 {
+		t.Error("thresholds changed through the caller's map", current)
+	}
+
+	if err := f.SetThresholds(-1, nil); err == nil {
+		t.Error("expected a negative min_usd to be refused")
+	}
+
+	if err := f.SetThresholds(500, map[string]float64{"XBT*": -1}); err == nil {
+		t.Error("expected a negative symbol_min_usd to be refused")
+	}
+
+	if f.MinUSD("ETHUSD") != 500 || f.MinUSD("XBTUSD") != 1000 {
+		t.Error("thresholds changed by refused values")
+	}
+}
+
+func TestFilterSummary(t *testing.T) {
+	f, err := newLiquidationFilter(FilterConfig{MinUSD: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only what happened since the last summary is counted
+	last := make(map[string]int64)
+	filterSummary(last)
+
+	for _, usd := range []float64{50, 60, 500} {
+		f.Allow(testLiquidation("XBTUSD", "Buy", usd))
+	}
+
+	counts := fmt.Sprint(filterSummary(last))
+	if counts != "[min_usd 2 passed 1]" {
+		t.Fatal("unexpected summary", counts)
+	}
+
+	if counts := filterSummary(last); len(counts) != 0 {
+		t.Fatal("expected nothing new", counts)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Ways a segment is shortened to fit the length limit.
+const (
+	StrategyDrop      = "drop"      // Leave the segment out
+	StrategyTrim      = "trim"      // Leave medals out one at a time, lowest priority first
+	StrategyEllipsize = "ellipsize" // Cut words off the end and add an ellipsis, leaving it out if no words fit
+	StrategyCompress  = "compress"  // Summarise the fills, e.g. "3 fills totalling $360,000"
+)
+
+// FitRule shortens a segment of a post when it is over the length limit.
+type FitRule struct {
+	Segment  string `json:"segment"`
+	Strategy string `json:"strategy"`
+
+	// Rules are applied lowest priority first, in the order given if the priorities are the same.
+	Priority int `json:"priority"`
+}
+
+// defaultFitRules keep the fills and medals for as long as possible.
+var defaultFitRules = []FitRule{
+	{Segment: SegmentStreak, Strategy: StrategyDrop, Priority: 10},
+	{Segment: SegmentPriceContext, Strategy: StrategyDrop, Priority: 20},
+	{Segment: SegmentMedals, Strategy: StrategyTrim, Priority: 30},
+	{Segment: SegmentSnark, Strategy: StrategyDrop, Priority: 40},
+	{Segment: SegmentFills, Strategy: StrategyCompress, Priority: 50},
+}
+
+// Strategies each segment can be shortened with.
+var segmentStrategies = map[string][]string{
+	SegmentStreak:       {StrategyDrop, StrategyEllipsize},
+	SegmentPriceContext: {StrategyDrop},
+	SegmentMedals:       {StrategyDrop, StrategyTrim},
+	SegmentSnark:        {StrategyDrop, StrategyEllipsize},
+	SegmentFills:        {StrategyCompress},
+}
+
+// ellipsis ends text which has been cut short.
+const ellipsis = "…"
+
+// compileFitRules checks and orders the rules.
+func compileFitRules(cfg FormatConfig) ([]FitRule, error) {
+	rules := cfg.Fit
+	if rules == nil {
+		rules = defaultFitRules
+	}
+
+	for _, r := range rules {
+		strategies, ok := segmentStrategies[r.Segment]
+		if !ok {
+			return nil, fmt.Errorf("unknown segment %q", r.Segment)
+		}
+
+		if !containsString(strategies, r.Strategy) {
+			return nil, fmt.Errorf("segment %v cannot be shortened with %q, expected one of: %v", r.Segment, r.Strategy, strings.Join(strategies, ", "))
+		}
+	}
+
+	sorted := append([]FitRule(nil), rules...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority < sorted[j].Priority
+	})
+
+	return sorted, nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fit shortens the post with each rule until it fits, then cuts it off at the limit as a last resort.
+func (f *postFormatter) fit(cl CombinedLiquidation, d Decoration, data PostData, text string) string {
+	for _, rule := range f.fitRules {
+		if f.fits(text) {
+			return text
+		}
+
+		switch rule.Strategy {
+		case StrategyDrop:
+			data.setSegment(rule.Segment, "")
+			if rule.Segment == SegmentMedals {
+				data.setMedals(nil)
+			}
+
+		case StrategyTrim:
+			medals := d.Medals
+			for len(medals) > 0 && !f.fits(text) {
+				medals = medals[:len(medals)-1]
+				data.setMedals(medals)
+				text = f.Text(data)
+			}
+
+		case StrategyEllipsize:
+			text = f.ellipsize(&data, rule.Segment)
+
+		case StrategyCompress:
+			if fills := cl.fillCount(); fills > 1 {
+				data.Fills = fmt.Sprintf(f.locale.CompressedFills, fills, f.locale.Number(displayUSD(cl.USDValue())))
+				data.ShowUSD = false
+			}
+		}
+
+		text = f.Text(data)
+	}
+
+	if !f.fits(text) {
+		text = f.truncate(text)
+	}
+
+	return text
+}
+
+// ellipsize cuts words off the end of a segment until the post fits.
+func (f *postFormatter) ellipsize(data *PostData, segment string) string {
+	words := strings.Fields(data.segment(segment))
+	shortened := func(n int) string {
+		if n == 0 {
+			return ""
+		}
+		return strings.Join(words[:n], " ") + ellipsis
+	}
+
+	// Posts only get longer with more words, so search for the most words which fit
+	n := sort.Search(len(words), func(n int) bool {
+		data.setSegment(segment, shortened(n))
+		return !f.fits(f.Text(*data))
+	})
+
+	data.setSegment(segment, shortened(max(n-1, 0)))
+	return f.Text(*data)
+}
+
+// truncate cuts the text off at the length limit, with an ellipsis if there is room for one.
+// The text is only cut between graphemes, so accents and emoji sequences stay whole.
+func (f *postFormatter) truncate(text string) string {
+	runes := []rune(text)
+	ends := graphemeEnds(runes)
+
+	end := ellipsis
+	if !f.fits(end) {
+		end = ""
+	}
+
+	cut := func(n int) string {
+		return strings.TrimSpace(string(runes[:ends[n]])) + end
+	}
+
+	n := sort.Search(len(ends), func(n int) bool {
+		return !f.fits(cut(n))
+	})
+
+	if n == 0 {
+		return ""
+	}
+
+	return cut(n - 1)
+}
+
+// graphemeEnds returns where each grapheme of the text ends, approximating the Unicode rules with the emoji sequences
+// Twitter counts, combining marks and Hangul jamo.
+func graphemeEnds(runes []rune) []int {
+	var ends []int
+	for i := 0; i < len(runes); {
+		j := emojiSequenceEnd(runes, i)
+		if j == i {
+			j++
+		}
+
+		for j < len(runes) && isGraphemeExtend(runes[j]) {
+			j++
+		}
+
+		ends = append(ends, j)
+		i = j
+	}
+
+	return ends
+}
+
+// isGraphemeExtend reports whether a code point belongs to the grapheme before it.
+func isGraphemeExtend(r rune) bool {
+	switch {
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc):
+		return true
+	case r == 0x200d, r >= 0xfe00 && r <= 0xfe0f, r >= 0x1f3fb && r <= 0x1f3ff:
+		return true
+	case r >= 0x1160 && r <= 0x11ff: // Hangul vowel and trailing jamo
+		return true
+	}
+
+	return false
+}
+
+// segment returns the text of an optional segment.
+func (data *PostData) segment(segment string) string {
+	switch segment {
+	case SegmentStreak:
+		return data.Streak
+	case SegmentPriceContext:
+		return data.PriceContext
+	case SegmentSnark:
+		return data.Snark
+	case SegmentMedals:
+		return data.Medals
+	case SegmentFills:
+		return data.Fills
+	}
+
+	return ""
+}
+
+// setSegment replaces the text of an optional segment.
+func (data *PostData) setSegment(segment, text string) {
+	switch segment {
+	case SegmentStreak:
+		data.Streak = text
+	case SegmentPriceContext:
+		data.PriceContext = text
+	case SegmentSnark:
+		data.Snark = text
+	case SegmentMedals:
+		data.Medals = text
+	case SegmentFills:
+		data.Fills = text
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+func TestFitStrategies(t *testing.T) {
+	cl := testLiquidation("XBTUSD", "Sell", 100000).ToCombined()
+	for i := 0; i < 5; i++ {
+		cl.Liquidations = append(cl.Liquidations, testLiquidation("XBTUSD", "Sell", 100000).PriceQuantity)
+	}
+
+	d := Decoration{
+		Medals: []Medal{{Name: "100k", Emoji: "\U0001F4AF"}},
+		Snark:  "the quick brown fox jumps over the lazy dog",
+	}
+
+	f, err := newPostFormatter(FormatConfig{
+		Fit: []FitRule{
+			{Segment: SegmentFills, Strategy: StrategyCompress, Priority: 2},
+			{Segment: SegmentSnark, Strategy: StrategyEllipsize, Priority: 1},
+		},
+	}, englishLocale)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table := []struct {
+		MaxLength int
+		Expected  string
+	}{
+		{-1, "Liquidated long on XBTUSD: Sell 100,000 + 100,000 + 100,000 + 100,000 + 100,000 + 100,000 @ 50,000 \U0001F4AF ~ the quick brown fox jumps over the lazy dog"},
+		{120, "Liquidated long on XBTUSD: Sell 100,000 + 100,000 + 100,000 + 100,000 + 100,000 + 100,000 @ 50,000 \U0001F4AF ~ the quick…"},
+		{100, "Liquidated long on XBTUSD: Sell 6 fills totalling $600,000 \U0001F4AF"},
+	}
+
+	for _, v := range table {
+		f.maxLength = v.MaxLength
+		if s := f.Render(cl, d); s != v.Expected {
+			t.Errorf("%v: expected %q got %q", v.MaxLength, v.Expected, s)
+		}
+	}
+}
+
+// randomPost is a liquidation and decoration generated for property tests.
+type randomPost struct {
+	Liquidation CombinedLiquidation
+	Decoration  Decoration
+	MaxLength   int
+}
+
+// Generate implements quick.Generator.
+func (randomPost) Generate(r *rand.Rand, size int) reflect.Value {
+	words := []string{"rekt", "\U0001F4AF", "\U0001F468‍\U0001F469‍\U0001F467", "清算", "ñ", "https://bitmex.com", "\U0001F1EF\U0001F1F5", "kill"}
+	text := func(n int) string {
+		var parts []string
+		for i := 0; i < n; i++ {
+			parts = append(parts, words[r.Intn(len(words))])
+		}
+		return strings.Join(parts, " ")
+	}
+
+	symbols := []Symbol{"XBTUSD", "ETHUSD", "XBTUSDT"}
+	side := []string{"Buy", "Sell"}[r.Intn(2)]
+
+	cl := testLiquidation(symbols[r.Intn(len(symbols))], side, 1+r.Float64()*1e6).ToCombined()
+	for i := r.Intn(size + 1); i > 0; i-- {
+		cl.Liquidations = append(cl.Liquidations, testLiquidation(cl.Symbol, side, 1+r.Float64()*1e6).PriceQuantity)
+	}
+
+	var medals []Medal
+	for i := r.Intn(10); i > 0; i-- {
+		medals = append(medals, Medal{Name: "medal", Emoji: words[r.Intn(len(words))]})
+	}
+
+	return reflect.ValueOf(randomPost{
+		Liquidation: cl,
+		Decoration: Decoration{
+			PriceContext: "(-4.2% in 15m)",
+			Medals:       medals,
+			Streak:       text(r.Intn(4)),
+			Snark:        text(r.Intn(size + 1)),
+		},
+		MaxLength: 1 + r.Intn(300),
+	})
+}
+
+func TestRenderWithinLimit(t *testing.T) {
+	formatters := map[string]*postFormatter{}
+	for name, cfg := range map[string]FormatConfig{
+		"default": {},
+		"ellipsize": {Fit: []FitRule{
+			{Segment: SegmentSnark, Strategy: StrategyEllipsize},
+			{Segment: SegmentStreak, Strategy: StrategyEllipsize},
+		}},
+		"none": {Fit: []FitRule{}},
+	} {
+		for _, locale := range locales {
+			f, err := newPostFormatter(cfg, locale)
+			if err != nil {
+				t.Fatal(err)
+			}
+			formatters[name+"/"+locale.Name] = f
+		}
+	}
+
+	for name, f := range formatters {
+		// Never over the limit
+		err := quick.Check(func(p randomPost) bool {
+			f.maxLength = p.MaxLength
+			s := f.Render(p.Liquidation, p.Decoration)
+			if postLength(s) > p.MaxLength {
+				t.Logf("%v: %v > %v: %q", name, postLength(s), p.MaxLength, s)
+				return false
+			}
+			return true
+		}, nil)
+		if err != nil {
+			t.Error(name, err)
+		}
+
+		// Posts which already fit are untouched
+		err = quick.Check(func(p randomPost) bool {
+			f.maxLength = -1
+			full := f.Render(p.Liquidation, p.Decoration)
+
+			f.maxLength = postLength(full)
+			return f.Render(p.Liquidation, p.Decoration) == full
+		}, nil)
+		if err != nil {
+			t.Error(name, err)
+		}
+	}
+}
+
+func TestTruncateGraphemes(t *testing.T) {
+	f, err := newPostFormatter(FormatConfig{}, englishLocale)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	family := "\U0001F468‍\U0001F469‍\U0001F467"
+	table := []struct {
+		Text      string
+		MaxLength int
+		Expected  string
+	}{
+		{"cafe\u0301 rekt", 6, "cafe\u0301…"},
+		{"cafe\u0301 rekt", 5, "caf…"},
+		{"rekt " + family + family, 9, "rekt " + family + "…"},
+		{"rekt " + family + family, 8, "rekt…"},
+		{"\U0001F1EF\U0001F1F5\U0001F1FA\U0001F1F8", 5, "\U0001F1EF\U0001F1F5…"},
+	}
+
+	for _, v := range table {
+		f.maxLength = v.MaxLength
+		if s := f.truncate(v.Text); s != v.Expected {
+			t.Errorf("%q at %v: expected %q got %q", v.Text, v.MaxLength, v.Expected, s)
+		}
+	}
+}
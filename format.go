@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+)
+
+// Parts of a post which can be shortened to fit the length limit.
+const (
+	SegmentStreak       = "streak"
+	SegmentPriceContext = "price_context"
+	SegmentMedals       = "medals"
+	SegmentSnark        = "snark"
+	SegmentFills        = "fills"
+)
+
+// DefaultPostTemplate is the original wording, e.g.
+// "Liquidated short on XBTUSD: Buy 130,170 @ 772.02 (-4.2% in 15m) 🏅💯 ~ Double kill ~ snark".
+const DefaultPostTemplate = `Liquidated {{.Position}} on {{.Symbol}}: {{.Side}} {{.Fills}}{{if .ShowUSD}} (≈ ${{.USD}}){{end}}` +
+	postDecorationsTemplate
+
+// defaultPostFormatter formats posts when a publisher has no template of its own.
+var defaultPostFormatter = func() *postFormatter {
+	f, err := newPostFormatter(FormatConfig{}, englishLocale)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}()
+
+// Functions available in post templates.
+var postTemplateFuncs = template.FuncMap{
+	"usd":   displayUSD,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"join":  strings.Join,
+}
+
+type (
+	// FormatConfig controls how a publisher formats liquidations.
+	FormatConfig struct {
+		// text/template executed with PostData, defaults to DefaultPostTemplate.
+		Template string `json:"template"`
+
+		// Maximum length of a post as counted by the publisher, defaults to 280, -1 for no limit.
+		MaxLength int `json:"max_length"`
+
+		// How segments are shortened until the post fits, defaults to dropping the streak, price context,
+		// medals one at a time and snark, then compressing the fills. An empty list shortens nothing.
+		// Posts are cut off at the limit as a last resort.
+		Fit []FitRule `json:"fit"`
+	}
+
+	// PostData is what post templates are executed with.
+	PostData struct {
+		Position string // Position that was liquidated, "long" or "short" in English
+		Symbol   string // Symbol, or the underlying if several contracts were liquidated
+		Side     string // Side of the liquidation order, "Buy" or "Sell" in English
+		Currency string // Currency the quantities are in, e.g. "USD", "XBT" or "Cont"
+
+		Fills      string      // Quantities and prices of each contract, e.g. "100 + 200 Cont @ 772.02, 734.01"
+		Quantities string      // Quantities of the primary contract, e.g. "100 + 200"
+		Prices     string      // Prices of the primary contract, e.g. "772.02, 734.01", or one price if they are all the same
+		Contracts  []PostFills // Each contract liquidated, starting with the primary symbol
+		Grouped    bool        // Several contracts with the same underlying were liquidated
+
+		USDValue float64 // Total USD value
+		USD      string  // Formatted total USD value, e.g. "1,200,000"
+		ShowUSD  bool    // The USD value is not obvious from the quantities
+
+		PriceContext string   // Recent price move, e.g. "(-4.2% in 15m)"
+		Medals       string   // Medal emojis, highest priority first
+		MedalNames   []string // Medal names, highest priority first
+		Streak       string   // Kill streak, e.g. "Double kill"
+		Snark        string   // Snarky meme text
+	}
+
+	// PostFills are the liquidations of one contract.
+	PostFills struct {
+		Symbol     string
+		Currency   string
+		Quantities string
+		Prices     string
+		USDValue   float64
+	}
+
+	// postFormatter is a compiled FormatConfig.
+	postFormatter struct {
+		locale    Locale
+		tmpl      *template.Template
+		maxLength int
+		fitRules  []FitRule
+
+		// Length of a post as counted by the publisher
+		length func(string) int
+	}
+)
+
+// newPostFormatter compiles and checks the format for a locale.
+func newPostFormatter(cfg FormatConfig, locale Locale) (*postFormatter, error) {
+	text := cfg.Template
+	if text == "" {
+		text = locale.Template
+	}
+
+	tmpl, err := template.New("post").Funcs(postTemplateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	fitRules, err := compileFitRules(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	f := postFormatter{
+		locale:    locale,
+		tmpl:      tmpl,
+		maxLength: cfg.MaxLength,
+		fitRules:  fitRules,
+		length:    postLength,
+	}
+
+	if f.maxLength == 0 {
+		f.maxLength = twitterLengthLimit
+	}
+
+	// Catch references to fields which do not exist now rather than when posting
+	sample := CombinedLiquidation{
+		Symbol:       "XBTUSD",
+		Side:         "Sell",
+		Liquidations: []PriceQuantity{{Price: 60000, Quantity: 1000, Currency: "USD", TotalUSDValue: 1000}},
+	}
+	if err := f.tmpl.Execute(&bytes.Buffer{}, f.data(sample, Decoration{})); err != nil {
+		return nil, err
+	}
+
+	return &f, nil
+}
+
+// data builds the template data for a liquidation in the formatter's locale.
+func (f *postFormatter) data(cl CombinedLiquidation, d Decoration) PostData {
+	loc := f.locale
+	data := PostData{
+		Position:   loc.Position(cl.Side),
+		Symbol:     cl.Name(),
+		Side:       loc.Side(cl.Side),
+		Currency:   cl.Liquidations[0].Currency,
+		Fills:      cl.contractFills(loc),
+		Quantities: cl.quantities(loc),
+		Prices:     cl.prices(loc),
+		Grouped:    len(cl.Related) > 0,
+
+		USDValue: cl.USDValue(),
+		USD:      loc.Number(displayUSD(cl.USDValue())),
+
+		PriceContext: d.PriceContext,
+		Streak:       d.Streak,
+		Snark:        d.Snark,
+	}
+
+	if loc.Name != englishLocale.Name {
+		if d.PriceContext != "" {
+			data.PriceContext = loc.FormatPriceMove(cl.PriceMove)
+		}
+
+		// Fall back to no streak or snark rather than English ones
+		lt := d.Localized[loc.Name]
+		data.Streak = lt.Streak
+		data.Snark = lt.Snark
+	}
+
+	data.ShowUSD = (data.Grouped || !cl.quotedInUSD()) && data.USDValue >= epsilon
+
+	for _, c := range cl.Contracts() {
+		data.Contracts = append(data.Contracts, PostFills{
+			Symbol:     string(c.Symbol),
+			Currency:   c.Liquidations[0].Currency,
+			Quantities: c.quantities(loc),
+			Prices:     c.prices(loc),
+			USDValue:   c.USDValue(),
+		})
+	}
+
+	data.setMedals(d.Medals)
+	return data
+}
+
+func (data *PostData) setMedals(medals []Medal) {
+	data.Medals = ""
+	data.MedalNames = nil
+	for _, m := range medals {
+		data.Medals += m.Emoji
+		data.MedalNames = append(data.MedalNames, m.Name)
+	}
+}
+
+// Text executes the template, falling back to the default template if it fails.
+func (f *postFormatter) Text(data PostData) string {
+	var buf bytes.Buffer
+	if err := f.tmpl.Execute(&buf, data); err != nil {
+		if f == defaultPostFormatter {
+			panic(err)
+		}
+
+		publisherLog.Error("Failed to execute post template, using the default", "err", err)
+		return defaultPostFormatter.Text(data)
+	}
+
+	return strings.TrimSpace(buf.String())
+}
+
+// fits returns true if the text is within the length limit.
+func (f *postFormatter) fits(text string) bool {
+	return f.maxLength < 0 || f.length(text) <= f.maxLength
+}
+
+// RenderCascade formats a cascade in the formatter's locale, cut off at the length limit if it is too long.
+func (f *postFormatter) RenderCascade(c Cascade) string {
+	text := f.locale.FormatCascade(c)
+	if !f.fits(text) {
+		text = f.truncate(text)
+	}
+
+	return text
+}
+
+// RenderCard draws the image card of a liquidation and its alt text in the locale, nil if it can't be drawn.
+func (f *postFormatter) RenderCard(cl CombinedLiquidation, d Decoration) ([]byte, string) {
+	card, err := RenderCard(f.locale, cl, d)
+	if err != nil {
+		publisherLog.Error("Failed to render card", "symbol", cl.Symbol, "err", err)
+		return nil, ""
+	}
+
+	return card, CardAltText(f.locale, cl, d)
+}
+
+// Render formats a decorated liquidation, shortening it until it fits in the length limit.
+func (f *postFormatter) Render(cl CombinedLiquidation, d Decoration) string {
+	data := f.data(cl, d)
+	return f.fit(cl, d, data, f.Text(data))
+}
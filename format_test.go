@@ -0,0 +1,158 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDefaultPostTemplate(t *testing.T) {
+	usd := testLiquidation("XBTUSD", "Buy", 130170).ToCombined()
+	usd.Liquidations[0].Price = 772.02
+	usd.Liquidations[0].MinTick = 0.01
+	usd.Liquidations = append(usd.Liquidations, PriceQuantity{Price: 734.01, Quantity: 123450, Currency: "USD", TotalUSDValue: 123450, MinTick: 0.01})
+
+	coins := testLiquidation("ETHUSD", "Sell", 4500).ToCombined()
+	coins.Liquidations[0].Quantity = 100
+	coins.Liquidations[0].Currency = "Cont"
+
+	table := []struct {
+		Liquidation CombinedLiquidation
+		Expected    string
+	}{
+		{usd, "Liquidated short on XBTUSD: Buy 130,170 + 123,450 @ 772.02, 734.01"},
+		{coins, "Liquidated long on ETHUSD: Sell 100 Cont @ 50,000 (≈ $4,500)"},
+	}
+
+	for _, v := range table {
+		if s := v.Liquidation.String(); s != v.Expected {
+			t.Errorf("expected %q got %q", v.Expected, s)
+		}
+	}
+
+	d := Decoration{
+		PriceContext: "(-4.2% in 15m)",
+		Medals:       []Medal{{Name: "week", Emoji: "\U0001F3C5"}, {Name: "100k", Emoji: "\U0001F4AF"}},
+		Streak:       "Double kill",
+		Snark:        "Rekt",
+	}
+
+	expected := "Liquidated short on XBTUSD: Buy 130,170 + 123,450 @ 772.02, 734.01 (-4.2% in 15m) \U0001F3C5\U0001F4AF ~ Double kill ~ Rekt"
+	if s := defaultPostFormatter.Render(usd, d); s != expected {
+		t.Errorf("expected %q got %q", expected, s)
+	}
+}
+
+func TestPostFormatterFitting(t *testing.T) {
+	cl := testLiquidation("XBTUSD", "Sell", 250000).ToCombined()
+	d := Decoration{
+		PriceContext: "(-4.2% in 15m)",
+		Medals:       []Medal{{Name: "week", Emoji: "W"}, {Name: "month", Emoji: "M"}, {Name: "100k", Emoji: "H"}},
+		Streak:       "Triple kill",
+		Snark:        "Rekt",
+	}
+
+	f, err := newPostFormatter(FormatConfig{
+		Template:  `{{.Position}} {{.USD}}{{with .PriceContext}} {{.}}{{end}}{{with .Medals}} {{.}}{{end}}{{with .Streak}} {{.}}{{end}}{{with .Snark}} {{.}}{{end}}`,
+		MaxLength: 40,
+	}, englishLocale)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table := []struct {
+		MaxLength int
+		Expected  string
+	}{
+		{-1, "long 250,000 (-4.2% in 15m) WMH Triple kill Rekt"},
+		{40, "long 250,000 (-4.2% in 15m) WMH Rekt"},
+		{25, "long 250,000 WMH Rekt"},
+		{19, "long 250,000 W Rekt"},
+		{15, "long 250,000"},
+		{5, "lon…"}, // Cut off as a last resort
+	}
+
+	for _, v := range table {
+		f.maxLength = v.MaxLength
+		if s := f.Render(cl, d); s != v.Expected {
+			t.Errorf("%v: expected %q got %q", v.MaxLength, v.Expected, s)
+		}
+	}
+
+	// Custom drop order
+	f, err = newPostFormatter(FormatConfig{
+		Template:  `{{.Symbol}}{{with .Snark}} {{.}}{{end}}{{with .Streak}} {{.}}{{end}}`,
+		MaxLength: 20,
+		Fit: []FitRule{
+			{Segment: SegmentSnark, Strategy: StrategyDrop},
+			{Segment: SegmentStreak, Strategy: StrategyDrop},
+		},
+	}, englishLocale)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := f.Render(cl, d); s != "XBTUSD Triple kill" {
+		t.Error("unexpected", s)
+	}
+}
+
+func TestPostFormatterInvalid(t *testing.T) {
+	table := map[string]FormatConfig{
+		"syntax":        {Template: "{{.Symbol"},
+		"unknown field": {Template: "{{.Ticker}}"},
+		"unknown func":  {Template: "{{.Symbol | shout}}"},
+		"bad segment":   {Fit: []FitRule{{Segment: "everything", Strategy: StrategyDrop}}},
+		"bad strategy":  {Fit: []FitRule{{Segment: SegmentPriceContext, Strategy: StrategyEllipsize}}},
+	}
+
+	for name, cfg := range table {
+		if _, err := newPostFormatter(cfg, englishLocale); err == nil {
+			t.Errorf("%v: expected an error", name)
+		}
+	}
+}
+
+func TestPostLength(t *testing.T) {
+	table := map[string]int{
+		"abc":                   3,
+		"≈ $1,000":              9,
+		"\U0001F3C5\U0001F4AF":  4,
+		"“quoted”":              8,
+		strings.Repeat("a", 10): 10,
+
+		// Emoji sequences
+		"\U0001F1EF\U0001F1F5": 2, // Flag
+		"\U0001F44D\U0001F3FD": 2, // Skin tone
+		"\u2764\uFE0F":         2, // Variation selector
+		"1\uFE0F\u20E3":        2, // Keycap
+		"\u00A9\uFE0F \u00A9":  4,
+		"a\uFE0F":              3, // Only emoji take the emoji presentation
+		"\u2194\uFE0F":         2,
+		"\U0001F468\u200D\U0001F469\u200D\U0001F467\u200D\U0001F466": 2, // ZWJ family
+		"\U0001F3F3\uFE0F\u200D\U0001F308 rekt":                      7,
+
+		// Normalised to NFC
+		"\u00E9":  1,
+		"e\u0301": 1,
+		"か\u3099": 2,
+
+		// Links
+		"https://www.bitmex.com/app/trade/XBTUSD": 23,
+		"see www.bitmex.com.":                     28,
+		"a http://x.co b":                         27,
+		"bitmex.com/app/trade/XBTUSD":             23,
+		"via t.co":                                27,
+		"example.io is down":                      18,
+		"example.io/status":                       23,
+		"docs.example.io":                         23,
+		"XBTUSD.PERP 59,000.50 @ 4.2x":            28,
+		"me@bitmex.com":                           13,
+		"not.a.tld":                               9,
+	}
+
+	for text, expected := range table {
+		if n := postLength(text); n != expected {
+			t.Errorf("%q: expected %v got %v", text, expected, n)
+		}
+	}
+}
@@ -6,6 +6,7 @@ require (
 	github.com/dustin/go-humanize v1.0.1
 	github.com/gorilla/websocket v1.5.1
 	github.com/michimani/gotwi v0.16.1
+	golang.org/x/text v0.13.0
 	golang.org/x/time v0.5.0
 	gopkg.in/guregu/null.v4 v4.0.0
 )
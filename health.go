@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HealthConfig controls when /healthz and /readyz report the bot as unhealthy.
+type HealthConfig struct {
+	// Address to serve /healthz and /readyz on, they are served on debug_listen if empty.
+	Listen string `json:"listen"`
+
+	// How long the BitMex websocket may be disconnected, defaults to 2m.
+	MaxDisconnected Duration `json:"max_disconnected"`
+
+	// How long without an instrument update before the USD values are out of date, defaults to 2m.
+	MaxInstrumentAge Duration `json:"max_instrument_age"`
+
+	// Posts a publisher may have queued up waiting for its rate limit, defaults to 100.
+	MaxQueued int `json:"max_queued"`
+
+	// Publishes in a row which may fail, defaults to 3.
+	MaxFailures int `json:"max_failures"`
+}
+
+// Health check defaults.
+const (
+	defaultMaxDisconnected  = 2 * time.Minute
+	defaultMaxInstrumentAge = 2 * time.Minute
+	defaultMaxQueued        = 100
+	defaultMaxFailures      = 3
+)
+
+// withDefaults fills in the thresholds which are not set.
+func (cfg HealthConfig) withDefaults() HealthConfig {
+	if cfg.MaxDisconnected == 0 {
+		cfg.MaxDisconnected = Duration(defaultMaxDisconnected)
+	}
+	if cfg.MaxInstrumentAge == 0 {
+		cfg.MaxInstrumentAge = Duration(defaultMaxInstrumentAge)
+	}
+	if cfg.MaxQueued == 0 {
+		cfg.MaxQueued = defaultMaxQueued
+	}
+	if cfg.MaxFailures == 0 {
+		cfg.MaxFailures = defaultMaxFailures
+	}
+
+	return cfg
+}
+
+// Health statuses.
+const (
+	healthOK        = "ok"
+	healthUnhealthy = "unhealthy"
+)
+
+type (
+	// healthMonitor keeps track of the BitMex connection and instrument updates, and checks them and the publishers.
+	healthMonitor struct {
+		cfg        HealthConfig
+		publishers *publisherSet
+		now        func() time.Time
+		started    time.Time
+
+		mu          sync.Mutex
+		connected   bool
+		since       time.Time // When the websocket last connected or disconnected, or when the bot started
+		instruments time.Time // Last instrument partial or update
+	}
+
+	// healthReport is the body of /healthz and /readyz.
+	healthReport struct {
+		Status      string            `json:"status"`
+		Feed        feedHealth        `json:"feed"`
+		Instruments instrumentHealth  `json:"instruments"`
+		Publishers  []publisherHealth `json:"publishers"`
+	}
+
+	// feedHealth is the status of the BitMex websocket.
+	feedHealth struct {
+		Status    string    `json:"status"`
+		Problem   string    `json:"problem,omitempty"`
+		Connected bool      `json:"connected"`
+		Since     time.Time `json:"since"`
+	}
+
+	// instrumentHealth is the status of the instrument table the USD values are worked out from.
+	instrumentHealth struct {
+		Status     string    `json:"status"`
+		Problem    string    `json:"problem,omitempty"`
+		LastUpdate time.Time `json:"last_update"`
+	}
+
+	// publisherHealth is the status of a publisher.
+	publisherHealth struct {
+		Name      string `json:"name"`
+		Status    string `json:"status"`
+		Problem   string `json:"problem,omitempty"`
+		Queued    int    `json:"queued"`
+		Failures  int    `json:"failures"`
+		LastError string `json:"last_error,omitempty"`
+	}
+)
+
+func newHealthMonitor(cfg HealthConfig, publishers *publisherSet) *healthMonitor {
+	return &healthMonitor{
+		cfg:        cfg.withDefaults(),
+		publishers: publishers,
+		now:        time.Now,
+		started:    time.Now(),
+		since:      time.Now(),
+	}
+}
+
+// Connected records that the websocket is connected, a nil monitor does nothing.
+func (h *healthMonitor) Connected() {
+	h.setConnected(true)
+}
+
+// Disconnected records that the websocket was lost.
+func (h *healthMonitor) Disconnected() {
+	h.setConnected(false)
+}
+
+func (h *healthMonitor) setConnected(connected bool) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.connected != connected {
+		h.connected = connected
+		h.since = h.now()
+	}
+}
+
+// InstrumentsUpdated records an instrument partial or update.
+func (h *healthMonitor) InstrumentsUpdated() {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.instruments = h.now()
+}
+
+// Check reports the status of every component. Ready also requires the websocket to be connected and the
+// instruments loaded right now, while healthy allows for reconnecting up to the thresholds.
+func (h *healthMonitor) Check(ready bool) healthReport {
+	h.mu.Lock()
+	now := h.now()
+	connected, since, instruments := h.connected, h.since, h.instruments
+	h.mu.Unlock()
+
+	report := healthReport{
+		Status:      healthOK,
+		Feed:        feedHealth{Status: healthOK, Connected: connected, Since: since},
+		Instruments: instrumentHealth{Status: healthOK, LastUpdate: instruments},
+		Publishers:  []publisherHealth{},
+	}
+
+	unhealthy := func(status, problem *string, format string, args ...any) {
+		*status = healthUnhealthy
+		*problem = fmt.Sprintf(format, args...)
+		report.Status = healthUnhealthy
+	}
+
+	maxDisconnected := time.Duration(h.cfg.MaxDisconnected)
+	switch down := now.Sub(since).Round(time.Second); {
+	case connected:
+	case down > maxDisconnected:
+		unhealthy(&report.Feed.Status, &report.Feed.Problem, "disconnected for %v, more than %v", down, maxDisconnected)
+	case ready:
+		unhealthy(&report.Feed.Status, &report.Feed.Problem, "not connected")
+	}
+
+	// Before the first update the instruments are as old as the bot
+	maxAge := time.Duration(h.cfg.MaxInstrumentAge)
+	updated := instruments
+	if updated.IsZero() {
+		updated = h.started
+	}
+
+	switch age := now.Sub(updated).Round(time.Second); {
+	case age > maxAge && instruments.IsZero():
+		unhealthy(&report.Instruments.Status, &report.Instruments.Problem, "not loaded after %v", age)
+	case age > maxAge:
+		unhealthy(&report.Instruments.Status, &report.Instruments.Problem, "no update for %v, more than %v", age, maxAge)
+	case ready && instruments.IsZero():
+		unhealthy(&report.Instruments.Status, &report.Instruments.Problem, "not loaded yet")
+	}
+
+	for _, w := range h.publishers.List() {
+		_, queued := w.Held()
+		failures, lastErr := w.Failures()
+
+		p := publisherHealth{Name: w.name, Status: healthOK, Queued: queued, Failures: failures, LastError: lastErr}
+		switch {
+		case failures >= h.cfg.MaxFailures:
+			unhealthy(&p.Status, &p.Problem, "the last %v publishes failed", failures)
+		case queued > h.cfg.MaxQueued:
+			unhealthy(&p.Status, &p.Problem, "%v posts queued, more than %v", queued, h.cfg.MaxQueued)
+		}
+
+		report.Publishers = append(report.Publishers, p)
+	}
+
+	return report
+}
+
+// Handler returns /healthz and /readyz, which respond with 503 when unhealthy.
+func (h *healthMonitor) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) { h.serve(w, false) })
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) { h.serve(w, true) })
+
+	return mux
+}
+
+func (h *healthMonitor) serve(w http.ResponseWriter, ready bool) {
+	report := h.Check(ready)
+
+	status := http.StatusOK
+	if report.Status != healthOK {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, status, report)
+}
+
+// runHealth serves the health checks on their own listener until the context is done.
+func runHealth(ctx context.Context, addr string, h *healthMonitor) {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           h.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	mainLog.Info("Listening (health)", "addr", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		mainLog.Error("Health listener stopped", "err", err)
+	}
+}
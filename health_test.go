@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// failingPublisher fails every publish until it is fixed.
+type failingPublisher struct {
+	fixed bool
+}
+
+func (p *failingPublisher) Publish(ctx context.Context, post preparedTweet) error {
+	if p.fixed {
+		return nil
+	}
+
+	return errors.New("service unavailable")
+}
+
+func TestHealth(t *testing.T) {
+	publisher := &failingPublisher{}
+	w := newPublisherWorker("test", publisher, nil, nil)
+	w.limiter = rate.NewLimiter(rate.Inf, 50)
+
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+
+	h := newHealthMonitor(HealthConfig{MaxQueued: 2}, newPublisherSet([]*publisherWorker{w}))
+	h.now = func() time.Time { return now }
+	h.started, h.since = start, start
+
+	check := func(ready bool, expected int) healthReport {
+		t.Helper()
+
+		rec := httptest.NewRecorder()
+		path := "/healthz"
+		if ready {
+			path = "/readyz"
+		}
+		h.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+		if rec.Code != expected {
+			t.Fatalf("%v: expected %v, got %v: %v", path, expected, rec.Code, rec.Body.String())
+		}
+
+		var report healthReport
+		if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+			t.Fatal(err)
+		}
+
+		return report
+	}
+
+	// Starting up is healthy but not ready
+	check(false, http.StatusOK)
+	if report := check(true, http.StatusServiceUnavailable); report.Feed.Problem != "not connected" || report.Instruments.Problem != "not loaded yet" {
+		t.Fatalf("unexpected problems %+v", report)
+	}
+
+	h.Connected()
+	h.InstrumentsUpdated()
+	check(true, http.StatusOK)
+
+	// Reconnecting is fine for a while
+	now = now.Add(time.Minute)
+	h.Disconnected()
+	now = now.Add(30 * time.Second)
+	check(false, http.StatusOK)
+	check(true, http.StatusServiceUnavailable)
+
+	now = now.Add(2 * time.Minute)
+	report := check(false, http.StatusServiceUnavailable)
+	if report.Feed.Status != healthUnhealthy || report.Instruments.Status != healthUnhealthy || report.Publishers[0].Status != healthOK {
+		t.Fatalf("expected the feed and instruments to be unhealthy %+v", report)
+	}
+
+	// Connected, but the instruments have stopped updating
+	h.Connected()
+	if report := check(false, http.StatusServiceUnavailable); report.Feed.Status != healthOK || report.Instruments.Status != healthUnhealthy {
+		t.Fatalf("expected the instruments to be stale %+v", report)
+	}
+
+	h.InstrumentsUpdated()
+	check(true, http.StatusOK)
+
+	// Publishes failing in a row, a success resets the count
+	for i := 0; i < defaultMaxFailures; i++ {
+		w.publish(context.Background(), preparedTweet{status: "Liquidated"})
+	}
+
+	report = check(false, http.StatusServiceUnavailable)
+	if p := report.Publishers[0]; p.Failures != defaultMaxFailures || p.LastError != "service unavailable" {
+		t.Fatalf("expected the failures to be reported %+v", p)
+	}
+
+	publisher.fixed = true
+	w.publish(context.Background(), preparedTweet{status: "Liquidated"})
+	check(true, http.StatusOK)
+
+	// The queue backing up
+	for i := 0; i < 3; i++ {
+		w.queue <- preparedTweet{}
+	}
+
+	if report := check(false, http.StatusServiceUnavailable); report.Publishers[0].Queued != 3 {
+		t.Fatalf("expected the queue to be reported %+v", report)
+	}
+}
+
+func TestValidateHealth(t *testing.T) {
+	cfg := BotConfig{
+		BitMexHost: "www.bitmex.com",
+		Health:     HealthConfig{Listen: "localhost:6060", MaxFailures: -1},
+	}
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected the health config to be invalid")
+	}
+
+	for _, expected := range []string{"health.listen", "health.max_failures"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Fatalf("expected %q in %v", expected, err)
+		}
+	}
+}
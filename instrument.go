@@ -5,6 +5,7 @@ import (
 	"math"
 	"sort"
 	"strings"
+	"time"
 
 	"gopkg.in/guregu/null.v4"
 )
@@ -56,27 +57,118 @@ type Instrument struct {
 
 // InstrumentTable of all instruments, used for queries and contract value calculations.
 type InstrumentTable struct {
-	insts map[Symbol]Instrument
+	insts   map[Symbol]Instrument
+	history priceHistory
 }
 
+// priceHistory is the recent prices of each instrument. It is kept across reconnects, while the instrument table
+// is loaded again from each partial.
+type priceHistory map[Symbol][]pricePoint
+
+// pricePoint is a sample of an instrument's price.
+type pricePoint struct {
+	Time  time.Time
+	Price float64
+}
+
+// Price history is kept for a short while, sampled at a fixed resolution to bound memory.
+const (
+	priceHistoryRetention  = 2 * time.Hour
+	priceHistoryResolution = 10 * time.Second
+)
+
 // NewInstrumentTable creates a new instrument table.
 func NewInstrumentTable(insts []Instrument) *InstrumentTable {
+	return newInstrumentTable(insts, make(priceHistory))
+}
+
+// newInstrumentTable creates an instrument table which adds to an existing price history.
+func newInstrumentTable(insts []Instrument, history priceHistory) *InstrumentTable {
 	table := InstrumentTable{
-		insts: make(map[Symbol]Instrument),
+		insts:   make(map[Symbol]Instrument),
+		history: history,
 	}
 
+	now := time.Now()
 	for _, v := range insts {
 		table.insts[v.Symbol] = v
+		table.record(v, now)
 	}
 
 	return &table
 }
 
+// record adds the current price of an instrument to its price history.
+func (it *InstrumentTable) record(inst Instrument, now time.Time) {
+	it.history.record(inst, now)
+}
+
+func (h priceHistory) record(inst Instrument, now time.Time) {
+	price := inst.LastPrice
+	if !price.Valid {
+		price = inst.MarkPrice
+	}
+
+	if !price.Valid || price.Float64 <= 0 {
+		return
+	}
+
+	history := h[inst.Symbol]
+
+	// Overwrite the latest sample if it is within the resolution
+	if n := len(history); n > 1 && now.Sub(history[n-2].Time) < priceHistoryResolution {
+		history[n-1] = pricePoint{now, price.Float64}
+	} else {
+		history = append(history, pricePoint{now, price.Float64})
+	}
+
+	// Prune expired samples
+	cutoff := now.Add(-priceHistoryRetention)
+	expired := 0
+	for expired < len(history)-1 && history[expired].Time.Before(cutoff) {
+		expired++
+	}
+
+	h[inst.Symbol] = history[expired:]
+}
+
+// PriceChange returns the percentage move of a symbol's price over the window.
+// Returns false if there is not enough history to cover the window.
+func (it *InstrumentTable) PriceChange(symbol Symbol, window time.Duration) (float64, bool) {
+	return it.priceChangeAt(symbol, window, time.Now())
+}
+
+func (it *InstrumentTable) priceChangeAt(symbol Symbol, window time.Duration, now time.Time) (float64, bool) {
+	return it.history.changeAt(symbol, window, now)
+}
+
+func (h priceHistory) changeAt(symbol Symbol, window time.Duration, now time.Time) (float64, bool) {
+	history := h[symbol]
+	if len(history) < 2 {
+		return 0, false
+	}
+
+	// Find the latest sample from before the window
+	cutoff := now.Add(-window)
+	idx := sort.Search(len(history), func(i int) bool {
+		return history[i].Time.After(cutoff)
+	})
+	if idx == 0 {
+		return 0, false
+	}
+
+	from := history[idx-1].Price
+	to := history[len(history)-1].Price
+
+	return (to - from) / from * 100, true
+}
+
 // Update the value of an instrument.
 func (it *InstrumentTable) Update(update Instrument) {
 	inst, ok := it.insts[update.Symbol]
 	if !ok {
 		it.insts[update.Symbol] = update
+		it.record(update, time.Now())
 		return
 	}
 
@@ -109,6 +201,10 @@ func (it *InstrumentTable) Update(update Instrument) {
 	}
 
 	it.insts[update.Symbol] = inst
+
+	if update.LastPrice.Valid || update.MarkPrice.Valid {
+		it.record(inst, time.Now())
+	}
 }
 
 // PriceUSD returns price of 1 unit of currency in USD.
@@ -218,9 +314,12 @@ func (it *InstrumentTable) Process(rl RawLiquidation) (Liquidation, error) {
 	// log.Println()
 
 	return Liquidation{
-		PriceQuantity: pq,
-		Symbol:        rl.Symbol,
-		Side:          rl.Side,
+		PriceQuantity:  pq,
+		Symbol:         rl.Symbol,
+		Side:           rl.Side,
+		Type:           inst.Type,
+		Underlying:     inst.Underlying,
+		SettleCurrency: inst.SettleCurrency,
 	}, nil
 }
 
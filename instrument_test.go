@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"log"
+	"math"
 	"os"
 	"testing"
+	"time"
 
 	"gopkg.in/guregu/null.v4"
 )
@@ -269,3 +272,60 @@ func TestInstruments2(t *testing.T) {
 		log.Println(l)
 	}
 }
+
+func TestPriceChange(t *testing.T) {
+	it := NewInstrumentTable(nil)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i <= 30; i++ {
+		it.record(Instrument{
+			Symbol:    "XBTUSD",
+			LastPrice: null.FloatFrom(50000 - float64(i)*100),
+		}, start.Add(time.Duration(i)*time.Minute))
+	}
+	now := start.Add(30 * time.Minute)
+
+	move, ok := it.priceChangeAt("XBTUSD", 15*time.Minute, now)
+	if !ok {
+		t.Fatal("expected a price move")
+	}
+
+	// 48,500 to 47,000
+	if math.Abs(move-(-3.0927835)) > 0.0001 {
+		t.Fatal("unexpected price move", move)
+	}
+
+	if _, ok := it.priceChangeAt("XBTUSD", time.Hour, now); ok {
+		t.Fatal("expected not enough history")
+	}
+
+	if _, ok := it.priceChangeAt("ETHUSD", 15*time.Minute, now); ok {
+		t.Fatal("expected no history")
+	}
+
+	if s := (PriceMove{Percent: move, Window: 15 * time.Minute}).String(); s != "(-3.1% in 15m)" {
+		t.Fatal("unexpected format", s)
+	}
+}
+
+func TestPriceHistoryAcrossReconnects(t *testing.T) {
+	f := &feed{}
+	partial := func(price float64) {
+		raw, err := json.Marshal([]Instrument{{Symbol: "XBTUSD", LastPrice: null.FloatFrom(price)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := f.handle(context.Background(), frame{Table: "instrument", Action: "partial", Data: raw}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each connection starts with a partial, which replaces the instruments but not their prices
+	partial(50000)
+	partial(49000)
+
+	if history := f.it.history["XBTUSD"]; len(history) != 2 || history[0].Price != 50000 || history[1].Price != 49000 {
+		t.Fatal("expected the price history to be kept", history)
+	}
+}
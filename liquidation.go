@@ -2,9 +2,7 @@ package main
 
 import (
 	"errors"
-	"fmt"
 	"math"
-	"sort"
 	"strings"
 	"time"
 
@@ -39,8 +37,13 @@ type (
 	Liquidation struct {
 		PriceQuantity
 
-		Symbol Symbol
-		Side   string
+		Symbol         Symbol
+		Side           string
+		Type           InstrumentType
+		Underlying     string
+		SettleCurrency string
+
+		PriceMove PriceMove
 	}
 
 	// CombinedLiquidation ...
@@ -49,19 +52,24 @@ type (
 		Side   string
 
 		Liquidations []PriceQuantity
-	}
-)
 
-const (
-	// MaxCombinedPositions caps the number of liquidations that can be combined into a single tweet.
-	MaxCombinedPositions = 3
+		PriceMove PriceMove
 
-	// MaxUSDValueMergable ...
-	MaxUSDValueMergable = 250000
+		// When grouping by underlying, liquidations on other contracts with the same underlying are kept in Related.
+		Underlying string
+		Related    []CombinedLiquidation
+	}
 
-	epsilon = 0.000001
+	// PriceMove is the percentage move of the instrument's price leading up to the liquidation.
+	// A zero window means the move is unknown or too small to mention.
+	PriceMove struct {
+		Percent float64
+		Window  time.Duration
+	}
 )
 
+const epsilon = 0.000001
+
 func displayTick(value, tick float64) string {
 	if tick == 0 {
 		return humanize.Commaf(value)
@@ -99,6 +107,15 @@ func (pq PriceQuantity) DisplayQuantity() string {
 	return displayTick(pq.Quantity, pq.MinStep)
 }
 
+// positionName returns the position that was liquidated by an order on the given side.
+func positionName(side string) string {
+	if side == "Buy" {
+		return "short"
+	}
+
+	return "long"
+}
+
 // ToCombined converts a single liquidation to a combined liquidation.
 func (l Liquidation) ToCombined() CombinedLiquidation {
 	return CombinedLiquidation{
@@ -107,34 +124,64 @@ func (l Liquidation) ToCombined() CombinedLiquidation {
 		Liquidations: []PriceQuantity{
 			l.PriceQuantity,
 		},
+		PriceMove: l.PriceMove,
 	}
 }
 
+// Grouped returns true if the liquidation can contain other contracts with the same underlying.
+func (cl CombinedLiquidation) Grouped() bool {
+	return cl.Underlying != ""
+}
+
+// Contracts returns the combined liquidation of each contract, starting with the primary symbol.
+func (cl CombinedLiquidation) Contracts() []CombinedLiquidation {
+	primary := cl
+	primary.Related = nil
+
+	return append([]CombinedLiquidation{primary}, cl.Related...)
+}
+
+// Name is the symbol, or the underlying if multiple contracts were liquidated.
+func (cl CombinedLiquidation) Name() string {
+	if len(cl.Related) > 0 {
+		return cl.Underlying
+	}
+
+	return string(cl.Symbol)
+}
+
 // CanCombine returns if an addtional liquidation can be merged into an existing combined liquidation.
-func (cl CombinedLiquidation) CanCombine(l Liquidation) bool {
-	if cl.Side != l.Side || cl.Symbol != l.Symbol {
+func (cl CombinedLiquidation) CanCombine(l Liquidation, policy CombiningPolicy) bool {
+	if cl.Side != l.Side {
 		return false
 	}
 
-	if len(cl.Liquidations) >= MaxCombinedPositions {
+	if cl.Symbol != l.Symbol && (!cl.Grouped() || cl.Underlying != l.Underlying) {
 		return false
 	}
 
-	for _, l2 := range cl.Liquidations {
-		if l2.TotalUSDValue > MaxUSDValueMergable {
+	var fills []PriceQuantity
+	for _, c := range cl.Contracts() {
+		fills = append(fills, c.Liquidations...)
+	}
+
+	if len(fills) >= policy.MaxPositions {
+		return false
+	}
+
+	for _, l2 := range fills {
+		if l2.TotalUSDValue > policy.MaxUSDValue {
 			return false
 		}
 	}
 
-	if l.TotalUSDValue > MaxUSDValueMergable {
+	if l.TotalUSDValue > policy.MaxUSDValue {
 		return false
 	}
 
-	// Acceptable merge groups
-	mergeGroups := []int{1, 24999, 250000}
-
-	for _, l2 := range cl.Liquidations {
-		if sort.SearchInts(mergeGroups, int(l.Quantity)) != sort.SearchInts(mergeGroups, int(l2.Quantity)) {
+	// Only combine liquidations of a similar size
+	for _, l2 := range fills {
+		if policy.mergeGroup(l.TotalUSDValue) != policy.mergeGroup(l2.TotalUSDValue) {
 			return false
 		}
 	}
@@ -143,120 +190,134 @@ func (cl CombinedLiquidation) CanCombine(l Liquidation) bool {
 }
 
 // Combine an existing liquidation into the the combined liquidation.
-func (cl *CombinedLiquidation) Combine(l Liquidation) error {
-	if !cl.CanCombine(l) {
+func (cl *CombinedLiquidation) Combine(l Liquidation, policy CombiningPolicy) error {
+	if !cl.CanCombine(l, policy) {
 		return errors.New("cannot merge")
 	}
 
-	cl.Liquidations = append(cl.Liquidations, l.PriceQuantity)
-	return nil
-}
+	// Other contracts with the same underlying
+	if l.Symbol != cl.Symbol {
+		for i := range cl.Related {
+			if cl.Related[i].Symbol == l.Symbol {
+				cl.Related[i].Liquidations = append(cl.Related[i].Liquidations, l.PriceQuantity)
+				return nil
+			}
+		}
 
-// String implements Stringer.
-func (l Liquidation) String() string {
-	var position string
-	if l.Side == "Buy" {
-		position = "short"
-	} else {
-		position = "long"
+		cl.Related = append(cl.Related, l.ToCombined())
+		return nil
 	}
 
-	switch l.Currency {
-	case "USD", "USDT":
-		// Example: Liquidated short on XBTUSD: Buy 130170 @ 772.02
-		return fmt.Sprintf("Liquidated %v on %v: %v %v @ %v", position, l.Symbol, l.Side, l.DisplayQuantity(), l.DisplayPrice())
-
-	default:
-		// Example: Liquidated short on ETHUSD: Buy 130170 Cont @ 772.02 (≈ $XXXX)
-		if l.TotalUSDValue < epsilon {
-			return fmt.Sprintf("Liquidated %v on %v: %v %v %v @ %v", position, l.Symbol, l.Side, l.DisplayQuantity(), l.Currency, l.DisplayPrice())
-		} else {
-			return fmt.Sprintf("Liquidated %v on %v: %v %v %v @ %v (≈ $%v)", position, l.Symbol, l.Side, l.DisplayQuantity(), l.Currency, l.DisplayPrice(), displayUSD(l.TotalUSDValue))
-		}
+	cl.Liquidations = append(cl.Liquidations, l.PriceQuantity)
+
+	// Keep the most recent price move of the primary symbol
+	if l.PriceMove.Window != 0 {
+		cl.PriceMove = l.PriceMove
 	}
 
+	return nil
+}
+
+// String formats the price move, e.g. "(-4.2% in 15m)".
+func (pm PriceMove) String() string {
+	return englishLocale.FormatPriceMove(pm)
 }
 
 // String implements Stringer.
-func (cl CombinedLiquidation) String() string {
+func (l Liquidation) String() string {
+	return l.ToCombined().String()
+}
 
-	var totalValue float64
-	currPrice := cl.Liquidations[0].DisplayPrice()
-	samePrice := true
+// String implements Stringer, using the default post template without any decoration.
+func (cl CombinedLiquidation) String() string {
+	return defaultPostFormatter.Text(defaultPostFormatter.data(cl, Decoration{}))
+}
 
-	for _, l := range cl.Liquidations {
-		totalValue += l.TotalUSDValue
-		samePrice = samePrice && l.DisplayPrice() == currPrice
+// contractFills lists the fills of each contract prefixed by its symbol, or just the fills if there is a single contract.
+func (cl CombinedLiquidation) contractFills(loc Locale) string {
+	if len(cl.Related) == 0 {
+		return cl.fills(loc)
 	}
 
-	var position string
-	if cl.Side == "Buy" {
-		position = "short"
-	} else {
-		position = "long"
+	var parts []string
+	for _, c := range cl.Contracts() {
+		parts = append(parts, string(c.Symbol)+" "+c.fills(loc))
 	}
 
-	cp := ""
-	for i, l := range cl.Liquidations {
-		if i > 0 {
-			cp += " + "
-		}
-		cp += l.DisplayQuantity()
+	return strings.Join(parts, "; ")
+}
+
+// fills lists the quantities and prices of the liquidations, e.g. "100 + 200 Cont @ 772.02, 734.01".
+func (cl CombinedLiquidation) fills(loc Locale) string {
+	if cl.quotedInUSD() {
+		return cl.quantities(loc) + " @ " + cl.prices(loc)
 	}
 
+	return cl.quantities(loc) + " " + cl.Liquidations[0].Currency + " @ " + cl.prices(loc)
+}
+
+// quotedInUSD returns true if the quantities are in dollars, so the USD value is obvious.
+func (cl CombinedLiquidation) quotedInUSD() bool {
 	switch cl.Liquidations[0].Currency {
 	case "USD", "USDT":
-	default:
-		cp += " " + cl.Liquidations[0].Currency
+		return true
 	}
 
-	cp += " @ "
-	if samePrice {
-		cp += currPrice
-	} else {
-		for i, l := range cl.Liquidations {
-			if i > 0 {
-				cp += ", "
-			}
-			cp += l.DisplayPrice()
-		}
+	return false
+}
+
+// quantities lists the quantities of the liquidations, e.g. "100 + 200".
+func (cl CombinedLiquidation) quantities(loc Locale) string {
+	var parts []string
+	for _, l := range cl.Liquidations {
+		parts = append(parts, loc.Number(l.DisplayQuantity()))
 	}
 
-	switch cl.Liquidations[0].Currency {
-	case "USD", "USDT":
-		// Example: Liquidated short on XBTUSD: Buy 130170, 123450 @ 772.02, 734.01
-		return fmt.Sprintf("Liquidated %v on %v: %v %s", position, cl.Symbol, cl.Side, cp)
+	return strings.Join(parts, " + ")
+}
 
-	default:
-		// Example Liquidated short on ETHUSD: Buy 100, 200 Cont @ 772.02, 734.01 (≈ $XXXX)
-		return fmt.Sprintf("Liquidated %v on %v: %v %s (≈ $%v)", position, cl.Symbol, cl.Side, cp, displayUSD(totalValue))
+// prices lists the prices of the liquidations, or a single price if they are all the same, e.g. "772.02, 734.01".
+func (cl CombinedLiquidation) prices(loc Locale) string {
+	currPrice := cl.Liquidations[0].DisplayPrice()
+	samePrice := true
+
+	var parts []string
+	for _, l := range cl.Liquidations {
+		samePrice = samePrice && l.DisplayPrice() == currPrice
+		parts = append(parts, loc.Number(l.DisplayPrice()))
 	}
-}
 
-// CombiningDelay is the minimum time to wait for another liquidation to combine with.
-// Small positions incur longer combining delays
-func (l Liquidation) CombiningDelay() time.Duration {
-	if l.Quantity < 1000 {
-		return 30 * time.Second
-	} else if l.Quantity < 25000 {
-		return 20 * time.Second
-	} else if l.Quantity < 125000 {
-		return 15 * time.Second
-	} else {
-		return 10 * time.Second
+	if samePrice {
+		return loc.Number(currPrice)
 	}
+
+	return strings.Join(parts, ", ")
 }
 
-// USDValue returns the USD value of the liquidation.
+// USDValue returns the USD value of the liquidation, including related contracts.
 func (cl CombinedLiquidation) USDValue() (total float64) {
 	for _, v := range cl.Liquidations {
 		total += v.TotalUSDValue
 	}
 
+	for _, r := range cl.Related {
+		total += r.USDValue()
+	}
+
 	return total
 }
 
-// TotalQuantity of a combined liquidation.
+// fillCount is the number of liquidations across all contracts.
+func (cl CombinedLiquidation) fillCount() int {
+	n := len(cl.Liquidations)
+	for _, r := range cl.Related {
+		n += r.fillCount()
+	}
+
+	return n
+}
+
+// TotalQuantity of a combined liquidation, quantities of related contracts are not comparable so are excluded.
 func (cl CombinedLiquidation) TotalQuantity() (total float64) {
 	for _, v := range cl.Liquidations {
 		total += v.Quantity
@@ -276,6 +337,28 @@ func (cl CombinedLiquidation) MaxQuantity() (max float64) {
 	return max
 }
 
+// Largest returns the liquidation with the highest USD value, excluding related contracts.
+func (cl CombinedLiquidation) Largest() (largest PriceQuantity) {
+	for _, v := range cl.Liquidations {
+		if v.TotalUSDValue > largest.TotalUSDValue {
+			largest = v
+		}
+	}
+
+	return largest
+}
+
+// hasQuantity returns true if one of the liquidations has exactly this quantity.
+func (cl CombinedLiquidation) hasQuantity(q float64) bool {
+	for _, v := range cl.Liquidations {
+		if math.Abs(v.Quantity-q) < epsilon {
+			return true
+		}
+	}
+
+	return false
+}
+
 // MinQuantity of a combined liquidation.
 func (cl CombinedLiquidation) MinQuantity() (min float64) {
 	if len(cl.Liquidations) == 0 {
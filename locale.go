@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Locale is the wording and number format of posts in a language.
+type Locale struct {
+	Name string
+
+	// Default post template, see PostData.
+	Template string
+
+	// Positions and order sides.
+	Long, Short string
+	Buy, Sell   string
+
+	// Recent price move, %[1]v is the change such as "-4.2%" and %[2]v the window such as "15m".
+	PriceMove string
+
+	// Fills summarised to fit the length limit, %[1]v is the number of fills and %[2]v their total USD value.
+	CompressedFills string
+
+	// Cascade of liquidations, %[1]v is the symbol, %[2]v the number of liquidations, %[3]v the positions
+	// liquidated, %[4]v how long it lasted, %[5]v the order side, %[6]v the total quantity, %[7]v the price range
+	// and %[8]v the total USD value.
+	Cascade string
+	Longs   string
+	Shorts  string
+
+	// Heading drawn on image cards, %[1]v is the position. The card font only has ASCII, locales it can't draw
+	// leave this empty and get English cards.
+	CardHeading string
+
+	// Alt text of image cards, %[1]v is the position, %[2]v the symbol, %[3]v the order side, %[4]v the fills and
+	// %[5]v the USD value. CardMedals is appended listing the medals awarded, %[1]v.
+	CardAlt    string
+	CardMedals string
+
+	// Digest of what was held back during quiet hours, %[1]v is the number of liquidations,
+	// %[2]v their total USD value and %[3]v the largest USD value.
+	DigestOne  string
+	DigestMany string
+
+	// Number separators.
+	Thousands string
+	Decimal   string
+}
+
+// Decorations appended to every post by the default templates.
+const postDecorationsTemplate = `{{with .PriceContext}} {{.}}{{end}}{{with .Medals}} {{.}}{{end}}{{with .Streak}} ~ {{.}}{{end}}{{with .Snark}} ~ {{.}}{{end}}`
+
+// Locales posts can be written in.
+var locales = map[string]Locale{
+	"en": englishLocale,
+	"es": {
+		Name:            "es",
+		Template:        `Posición {{.Position}} liquidada en {{.Symbol}}: {{.Side}} {{.Fills}}{{if .ShowUSD}} (≈ ${{.USD}}){{end}}` + postDecorationsTemplate,
+		Long:            "larga",
+		Short:           "corta",
+		Buy:             "Compra",
+		Sell:            "Venta",
+		PriceMove:       "(%[1]v en %[2]v)",
+		CompressedFills: "%[1]d ejecuciones por $%[2]v",
+		Cascade:         "Cascada de liquidaciones en %[1]v: %[2]d %[3]v liquidadas en %[4]v, %[5]v %[6]v @ %[7]v (≈ $%[8]v)",
+		Longs:           "largas",
+		Shorts:          "cortas",
+		CardHeading:     "%[1]v liquidada",
+		CardAlt:         "Posición %[1]v liquidada en %[2]v: %[3]v %[4]v, por unos $%[5]v.",
+		CardMedals:      " Medallas: %[1]v.",
+		DigestOne:       "Mientras no estábamos: 1 liquidación de $%[2]v",
+		DigestMany:      "Mientras no estábamos: %[1]d liquidaciones por $%[2]v, la mayor de $%[3]v",
+		Thousands:       ".",
+		Decimal:         ",",
+	},
+	"ja": {
+		Name:            "ja",
+		Template:        `{{.Symbol}}で{{.Position}}が清算: {{.Side}} {{.Fills}}{{if .ShowUSD}} (≈ ${{.USD}}){{end}}` + postDecorationsTemplate,
+		Long:            "ロング",
+		Short:           "ショート",
+		Buy:             "買い",
+		Sell:            "売り",
+		PriceMove:       "(%[2]vで%[1]v)",
+		CompressedFills: "%[1]d件 合計$%[2]v",
+		Cascade:         "%[1]vで連鎖清算: %[3]v %[2]d件 (%[4]v) %[5]v %[6]v @ %[7]v (≈ $%[8]v)",
+		Longs:           "ロング",
+		Shorts:          "ショート",
+		CardAlt:         "%[2]vで%[1]vが清算: %[3]v %[4]v、約$%[5]v。",
+		CardMedals:      "メダル: %[1]v。",
+		DigestOne:       "休止中の清算: 1件 $%[2]v",
+		DigestMany:      "休止中の清算: %[1]d件 合計$%[2]v 最大$%[3]v",
+		Thousands:       ",",
+		Decimal:         ".",
+	},
+}
+
+var englishLocale = Locale{
+	Name:            "en",
+	Template:        DefaultPostTemplate,
+	Long:            "long",
+	Short:           "short",
+	Buy:             "Buy",
+	Sell:            "Sell",
+	PriceMove:       "(%[1]v in %[2]v)",
+	CompressedFills: "%[1]d fills totalling $%[2]v",
+	Cascade:         "Liquidation cascade on %[1]v: %[2]d %[3]v liquidated in %[4]v, %[5]v %[6]v @ %[7]v (≈ $%[8]v)",
+	Longs:           "longs",
+	Shorts:          "shorts",
+	CardHeading:     "%[1]v liquidated",
+	CardAlt:         "Liquidated %[1]v on %[2]v: %[3]v %[4]v, worth about $%[5]v.",
+	CardMedals:      " Medals: %[1]v.",
+	DigestOne:       "While we were away: 1 liquidation worth $%[2]v",
+	DigestMany:      "While we were away: %[1]d liquidations worth $%[2]v, the largest was $%[3]v",
+	Thousands:       ",",
+	Decimal:         ".",
+}
+
+// findLocale returns a locale by name, English if the name is empty.
+func findLocale(name string) (Locale, error) {
+	if name == "" {
+		return englishLocale, nil
+	}
+
+	l, ok := locales[name]
+	if !ok {
+		return Locale{}, fmt.Errorf("unknown locale %q", name)
+	}
+
+	return l, nil
+}
+
+// Number converts a number formatted with English separators, e.g. "1,234.5", to the locale.
+func (l Locale) Number(s string) string {
+	if l.Thousands == "," && l.Decimal == "." {
+		return s
+	}
+
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case ',':
+			b.WriteString(l.Thousands)
+		case '.':
+			b.WriteString(l.Decimal)
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// Position returns the position liquidated by an order on the given side.
+func (l Locale) Position(side string) string {
+	if positionName(side) == "short" {
+		return l.Short
+	}
+
+	return l.Long
+}
+
+// Side returns the order side.
+func (l Locale) Side(side string) string {
+	if side == "Buy" {
+		return l.Buy
+	}
+
+	return l.Sell
+}
+
+// FormatPriceMove formats a price move, e.g. "(-4.2% in 15m)".
+func (l Locale) FormatPriceMove(pm PriceMove) string {
+	if pm.Window == 0 {
+		return ""
+	}
+
+	var window string
+	switch {
+	case pm.Window%time.Hour == 0:
+		window = fmt.Sprintf("%dh", pm.Window/time.Hour)
+	case pm.Window%time.Minute == 0:
+		window = fmt.Sprintf("%dm", pm.Window/time.Minute)
+	default:
+		window = fmt.Sprintf("%ds", pm.Window/time.Second)
+	}
+
+	return fmt.Sprintf(l.PriceMove, l.Number(fmt.Sprintf("%+.1f%%", pm.Percent)), window)
+}
+
+// FormatCascade formats a cascade, e.g.
+// "Liquidation cascade on XBTUSD: 37 longs liquidated in 4m12s, Sell 2,300,000 @ 58,200 - 60,100 (≈ $2,300,000)".
+func (l Locale) FormatCascade(c Cascade) string {
+	quantity := l.Number(displayTick(c.Quantity, c.MinStep))
+	switch c.Currency {
+	case "USD", "USDT":
+	default:
+		quantity += " " + c.Currency
+	}
+
+	prices := l.Number(displayTick(c.MinPrice, c.MinTick))
+	if high := l.Number(displayTick(c.MaxPrice, c.MinTick)); high != prices {
+		prices += " - " + high
+	}
+
+	positions := l.Longs
+	if positionName(c.Side) == "short" {
+		positions = l.Shorts
+	}
+
+	return fmt.Sprintf(l.Cascade, c.Symbol, c.Count, positions, c.Duration().Round(time.Second), l.Side(c.Side), quantity, prices,
+		l.Number(displayUSD(c.USDValue)))
+}
+
+// FormatDigest formats a digest of liquidations held back during quiet hours.
+func (l Locale) FormatDigest(d digest) string {
+	format := l.DigestMany
+	if d.Count == 1 {
+		format = l.DigestOne
+	}
+
+	return fmt.Sprintf(format, d.Count, l.Number(displayUSD(d.USDValue)), l.Number(displayUSD(d.Largest)))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLocalizedPosts(t *testing.T) {
+	cl := testLiquidation("XBTUSD", "Buy", 130170).ToCombined()
+	cl.Liquidations[0].Price = 772.02
+	cl.Liquidations[0].MinTick = 0.01
+	cl.PriceMove = PriceMove{Percent: -4.2, Window: 15 * time.Minute}
+
+	coins := testLiquidation("ETHUSD", "Sell", 4500).ToCombined()
+	coins.Liquidations[0].Quantity = 1500
+	coins.Liquidations[0].Currency = "Cont"
+
+	d := Decoration{
+		PriceContext: cl.PriceMove.String(),
+		Medals:       []Medal{{Name: "100k", Emoji: "\U0001F4AF"}},
+		Streak:       "Double kill",
+		Snark:        "Rekt",
+		Localized: map[string]LocalizedText{
+			"es": {Streak: "Doble muerte", Snark: "QEPD"},
+		},
+	}
+
+	table := []struct {
+		Locale      string
+		Liquidation CombinedLiquidation
+		Decoration  Decoration
+		Expected    string
+	}{
+		{"en", cl, d, "Liquidated short on XBTUSD: Buy 130,170 @ 772.02 (-4.2% in 15m) \U0001F4AF ~ Double kill ~ Rekt"},
+		{"es", cl, d, "Posición corta liquidada en XBTUSD: Compra 130.170 @ 772,02 (-4,2% en 15m) \U0001F4AF ~ Doble muerte ~ QEPD"},
+		{"es", coins, Decoration{}, "Posición larga liquidada en ETHUSD: Venta 1.500 Cont @ 50.000 (≈ $4.500)"},
+
+		// No Japanese text was loaded, so the English streak and snark are left out
+		{"ja", cl, d, "XBTUSDでショートが清算: 買い 130,170 @ 772.02 (15mで-4.2%) \U0001F4AF"},
+	}
+
+	for _, v := range table {
+		locale, err := findLocale(v.Locale)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := newPostFormatter(FormatConfig{}, locale)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if s := f.Render(v.Liquidation, v.Decoration); s != v.Expected {
+			t.Errorf("%v: expected %q got %q", v.Locale, v.Expected, s)
+		}
+	}
+
+	if _, err := findLocale("xx"); err == nil {
+		t.Error("expected an unknown locale to fail")
+	}
+}
+
+func TestLocalizedDigest(t *testing.T) {
+	d := digest{Count: 3, USDValue: 4500000, Largest: 1200000}
+
+	if s := locales["es"].FormatDigest(d); s != "Mientras no estábamos: 3 liquidaciones por $4.500.000, la mayor de $1.200.000" {
+		t.Error("unexpected digest", s)
+	}
+
+	if s := d.String(); s != "While we were away: 3 liquidations worth $4,500,000, the largest was $1,200,000" {
+		t.Error("unexpected digest", s)
+	}
+}
+
+func TestLocalizedCascadesAndCards(t *testing.T) {
+	c := Cascade{
+		Symbol: "XBTUSD", Side: "Sell", Count: 3, Quantity: 600000, Currency: "USD", USDValue: 600000,
+		MinPrice: 59000, MaxPrice: 59700, MinStep: 100, MinTick: 0.5,
+		Start: time.Unix(0, 0), End: time.Unix(27, 0),
+	}
+
+	cl := testLiquidation("XBTUSD", "Buy", 130170).ToCombined()
+	d := Decoration{Medals: []Medal{{Name: "100k"}}}
+
+	table := []struct {
+		Locale  string
+		Cascade string
+		AltText string
+	}{
+		{"en", "Liquidation cascade on XBTUSD: 3 longs liquidated in 27s, Sell 600,000 @ 59,000 - 59,700 (≈ $600,000)",
+			"Liquidated short on XBTUSD: Buy 130,170 @ 50,000, worth about $130,170. Medals: 100k."},
+		{"es", "Cascada de liquidaciones en XBTUSD: 3 largas liquidadas en 27s, Venta 600.000 @ 59.000 - 59.700 (≈ $600.000)",
+			"Posición corta liquidada en XBTUSD: Compra 130.170 @ 50.000, por unos $130.170. Medallas: 100k."},
+		{"ja", "XBTUSDで連鎖清算: ロング 3件 (27s) 売り 600,000 @ 59,000 - 59,700 (≈ $600,000)",
+			"XBTUSDでショートが清算: 買い 130,170 @ 50,000、約$130,170。メダル: 100k。"},
+	}
+
+	for _, v := range table {
+		f, err := newPostFormatter(FormatConfig{}, locales[v.Locale])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if s := f.RenderCascade(c); s != v.Cascade {
+			t.Errorf("%v: expected cascade %q got %q", v.Locale, v.Cascade, s)
+		}
+
+		card, alt := f.RenderCard(cl, d)
+		if card == nil || alt != v.AltText {
+			t.Errorf("%v: expected alt text %q got %q", v.Locale, v.AltText, alt)
+		}
+	}
+}
+
+func TestDecorateTranslations(t *testing.T) {
+	s := &State{
+		SaveFile:   filepath.Join(t.TempDir(), "high_scores.json"),
+		HighScores: newHighScores(),
+		Corpus:     Corpus{Snark: []SnarkLine{{Raw: "snark", Text: "snark"}}, MultiKill: []string{"Double kill"}},
+		Medals:     testMedalEngine(t),
+	}
+
+	if err := s.LoadTranslation("es"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, locale := range []string{"es", "ja"} {
+		c, err := loadTranslation(builtinText, locale)
+		if err != nil {
+			t.Fatal(locale, err)
+		}
+
+		if len(c.Snark) == 0 || len(c.MultiKill) == 0 {
+			t.Fatal(locale, "empty corpus")
+		}
+	}
+
+	cl := testLiquidation("XBTUSD", "Sell", 1000).ToCombined()
+	s.Decorate(cl)
+	d := s.Decorate(cl)
+
+	if d.Streak != "Double kill" || d.Localized["es"].Streak != "Doble muerte" {
+		t.Errorf("unexpected streaks %q %+v", d.Streak, d.Localized)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// LogConfig controls the logging.
+type LogConfig struct {
+	// "text" (default) or "json".
+	Format string `json:"format"`
+
+	// Minimum level logged: "debug", "info" (default), "warn" or "error".
+	Level string `json:"level"`
+
+	// Levels of components which differ from Level, e.g. {"combiner": "debug", "feed": "warn"}.
+	Components map[string]string `json:"components"`
+
+	// Include the file and line that logged.
+	Source bool `json:"source"`
+}
+
+// Loggers of the components, each can be given its own level.
+var (
+	logComponents = make(map[string]bool)
+
+	mainLog      = newLogger("main")      // Starting up and shutting down
+	feedLog      = newLogger("feed")      // BitMex websocket and every frame received
+	pipelineLog  = newLogger("pipeline")  // Liquidations detected and filtered
+	combinerLog  = newLogger("combiner")  // Combining liquidations and cascades
+	publisherLog = newLogger("publisher") // Schedules, rate limits and publishing
+	stateLog     = newLogger("state")     // High scores, seen orders and the publisher queue
+	adminLog     = newLogger("admin")     // Admin API
+	reloadLog    = newLogger("reload")    // Config and text reloads
+)
+
+// logSettings are the handler and levels in use, replaced when the config is loaded.
+type logSettings struct {
+	handler    slog.Handler
+	level      slog.Level
+	components map[string]slog.Level
+}
+
+var currentLogSettings atomic.Pointer[logSettings]
+
+func init() {
+	currentLogSettings.Store(&logSettings{
+		handler: slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}),
+		level:   slog.LevelInfo,
+	})
+
+	// Anything using the log package, such as net/http, goes to the same place
+	slog.SetDefault(mainLog)
+}
+
+// levelOf returns the minimum level logged for a component.
+func (s *logSettings) levelOf(component string) slog.Level {
+	if level, ok := s.components[component]; ok {
+		return level
+	}
+
+	return s.level
+}
+
+// newLogger returns the logger of a component.
+func newLogger(component string) *slog.Logger {
+	logComponents[component] = true
+	return slog.New(&componentHandler{component: component})
+}
+
+// componentHandler applies the level of its component, and passes records on to the current handler.
+type componentHandler struct {
+	component string
+	with      []func(slog.Handler) slog.Handler // Attributes and groups added with Logger.With
+}
+
+// Enabled implements slog.Handler.
+func (h *componentHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= currentLogSettings.Load().levelOf(h.component)
+}
+
+// Handle implements slog.Handler.
+func (h *componentHandler) Handle(ctx context.Context, r slog.Record) error {
+	handler := currentLogSettings.Load().handler.WithAttrs([]slog.Attr{slog.String("component", h.component)})
+	for _, with := range h.with {
+		handler = with(handler)
+	}
+
+	return handler.Handle(ctx, r)
+}
+
+// WithAttrs implements slog.Handler.
+func (h *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.withHandler(func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+// WithGroup implements slog.Handler.
+func (h *componentHandler) WithGroup(name string) slog.Handler {
+	return h.withHandler(func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
+
+func (h *componentHandler) withHandler(with func(slog.Handler) slog.Handler) slog.Handler {
+	return &componentHandler{
+		component: h.component,
+		with:      append(h.with[:len(h.with):len(h.with)], with),
+	}
+}
+
+// parseLogLevel parses a level such as "debug" or "warn".
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q, use debug, info, warn or error", s)
+	}
+
+	return level, nil
+}
+
+// validate checks the format, levels and component names.
+func (cfg LogConfig) validate() error {
+	_, err := cfg.settings(io.Discard)
+	return err
+}
+
+// settings returns the handler and levels for the config, writing to w.
+func (cfg LogConfig) settings(w io.Writer) (*logSettings, error) {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug, AddSource: cfg.Source}
+
+	s := logSettings{components: make(map[string]slog.Level)}
+	switch cfg.Format {
+	case "", "text":
+		s.handler = slog.NewTextHandler(w, opts)
+	case "json":
+		s.handler = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("log.format must be text or json, not %q", cfg.Format)
+	}
+
+	var err error
+	if s.level, err = parseLogLevel(cfg.Level); err != nil {
+		return nil, fmt.Errorf("log.level: %w", err)
+	}
+
+	for component, level := range cfg.Components {
+		if !logComponents[component] {
+			known := make([]string, 0, len(logComponents))
+			for c := range logComponents {
+				known = append(known, c)
+			}
+			sort.Strings(known)
+
+			return nil, fmt.Errorf("log.components: unknown component %q, use %v", component, strings.Join(known, ", "))
+		}
+
+		if s.components[component], err = parseLogLevel(level); err != nil {
+			return nil, fmt.Errorf("log.components[%q]: %w", component, err)
+		}
+	}
+
+	return &s, nil
+}
+
+// configureLogging switches the logging to the config, the previous settings are kept if it is invalid.
+func configureLogging(cfg LogConfig) error {
+	s, err := cfg.settings(os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	currentLogSettings.Store(s)
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// captureLogs sends the logs to a buffer for the rest of the test.
+func captureLogs(t *testing.T, cfg LogConfig) *bytes.Buffer {
+	var buf bytes.Buffer
+	s, err := cfg.settings(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev := currentLogSettings.Swap(s)
+	t.Cleanup(func() { currentLogSettings.Store(prev) })
+
+	return &buf
+}
+
+func TestLogComponents(t *testing.T) {
+	buf := captureLogs(t, LogConfig{Format: "json", Level: "warn", Components: map[string]string{"combiner": "debug"}})
+
+	combinerLog.With("symbol", "XBTUSD").Debug("Test combined", "usd_value", 1000.0)
+	feedLog.Info("Test received", "action", "insert")
+	feedLog.Warn("Test warning")
+
+	// Goroutines left over from other tests may log too
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var v map[string]any
+		if err := json.Unmarshal([]byte(line), &v); err != nil {
+			t.Fatal(err, line)
+		}
+
+		if msg, _ := v["msg"].(string); strings.HasPrefix(msg, "Test ") {
+			lines = append(lines, v)
+		}
+	}
+
+	if len(lines) != 2 {
+		t.Fatalf("expected the combiner debug and feed warning only:\n%v", buf)
+	}
+
+	if l := lines[0]; l["component"] != "combiner" || l["msg"] != "Test combined" || l["symbol"] != "XBTUSD" || l["usd_value"] != 1000.0 {
+		t.Errorf("unexpected combiner line %v", l)
+	}
+
+	if l := lines[1]; l["component"] != "feed" || l["level"] != "WARN" {
+		t.Errorf("unexpected feed line %v", l)
+	}
+}
+
+func TestLogConfigValidate(t *testing.T) {
+	for _, c := range []struct {
+		cfg      LogConfig
+		expected string
+	}{
+		{LogConfig{Format: "xml"}, "log.format must be text or json"},
+		{LogConfig{Level: "loud"}, `unknown log level "loud"`},
+		{LogConfig{Components: map[string]string{"combinator": "debug"}}, `unknown component "combinator", use admin, combiner,`},
+	} {
+		if err := c.cfg.validate(); err == nil || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("%+v: expected %q, got %v", c.cfg, c.expected, err)
+		}
+	}
+
+	if err := (LogConfig{Format: "text", Level: "DEBUG", Components: map[string]string{"feed": "error"}}).validate(); err != nil {
+		t.Fatal(err)
+	}
+}
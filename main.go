@@ -3,52 +3,26 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"math"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	_ "net/http/pprof"
 
-	"golang.org/x/time/rate"
+	// Embed the timezone database for the publisher schedules
+	_ "time/tzdata"
 
 	"github.com/gorilla/websocket"
-	"github.com/michimani/gotwi"
-	"github.com/michimani/gotwi/tweet/managetweet"
-	ctypes "github.com/michimani/gotwi/tweet/managetweet/types"
-	"github.com/michimani/gotwi/user/userlookup"
-	utypes "github.com/michimani/gotwi/user/userlookup/types"
 )
 
-// BotConfig store the bot configuration.
-type BotConfig struct {
-	BitMexHost            string `json:"bitmex_host"`
-	TwitterConsumerKey    string `json:"twitter_consumer_key"`
-	TwitterConsumerSecret string `json:"twitter_consumer_secret"`
-	TwitterAccessToken    string `json:"twitter_access_token"`
-	TwitterTokenSecret    string `json:"twitter_token_secret"`
-}
-
-func loadConfig() (config BotConfig, err error) {
-	configPath := os.Getenv("CONFIG")
-	if configPath == "" {
-		configPath = "config.json"
-	}
-
-	file, err := os.Open(configPath)
-	if err != nil {
-		return config, err
-	}
-
-	if err := json.NewDecoder(file).Decode(&config); err != nil {
-		return config, err
-	}
-
-	return config, nil
-}
-
 // Constants for Websocket
 const (
 	// Time allowed to write a message to the peer.
@@ -61,24 +35,37 @@ const (
 	pingPeriod = (pongWait * 9) / 10
 )
 
-func runClient(cfg BotConfig, liqChan chan Liquidation) error {
+// Liquidation order IDs are remembered for a day across reconnects and restarts.
+const orderStoreTTL = 24 * time.Hour
+
+// How long to wait for pending liquidations to be flushed and saved on shutdown by default.
+const defaultShutdownTimeout = 20 * time.Second
+
+// runClient connects to BitMex and sends the liquidations on liqChan, appending the raw frames to record if it is not nil.
+func runClient(ctx context.Context, host string, f *feed, record io.Writer, liqChan chan<- Liquidation) error {
 	// Subscribe to the liquidation feed.
 	// https://www.bitmex.com/app/wsAPI
 	var u url.URL
 	u.Scheme = "wss"
-	u.Host = cfg.BitMexHost
+	u.Host = host
 	u.Path = "realtime"
 	u.RawQuery = "subscribe=instrument,liquidation"
 
 	// Connect the websocket
-	conn, _, err := websocket.DefaultDialer.Dial(u.String(), http.Header{})
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, u.String(), http.Header{})
 	if err != nil {
 		return fmt.Errorf("could not connect to BitMex: %w", err)
 	}
 
-	log.Println("Connected to BitMex:", u.String())
+	feedLog.Info("Connected to BitMex", "url", u.String())
 
-	// Handle the pings
+	f.health.Connected()
+	defer f.health.Disconnected()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	// Handle the pings, and close the connection when shutting down
 	go func() {
 		ticker := time.NewTicker(pingPeriod)
 		defer func() {
@@ -86,9 +73,21 @@ func runClient(cfg BotConfig, liqChan chan Liquidation) error {
 			conn.Close()
 		}()
 
-		for range ticker.C {
-			conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := conn.WriteMessage(websocket.PingMessage, []byte{}); err != nil {
+		for {
+			select {
+			case <-ticker.C:
+				conn.SetWriteDeadline(time.Now().Add(writeWait))
+				if err := conn.WriteMessage(websocket.PingMessage, []byte{}); err != nil {
+					return
+				}
+
+			case <-ctx.Done():
+				feedLog.Info("Disconnecting from BitMex")
+				msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+				conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+				return
+
+			case <-done:
 				return
 			}
 		}
@@ -98,150 +97,243 @@ func runClient(cfg BotConfig, liqChan chan Liquidation) error {
 	conn.SetReadDeadline(time.Now().Add(pongWait))
 	conn.SetPongHandler(func(string) error { conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
 
-	// Prevent orderIDs from appearing twice.
-	lastSeen := make(map[string]time.Time)
-
-	var it *InstrumentTable
+	// The instruments are loaded again from the partial
+	f.it = nil
 
 	for {
-		var data struct {
-			Table  string          `json:"table"`
-			Action string          `json:"action"`
-			Error  string          `json:"error"`
-			Data   json.RawMessage `json:"data"`
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		if record != nil {
+			if _, err := record.Write(append(raw, '\n')); err != nil {
+				feedLog.Warn("Failed to record frame", "err", err)
+			}
 		}
-		if err := conn.ReadJSON(&data); err != nil {
+
+		var data frame
+		if err := json.Unmarshal(raw, &data); err != nil {
 			return err
 		}
 
-		if data.Error != "" {
-			return fmt.Errorf("error in API response: %v", err)
+		if err := f.handle(ctx, data, liqChan); err != nil {
+			return err
 		}
+	}
+}
 
-		switch data.Table {
-		case "instrument":
-			switch data.Action {
-			case "partial":
-				var curr []Instrument
-				if err := json.Unmarshal(data.Data, &curr); err != nil {
-					return err
-				}
+// frame is a message from the BitMex websocket.
+type frame struct {
+	Table  string          `json:"table"`
+	Action string          `json:"action"`
+	Error  string          `json:"error"`
+	Data   json.RawMessage `json:"data"`
+}
 
-				it = NewInstrumentTable(curr)
+// feed turns the frames of a connection into liquidations, either live or replayed from a recording.
+type feed struct {
+	cfg     *liveConfig
+	orders  *OrderStore
+	it      *InstrumentTable
+	history priceHistory   // Kept across connections for the price context, created on the first partial if nil
+	health  *healthMonitor // Told about instrument updates, if not nil
+}
 
-			case "update":
-				var update []Instrument
-				if err := json.Unmarshal(data.Data, &update); err != nil {
-					return err
-				}
+// handle processes a frame, sending new liquidations on liqChan.
+func (f *feed) handle(ctx context.Context, data frame, liqChan chan<- Liquidation) error {
+	if data.Error != "" {
+		return fmt.Errorf("error in API response: %v", data.Error)
+	}
 
-				for _, v := range update {
-					it.Update(v)
-				}
+	switch data.Table {
+	case "instrument":
+		switch data.Action {
+		case "partial":
+			var curr []Instrument
+			if err := json.Unmarshal(data.Data, &curr); err != nil {
+				return err
 			}
 
-		case "liquidation":
-			log.Printf("Received: %v %v %v\n", data.Table, data.Action, string(data.Data))
+			if f.history == nil {
+				f.history = make(priceHistory)
+			}
 
-			// BitMex may "insert" / "delete / "insert" the order when it is able to liquidate at a better price
-			// "insert" is sent when the order is submitted
-			// "delete" is sent when the order is executed
-			// It may also "update" the order when the it is amended or partially filled
+			f.it = newInstrumentTable(curr, f.history)
+			f.health.InstrumentsUpdated()
 
-			switch data.Action {
-			case "partial":
-				var curr []RawLiquidation
-				if err := json.Unmarshal(data.Data, &curr); err != nil {
-					return err
-				}
+		case "update":
+			// Wait for instruments table to be loaded
+			if f.it == nil {
+				return nil
+			}
 
-				// Load the current liquidations as last seen
-				for _, v := range curr {
-					lastSeen[v.OrderID] = time.Now()
-				}
+			var update []Instrument
+			if err := json.Unmarshal(data.Data, &update); err != nil {
+				return err
+			}
 
-			case "update":
-				// Ignored, since once the tweet goes out there is no recovering it
+			for _, v := range update {
+				f.it.Update(v)
+			}
+			f.health.InstrumentsUpdated()
+		}
 
-			case "delete":
-				var update []RawLiquidation
-				if err := json.Unmarshal(data.Data, &update); err != nil {
-					return err
-				}
+	case "liquidation":
+		feedLog.Debug("Received", "table", data.Table, "action", data.Action, "data", string(data.Data))
 
-				// Update last seen to keep it alive
-				for _, v := range update {
-					lastSeen[v.OrderID] = time.Now()
-				}
+		// BitMex may "insert" / "delete / "insert" the order when it is able to liquidate at a better price
+		// "insert" is sent when the order is submitted
+		// "delete" is sent when the order is executed
+		// It may also "update" the order when the it is amended or partially filled
 
-			case "insert":
-				// Wait for instruments table to be loaded
-				if it == nil {
-					continue
-				}
+		switch data.Action {
+		case "partial":
+			var curr []RawLiquidation
+			if err := json.Unmarshal(data.Data, &curr); err != nil {
+				return err
+			}
 
-				// Prune last seen
-				for k, v := range lastSeen {
-					if time.Since(v) > 24*time.Hour {
-						delete(lastSeen, k)
-					}
-				}
+			// Load the current liquidations as last seen
+			for _, v := range curr {
+				f.orders.Touch(v.OrderID)
+			}
 
-				var inserts []RawLiquidation
-				if err := json.Unmarshal(data.Data, &inserts); err != nil {
-					return err
-				}
+		case "update":
+			// Ignored, since once the tweet goes out there is no recovering it
 
-				for _, v := range inserts {
-					if _, ok := lastSeen[v.OrderID]; ok {
-						lastSeen[v.OrderID] = time.Now()
-						continue
-					}
+		case "delete":
+			var update []RawLiquidation
+			if err := json.Unmarshal(data.Data, &update); err != nil {
+				return err
+			}
 
-					lastSeen[v.OrderID] = time.Now()
+			// Update last seen to keep it alive
+			for _, v := range update {
+				f.orders.Touch(v.OrderID)
+			}
 
-					l, err := it.Process(v)
-					if err != nil {
-						log.Printf("failed to process: %+v %v\n", v, err)
-						continue
+		case "insert":
+			// Wait for instruments table to be loaded
+			if f.it == nil {
+				return nil
+			}
+
+			var inserts []RawLiquidation
+			if err := json.Unmarshal(data.Data, &inserts); err != nil {
+				return err
+			}
+
+			for _, v := range inserts {
+				// Prevent orderIDs from appearing twice
+				if f.orders.Seen(v.OrderID) {
+					feedLog.Debug("Already seen", "order_id", v.OrderID, "symbol", v.Symbol)
+					continue
+				}
+
+				l, err := f.it.Process(v)
+				if err != nil {
+					feedLog.Warn("Failed to process liquidation", "order_id", v.OrderID, "symbol", v.Symbol, "err", err)
+					continue
+				}
+
+				// Add the recent price move if it is big enough to be interesting
+				if pc := f.cfg.Load().PriceContext; pc.Window > 0 {
+					window := time.Duration(pc.Window)
+					if move, ok := f.it.PriceChange(v.Symbol, window); ok && math.Abs(move) >= pc.MinMove {
+						l.PriceMove = PriceMove{Percent: move, Window: window}
 					}
+				}
 
-					liqChan <- l
+				select {
+				case liqChan <- l:
+				case <-ctx.Done():
+					return ctx.Err()
 				}
 			}
 		}
 	}
+
+	return nil
 }
 
-func symbolLiquidator(state *State, liqChan <-chan Liquidation, tweetChan chan<- preparedTweet) {
+// symbolLiquidator combines the liquidations of a symbol and posts them.
+// A channel sent on flushChan is closed once everything held back for combining has been posted.
+func symbolLiquidator(ctx context.Context, live *liveConfig, state *State, liqChan <-chan Liquidation, flushChan <-chan chan struct{}, tweetChan chan<- preparedTweet) {
 	flusher := time.NewTicker(10 * time.Second)
 	defer flusher.Stop()
 
 	var unsentLiquidation *CombinedLiquidation
 	var unsentReceivedAt time.Time
 	var unsentCombiningDelay time.Duration
+	var unsentPolicy CombiningPolicy
+
+	// Settings changed by a reload apply from the next event
+	cfg := live.Load()
+	cascades := newCascadeDetector(cfg.Cascade)
+	refresh := func() {
+		cfg = live.Load()
+		cascades.cfg = cfg.Cascade
+	}
 
 	tweet := func(cl CombinedLiquidation) {
 		decoration := state.Decorate(cl)
-		status := decoration.Apply(cl.String())
+
+		tweetChan <- preparedTweet{
+			timestamp:   time.Now(),
+			usdValue:    cl.USDValue(),
+			status:      cl.String(),
+			liquidation: &cl,
+			decoration:  decoration,
+			withCard:    cfg.CardMinUSD > 0 && cl.USDValue() >= cfg.CardMinUSD, // Attach a card to the big ones
+		}
+	}
+
+	postCascade := func(c Cascade) {
+		combinerLog.Info("Cascade finished", "symbol", c.Symbol, "usd_value", c.USDValue, "cascade", c.String())
 		tweetChan <- preparedTweet{
 			timestamp: time.Now(),
-			usdValue:  cl.USDValue(),
-			status:    status,
+			usdValue:  c.USDValue,
+			status:    c.String(),
+			cascade:   &c,
+		}
+	}
+
+	// Post everything pending without waiting for more liquidations
+	flush := func() {
+		for _, c := range cascades.Flush() {
+			postCascade(c)
+		}
+
+		if unsentLiquidation != nil {
+			tweet(*unsentLiquidation)
+			unsentLiquidation = nil
 		}
 	}
 
 	newUnsent := func(l Liquidation) {
 		combined := l.ToCombined()
+		if cfg.Combining.GroupByUnderlying {
+			combined.Underlying = l.Underlying
+		}
 
 		unsentLiquidation = &combined
 		unsentReceivedAt = time.Now()
-		unsentCombiningDelay = l.CombiningDelay()
+		unsentPolicy = cfg.Combining.Policy(l)
+		unsentCombiningDelay = unsentPolicy.CombiningDelay(l.TotalUSDValue)
 	}
 
 	for {
 		select {
 		case <-flusher.C:
+			refresh()
+			for _, c := range cascades.Finished(time.Now()) {
+				postCascade(c)
+			}
+
 			if unsentLiquidation == nil {
 				continue
 			}
@@ -254,28 +346,62 @@ func symbolLiquidator(state *State, liqChan <-chan Liquidation, tweetChan chan<-
 			tweet(*unsentLiquidation)
 			unsentLiquidation = nil
 
+		case done := <-flushChan:
+			refresh()
+			flush()
+			close(done)
+
+		case <-ctx.Done():
+			// Shutting down, liquidations still arriving are posted without combining
+			refresh()
+			flush()
+			for l := range liqChan {
+				newUnsent(l)
+				flush()
+			}
+			return
+
 		case l, ok := <-liqChan:
+			refresh()
 			if !ok {
+				flush()
 				return
 			}
 
-			log.Println("Got", l)
+			combinerLog.Debug("Got liquidation", "symbol", l.Symbol, "usd_value", l.TotalUSDValue, "liquidation", l.String())
+
+			// Liquidations in a cascade are posted together when it is over
+			if cascades.Observe(l, time.Now()) {
+				combinerLog.Debug("Part of a cascade", "symbol", l.Symbol, "usd_value", l.TotalUSDValue)
+				if unsentLiquidation != nil && cascades.Active(unsentLiquidation.Symbol, unsentLiquidation.Side) {
+					combinerLog.Debug("Adding to cascade", "symbol", unsentLiquidation.Symbol, "usd_value", unsentLiquidation.USDValue())
+					cascades.Absorb(*unsentLiquidation, unsentReceivedAt)
+
+					// Other contracts with the same underlying are not part of the cascade
+					for _, related := range unsentLiquidation.Related {
+						tweet(related)
+					}
+					unsentLiquidation = nil
+				}
+				continue
+			}
+
 			if unsentLiquidation == nil {
 				newUnsent(l)
 				continue
 			}
 
 			// Try and combine
-			if unsentLiquidation.CanCombine(l) {
-				log.Println("Combining", unsentLiquidation)
-				log.Println("With", l)
-				unsentLiquidation.Combine(l)
-				log.Println("Into", unsentLiquidation)
+			if unsentLiquidation.CanCombine(l, unsentPolicy) {
+				combined := unsentLiquidation.String()
+				unsentLiquidation.Combine(l, unsentPolicy)
+				combinerLog.Debug("Combined", "symbol", l.Symbol, "usd_value", unsentLiquidation.USDValue(),
+					"combined", combined, "with", l.String(), "into", unsentLiquidation.String())
 				continue
-			} else {
-				log.Println("Can't combine", unsentLiquidation, l)
 			}
 
+			combinerLog.Debug("Can't combine", "symbol", l.Symbol, "usd_value", l.TotalUSDValue, "pending", unsentLiquidation.String(), "with", l.String())
+
 			// Tweet the existing liquidation if it cannot be combined
 			tweet(*unsentLiquidation)
 			newUnsent(l)
@@ -286,130 +412,333 @@ func symbolLiquidator(state *State, liqChan <-chan Liquidation, tweetChan chan<-
 type preparedTweet struct {
 	timestamp time.Time
 	usdValue  float64
-	status    string
+	status    string // Posted as is if there is no liquidation
+	manual    bool   // Posted through the admin API, skipping the schedule and value cap
+
+	// Decorated liquidation or cascade, formatted by each publisher
+	liquidation *CombinedLiquidation
+	decoration  Decoration
+	cascade     *Cascade
+
+	// Optional PNG image card and its alt text, drawn by each publisher if withCard is set
+	withCard bool
+	card     []byte
+	altText  string
 }
 
-func liquidator(liqChan <-chan Liquidation, state *State, client *gotwi.Client) {
-	tweetChan := make(chan preparedTweet, 10000)
-	defer close(tweetChan)
-	go func() {
-		// https://developer.twitter.com/en/docs/twitter-api/tweets/manage-tweets/api-reference/post-tweets
-		// 200 requests in 15 min
-		// 1500 tweets per 30 days on free plan (50 daily)
-		//
-		// We don't want to blow all of it in a single day, cap at max 50 tweets a day and refil at 1 every 1728s (1500 / 30 days)
-		limiter := rate.NewLimiter(rate.Every(1728*time.Second), 50)
-
-		for status := range tweetChan {
-			var minValue float64
-			switch {
-			case limiter.Burst() < 5:
-				minValue = 5000000
-			case limiter.Burst() < 10:
-				minValue = 1000000
-			case limiter.Burst() < 25:
-				minValue = 100000
-			}
+// liquidatorControl reaches the running symbol liquidators from the admin API.
+type liquidatorControl struct {
+	mu      sync.Mutex
+	flushes map[chan chan struct{}]bool
+}
 
-			if status.usdValue < minValue {
-				log.Printf("Tweet dropped because of value cap: %v < %v\n", status.usdValue, minValue)
-				continue
-			}
+func newLiquidatorControl() *liquidatorControl {
+	return &liquidatorControl{flushes: make(map[chan chan struct{}]bool)}
+}
 
-			// Apply the rate limit
-			_ = limiter.Wait(context.Background())
+func (c *liquidatorControl) add() chan chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-			lag := time.Since(status.timestamp)
-			if client != nil {
-				res, err := managetweet.Create(context.Background(), client, &ctypes.CreateInput{
-					Text: gotwi.String(status.status),
-				})
-				if err != nil {
-					log.Println("Failed to tweet:", status.status, err)
-				} else {
-					if res.Data.ID != nil {
-						log.Printf("Sent tweet: %v: bursts %v: lag %v: '%v'\n", *res.Data.ID, limiter.Burst(), lag, status.status)
-					} else {
-						log.Printf("Sent tweet: (???): bursts %v: lag %v: '%v'\n", limiter.Burst(), lag, status.status)
-					}
-				}
-			} else {
-				log.Printf("Would have tweeted: lag %v: '%v'\n", lag, status.status)
-			}
+	flushChan := make(chan chan struct{})
+	c.flushes[flushChan] = true
+
+	return flushChan
+}
+
+func (c *liquidatorControl) remove(flushChan chan chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.flushes, flushChan)
+}
+
+// Flush posts everything the symbol liquidators are holding back for combining, returning how many were flushed.
+func (c *liquidatorControl) Flush(ctx context.Context) (int, error) {
+	c.mu.Lock()
+	var flushes []chan chan struct{}
+	for flushChan := range c.flushes {
+		flushes = append(flushes, flushChan)
+	}
+	c.mu.Unlock()
+
+	for _, flushChan := range flushes {
+		done := make(chan struct{})
+		select {
+		case flushChan <- done:
+		case <-ctx.Done():
+			return 0, ctx.Err()
 		}
-	}()
 
-	// Demultiplex this channel by the tickers
-	channels := make(map[Symbol]chan Liquidation)
-	defer func() {
-		for _, c := range channels {
-			close(c)
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+
+	return len(flushes), nil
+}
+
+// liquidator runs the pipeline until liqChan is closed, then waits for everything pending to reach the publishers.
+func liquidator(ctx context.Context, cfg *liveConfig, filter *liquidationFilter, control *liquidatorControl, liqChan <-chan Liquidation, state *State, publishers *publisherSet) {
+	tweetChan := make(chan preparedTweet, 10000)
+
+	publishers.start(ctx)
+
+	// Every publisher gets a copy of each post
+	go func() {
+		defer publishers.close()
+
+		for post := range tweetChan {
+			publishers.send(post)
 		}
 	}()
 
+	// Demultiplex this channel by the tickers, or the underlying if they are being grouped
+	channels := make(map[string]chan Liquidation)
+	var wg sync.WaitGroup
+
 	for l := range liqChan {
-		log.Printf("Detected liquidation: %+v\n", l)
+		pipelineLog.Debug("Detected liquidation", "symbol", l.Symbol, "usd_value", l.TotalUSDValue, "liquidation", l.String())
+
+		if reason, ok := filter.Allow(l); !ok {
+			pipelineLog.Debug("Filtered liquidation", "symbol", l.Symbol, "usd_value", l.TotalUSDValue, "reason", reason,
+				"filtered", filteredLiquidations.Get(reason).String())
+			continue
+		}
 
-		if channels[l.Symbol] == nil {
-			channels[l.Symbol] = make(chan Liquidation, 10000)
-			go symbolLiquidator(state, channels[l.Symbol], tweetChan)
+		key := string(l.Symbol)
+		if cfg.Load().Combining.GroupByUnderlying && l.Underlying != "" {
+			key = "underlying:" + l.Underlying
 		}
 
-		channels[l.Symbol] <- l
+		if channels[key] == nil {
+			channels[key] = make(chan Liquidation, 10000)
+
+			flushChan := control.add()
+
+			wg.Add(1)
+			go func(c <-chan Liquidation) {
+				defer wg.Done()
+				defer control.remove(flushChan)
+				symbolLiquidator(ctx, cfg, state, c, flushChan, tweetChan)
+			}(channels[key])
+		}
+
+		channels[key] <- l
+	}
+
+	for _, c := range channels {
+		close(c)
+	}
+	wg.Wait()
+
+	close(tweetChan)
+}
+
+// shutdown flushes the pipeline and saves everything to disk, giving up after the timeout.
+func shutdown(timeout time.Duration, liqChan chan Liquidation, liquidatorDone <-chan struct{}, publishers *publisherSet, queueFile string, state *State, orders *OrderStore) {
+	deadline := time.After(timeout)
+
+	// Let the liquidator flush what is pending to the publishers
+	close(liqChan)
+	select {
+	case <-liquidatorDone:
+	case <-deadline:
+		mainLog.Warn("Timed out waiting for pending liquidations to be flushed")
+	}
+
+	// Save what the publishers did not get to, only the digests of those which did not stop in time
+	workers := publishers.List()
+	for _, w := range workers {
+		select {
+		case <-w.done:
+		case <-deadline:
+			mainLog.Warn("Timed out waiting for publisher to stop, unpublished posts are lost", "publisher", w.name)
+		}
+	}
+
+	if err := saveQueues(queueFile, workers); err != nil {
+		stateLog.Error("Failed to save publisher queue", "err", err)
+	}
+
+	if err := state.Save(); err != nil {
+		stateLog.Error("Failed to save state", "err", err)
+	}
+
+	if err := state.Backup(); err != nil {
+		stateLog.Error("Failed to back up high scores", "err", err)
+	}
+
+	if err := orders.Save(); err != nil {
+		stateLog.Error("Failed to save order store", "err", err)
 	}
 }
 
 func main() {
-	log.SetFlags(log.Lshortfile | log.LstdFlags | log.Lmicroseconds)
+	if err := runCLI(os.Args[1:], os.Stdout); err != nil {
+		mainLog.Error(err.Error())
+		os.Exit(1)
+	}
+}
 
-	go func() {
-		log.Println("Listening on localhost:6060 (pprof)")
-		log.Println(http.ListenAndServe("localhost:6060", nil))
-	}()
+// runCommand runs the bot until it is stopped.
+func runCommand(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	flags := addConfigFlags(fs)
+	record := fs.String("record", "", "append the raw BitMex frames to this file, for replay")
+	fs.Parse(args)
 
-	cfg, err := loadConfig()
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+
+	cfg, err := flags.load()
 	if err != nil {
-		log.Fatal("Unable to load config:", err)
+		return fmt.Errorf("unable to load config: %w", err)
 	}
 
-	in := &gotwi.NewClientInput{
-		AuthenticationMethod: gotwi.AuthenMethodOAuth1UserContext,
-		APIKey:               cfg.TwitterConsumerKey,
-		APIKeySecret:         cfg.TwitterConsumerSecret,
-		OAuthToken:           cfg.TwitterAccessToken,
-		OAuthTokenSecret:     cfg.TwitterTokenSecret,
+	if err := cfg.resolveSecrets(); err != nil {
+		return fmt.Errorf("unable to read credentials: %w", err)
 	}
 
-	var client *gotwi.Client
-	if cfg.TwitterConsumerKey != "" {
-		client, err = gotwi.NewClient(in)
-		if err != nil {
-			log.Fatalln(err)
-		}
+	if err := checkConfig(cfg); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	paths, err := cfg.Paths()
+	if err != nil {
+		return err
+	}
+
+	paths.describe()
+	if err := paths.createStateDir(); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	workers, err := newPublisherWorkers(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create publishers: %w", err)
+	}
+	publishers := newPublisherSet(workers)
+
+	// The health checks go on the debug listener unless they have their own
+	health := newHealthMonitor(cfg.Health, publishers)
+	if cfg.Health.Listen == "" {
+		http.Handle("GET /healthz", health.Handler())
+		http.Handle("GET /readyz", health.Handler())
+	}
+
+	if addr := cfg.debugListen(); addr != "" {
+		go func() {
+			mainLog.Info("Listening (pprof)", "addr", addr)
+			mainLog.Error("Debug listener stopped", "err", http.ListenAndServe(addr, nil))
+		}()
+	}
+
+	state, err := newStateFor(cfg, paths)
+	if err != nil {
+		return err
+	}
 
-		u, err := userlookup.GetMe(context.Background(), client, &utypes.GetMeInput{})
+	filter, err := newLiquidationFilter(cfg.Filter)
+	if err != nil {
+		return fmt.Errorf("invalid filter: %w", err)
+	}
+
+	orders, err := NewOrderStore(paths.State(orderStoreFile), orderStoreTTL)
+	if err != nil {
+		return fmt.Errorf("failed to load order store: %w", err)
+	}
+
+	if err := loadQueues(paths.State(publisherQueueFile), workers); err != nil {
+		stateLog.Error("Failed to load publisher queue", "err", err)
+	}
+
+	var recording io.Writer
+	if *record != "" {
+		file, err := os.OpenFile(*record, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
 		if err != nil {
-			log.Fatalln(err)
+			return fmt.Errorf("failed to open recording: %w", err)
 		}
+		defer file.Close()
 
-		log.Println("Logged in as:", *u.Data.Username)
+		feedLog.Info("Recording frames", "file", *record)
+		recording = file
 	}
 
-	state, err := NewState()
-	if err != nil {
-		log.Fatalln("Failed to load state:", err)
+	// Shut down cleanly when systemd stops us
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// The pipeline picks up reloaded settings
+	live := newLiveConfig(cfg)
+	f := &feed{cfg: live, orders: orders, health: health}
+
+	// Reload the config and text on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	reload := &reloader{
+		path:       flags.path,
+		paths:      paths,
+		override:   flags.override,
+		publishers: publishers,
+		filter:     filter,
+		state:      state,
+		live:       live,
+		cfg:        cfg,
 	}
+	go reload.run(ctx, hup, time.Duration(cfg.ReloadInterval))
+
+	go orders.RunSweeper(ctx, 10*time.Second)
+	go state.RunSaver(ctx, highScoresSaveInterval, highScoresBackupInterval)
+	go logFilterSummary(ctx, filterSummaryInterval)
 
 	// Start the liquidator
 	liqChan := make(chan Liquidation, 1024)
-	defer close(liqChan)
+	liquidatorDone := make(chan struct{})
+	control := newLiquidatorControl()
 
-	go liquidator(liqChan, state, client)
+	go func() {
+		defer close(liquidatorDone)
+		liquidator(ctx, live, filter, control, liqChan, state, publishers)
+	}()
 
-	for {
-		if err := runClient(cfg, liqChan); err != nil {
-			log.Println("Error:", err, "reconnecting in 10 seconds")
-			time.Sleep(10 * time.Second)
+	if cfg.Admin.Listen != "" {
+		go runAdmin(ctx, cfg.Admin, &adminServer{
+			token:      cfg.Admin.Token,
+			publishers: publishers,
+			filter:     filter,
+			control:    control,
+			state:      state,
+		})
+	}
+
+	if cfg.Health.Listen != "" {
+		go runHealth(ctx, cfg.Health.Listen, health)
+	}
+
+	for ctx.Err() == nil {
+		if err := runClient(ctx, cfg.BitMexHost, f, recording, liqChan); err != nil && ctx.Err() == nil {
+			feedLog.Error("Disconnected from BitMex, reconnecting in 10 seconds", "err", err)
+
+			select {
+			case <-time.After(10 * time.Second):
+			case <-ctx.Done():
+			}
 		}
 	}
+
+	timeout := time.Duration(cfg.ShutdownTimeout)
+	if timeout == 0 {
+		timeout = defaultShutdownTimeout
+	}
+
+	mainLog.Info("Shutting down", "timeout", timeout)
+	shutdown(timeout, liqChan, liquidatorDone, publishers, paths.State(publisherQueueFile), state, orders)
+	mainLog.Info("Shut down")
+
+	return nil
 }
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"io/fs"
+	"math"
+	"sort"
+	"time"
+)
+
+// Records a liquidation can break, used in medal conditions.
+const (
+	RecordDay     = "day"
+	RecordWeek    = "week"
+	RecordMonth   = "month"
+	RecordYear    = "year"
+	RecordAllTime = "all_time"
+)
+
+// isRecordName returns true if the name is one of the records.
+func isRecordName(name string) bool {
+	switch name {
+	case RecordDay, RecordWeek, RecordMonth, RecordYear, RecordAllTime:
+		return true
+	}
+
+	return false
+}
+
+// A medal is never awarded more than this many times to one liquidation. It only guards against runaway repeat
+// expressions, the 100k medal is awarded for every 100k up to $100m as it always was.
+const maxMedalRepeat = 1000
+
+// Variables available in the repeat expression of a medal rule.
+var medalExprVars = []string{"usd", "quantity", "max_quantity", "min_quantity", "fills", "streak"}
+
+type (
+	// A Medal is awarded to the liquidation if it matches a medal rule.
+	Medal struct {
+		Name  string `json:"name"`
+		Emoji string `json:"emoji"`
+
+		// How the medal is drawn on image cards, since the font has no emojis.
+		Label       string `json:"label"`       // e.g. "WEEK"
+		Description string `json:"description"` // Used in the alt text, e.g. "largest this week"
+		Color       string `json:"color"`       // e.g. "#cd7f32"
+
+		// Higher priority medals are shown first, and are the last to be trimmed.
+		Priority int `json:"priority"`
+	}
+
+	// MedalRule awards a medal when its condition matches.
+	MedalRule struct {
+		Medal
+
+		When MedalCondition `json:"when"`
+
+		// Expression for how many times the medal is awarded, e.g. "usd / 100000", defaults to once.
+		// It may use usd, quantity, max_quantity, min_quantity, fills and streak, with + - * / ( ) floor min and max.
+		// The usd value includes related contracts, the rest are of the primary symbol.
+		Repeat string `json:"repeat"`
+	}
+
+	// MedalCondition is when a medal is awarded, every condition which is set must match.
+	MedalCondition struct {
+		// Range of the total USD value, 0 for no maximum.
+		MinUSD float64 `json:"min_usd"`
+		MaxUSD float64 `json:"max_usd"`
+
+		// One of the positions liquidated had exactly this quantity.
+		Quantity float64 `json:"quantity"`
+
+		// Number of positions liquidated on the symbol in a row, including these.
+		MinStreak int `json:"min_streak"`
+
+		// Time since the previous liquidation on the symbol.
+		MaxSinceLastKill Duration `json:"max_since_last_kill"`
+
+		// Any of these records were broken: day, week, month, year or all_time.
+		Records []string `json:"records"`
+
+		// Symbol patterns, in the same format as the filter.
+		Symbols []string `json:"symbols"`
+
+		// Positions liquidated: long or short.
+		Sides []string `json:"sides"`
+	}
+
+	// medalContext is what medal conditions are evaluated against.
+	medalContext struct {
+		Liquidation   CombinedLiquidation
+		Streak        int
+		SinceLastKill time.Duration // Negative if there is no previous liquidation
+		Records       map[string]bool
+	}
+
+	// medalRule is a compiled MedalRule.
+	medalRule struct {
+		MedalRule
+
+		symbols []symbolPattern
+		repeat  expr
+	}
+
+	// medalEngine awards medals using a set of rules.
+	medalEngine struct {
+		rules []medalRule
+	}
+)
+
+// parseHexColor parses a colour such as "#cd7f32".
+func parseHexColor(s string) (color.RGBA, error) {
+	c := color.RGBA{A: 0xff}
+	if _, err := fmt.Sscanf(s, "#%02x%02x%02x", &c.R, &c.G, &c.B); err != nil || len(s) != 7 {
+		return color.RGBA{}, fmt.Errorf("invalid colour %q, expected #rrggbb", s)
+	}
+
+	return c, nil
+}
+
+// newMedalEngine validates and compiles the medal rules.
+func newMedalEngine(rules []MedalRule) (*medalEngine, error) {
+	var e medalEngine
+
+	seen := make(map[string]bool)
+	for _, r := range rules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("medal without a name")
+		}
+
+		if seen[r.Name] {
+			return nil, fmt.Errorf("duplicate medal %q", r.Name)
+		}
+		seen[r.Name] = true
+
+		compiled, err := compileMedalRule(r)
+		if err != nil {
+			return nil, fmt.Errorf("medal %v: %w", r.Name, err)
+		}
+
+		e.rules = append(e.rules, compiled)
+	}
+
+	return &e, nil
+}
+
+func compileMedalRule(r MedalRule) (medalRule, error) {
+	compiled := medalRule{MedalRule: r}
+
+	if r.Color != "" {
+		if _, err := parseHexColor(r.Color); err != nil {
+			return medalRule{}, err
+		}
+	}
+
+	for _, record := range r.When.Records {
+		if !isRecordName(record) {
+			return medalRule{}, fmt.Errorf("unknown record %q", record)
+		}
+	}
+
+	for _, side := range r.When.Sides {
+		if side != "long" && side != "short" {
+			return medalRule{}, fmt.Errorf("unknown side %q, expected long or short", side)
+		}
+	}
+
+	var err error
+	if compiled.symbols, err = compileSymbolPatterns(r.When.Symbols); err != nil {
+		return medalRule{}, err
+	}
+
+	repeat := r.Repeat
+	if repeat == "" {
+		repeat = "1"
+	}
+
+	if compiled.repeat, err = compileExpr(repeat, medalExprVars); err != nil {
+		return medalRule{}, err
+	}
+
+	return compiled, nil
+}
+
+// loadMedals loads the medal rules of the text, using the built in rules if the text has none.
+func loadMedals(text fs.FS) (*medalEngine, error) {
+	e, err := loadMedalEngine(text, medalsFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return loadMedalEngine(builtinText, medalsFile)
+	}
+
+	return e, err
+}
+
+// loadMedalEngine loads the medal rules from a JSON file.
+func loadMedalEngine(fsys fs.FS, path string) (*medalEngine, error) {
+	raw, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []MedalRule
+	if err := json.Unmarshal(raw, &rules); err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+
+	e, err := newMedalEngine(rules)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+
+	return e, nil
+}
+
+// Match returns true if the condition holds.
+func (r medalRule) Match(ctx medalContext) bool {
+	w := r.When
+	cl := ctx.Liquidation
+	usd := cl.USDValue()
+
+	if usd < w.MinUSD || (w.MaxUSD > 0 && usd > w.MaxUSD) {
+		return false
+	}
+
+	if w.Quantity != 0 && !cl.hasQuantity(w.Quantity) {
+		return false
+	}
+
+	if ctx.Streak < w.MinStreak {
+		return false
+	}
+
+	if w.MaxSinceLastKill > 0 && (ctx.SinceLastKill < 0 || ctx.SinceLastKill > time.Duration(w.MaxSinceLastKill)) {
+		return false
+	}
+
+	if len(w.Records) > 0 {
+		broken := false
+		for _, record := range w.Records {
+			broken = broken || ctx.Records[record]
+		}
+
+		if !broken {
+			return false
+		}
+	}
+
+	if len(r.symbols) > 0 && !matchAny(r.symbols, cl.Symbol) {
+		return false
+	}
+
+	if len(w.Sides) > 0 && !containsFold(w.Sides, positionName(cl.Side)) {
+		return false
+	}
+
+	return true
+}
+
+// Count returns how many times the medal is awarded.
+func (r medalRule) Count(ctx medalContext) int {
+	cl := ctx.Liquidation
+	n := r.repeat(map[string]float64{
+		"usd":          cl.USDValue(),
+		"quantity":     cl.TotalQuantity(),
+		"max_quantity": cl.MaxQuantity(),
+		"min_quantity": cl.MinQuantity(),
+		"fills":        float64(len(cl.Liquidations)),
+		"streak":       float64(ctx.Streak),
+	})
+
+	if math.IsNaN(n) || n < 0 {
+		return 0
+	}
+
+	return int(math.Min(math.Floor(n), maxMedalRepeat))
+}
+
+// Award returns the medals for a liquidation, highest priority first.
+func (e *medalEngine) Award(ctx medalContext) []Medal {
+	if e == nil {
+		return nil
+	}
+
+	var medals []Medal
+	for _, r := range e.rules {
+		if !r.Match(ctx) {
+			continue
+		}
+
+		for i := 0; i < r.Count(ctx); i++ {
+			medals = append(medals, r.Medal)
+		}
+	}
+
+	sort.SliceStable(medals, func(i, j int) bool {
+		return medals[i].Priority > medals[j].Priority
+	})
+
+	return medals
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMedalEngine(t *testing.T) {
+	e, err := newMedalEngine([]MedalRule{
+		{Medal: Medal{Name: "100k", Emoji: "A", Priority: 1}, When: MedalCondition{MinUSD: 100000}, Repeat: "usd / 100000"},
+		{Medal: Medal{Name: "one", Emoji: "B", Priority: 5}, When: MedalCondition{Quantity: 1}},
+		{Medal: Medal{Name: "fast", Emoji: "C"}, When: MedalCondition{MaxSinceLastKill: Duration(10 * time.Second)}},
+		{Medal: Medal{Name: "eth_short_record", Emoji: "D", Priority: 10}, When: MedalCondition{
+			Records: []string{RecordWeek, RecordMonth},
+			Symbols: []string{"ETH*"},
+			Sides:   []string{"short"},
+		}},
+		{Medal: Medal{Name: "mid", Emoji: "E"}, When: MedalCondition{MinUSD: 1000, MaxUSD: 5000, MinStreak: 3}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(medals []Medal) (s string) {
+		for _, m := range medals {
+			s += m.Emoji
+		}
+		return s
+	}
+
+	ethShort := testLiquidation("ETHUSD", "Buy", 350000).ToCombined()
+	ethShort.Liquidations = append(ethShort.Liquidations, PriceQuantity{Quantity: 1})
+
+	table := []struct {
+		Name     string
+		Context  medalContext
+		Expected string
+	}{
+		{"nothing", medalContext{Liquidation: testLiquidation("XBTUSD", "Sell", 500).ToCombined(), SinceLastKill: -1}, ""},
+		{"repeated", medalContext{Liquidation: testLiquidation("XBTUSD", "Sell", 250000).ToCombined(), SinceLastKill: -1}, "AA"},
+		{"not capped", medalContext{Liquidation: testLiquidation("XBTUSD", "Sell", 5e7).ToCombined(), SinceLastKill: -1}, strings.Repeat("A", 500)},
+		{"capped", medalContext{Liquidation: testLiquidation("XBTUSD", "Sell", 1e12).ToCombined(), SinceLastKill: -1}, strings.Repeat("A", maxMedalRepeat)},
+		{"fast", medalContext{Liquidation: testLiquidation("XBTUSD", "Sell", 500).ToCombined(), SinceLastKill: 10 * time.Second}, "C"},
+		{"too slow", medalContext{Liquidation: testLiquidation("XBTUSD", "Sell", 500).ToCombined(), SinceLastKill: 11 * time.Second}, ""},
+		{"usd range and streak", medalContext{Liquidation: testLiquidation("XBTUSD", "Sell", 2000).ToCombined(), Streak: 3, SinceLastKill: -1}, "E"},
+		{"streak too short", medalContext{Liquidation: testLiquidation("XBTUSD", "Sell", 2000).ToCombined(), Streak: 2, SinceLastKill: -1}, ""},
+		{"above usd range", medalContext{Liquidation: testLiquidation("XBTUSD", "Sell", 6000).ToCombined(), Streak: 3, SinceLastKill: -1}, ""},
+		{"record by priority", medalContext{Liquidation: ethShort, SinceLastKill: -1, Records: map[string]bool{RecordMonth: true}}, "DBAAA"},
+		{"record wrong side", medalContext{Liquidation: testLiquidation("ETHUSD", "Sell", 500).ToCombined(), SinceLastKill: -1, Records: map[string]bool{RecordMonth: true}}, ""},
+		{"record wrong symbol", medalContext{Liquidation: testLiquidation("XBTUSD", "Buy", 500).ToCombined(), SinceLastKill: -1, Records: map[string]bool{RecordMonth: true}}, ""},
+		{"other record", medalContext{Liquidation: testLiquidation("ETHUSD", "Buy", 500).ToCombined(), SinceLastKill: -1, Records: map[string]bool{RecordDay: true}}, ""},
+	}
+
+	for _, v := range table {
+		if result := names(e.Award(v.Context)); result != v.Expected {
+			t.Errorf("%v: expected %q got %q", v.Name, v.Expected, result)
+		}
+	}
+}
+
+func TestMedalEngineInvalid(t *testing.T) {
+	table := map[string]MedalRule{
+		"no name":      {},
+		"bad record":   {Medal: Medal{Name: "a"}, When: MedalCondition{Records: []string{"decade"}}},
+		"bad side":     {Medal: Medal{Name: "a"}, When: MedalCondition{Sides: []string{"up"}}},
+		"bad symbol":   {Medal: Medal{Name: "a"}, When: MedalCondition{Symbols: []string{"re:("}}},
+		"bad repeat":   {Medal: Medal{Name: "a"}, Repeat: "usd *"},
+		"bad variable": {Medal: Medal{Name: "a"}, Repeat: "price"},
+		"bad colour":   {Medal: Medal{Name: "a", Color: "red"}},
+	}
+
+	for name, rule := range table {
+		if _, err := newMedalEngine([]MedalRule{rule}); err == nil {
+			t.Errorf("%v: expected an error", name)
+		}
+	}
+
+	if _, err := newMedalEngine([]MedalRule{{Medal: Medal{Name: "a"}}, {Medal: Medal{Name: "a"}}}); err == nil {
+		t.Error("expected duplicate names to be refused")
+	}
+
+	// The shipped rules must load
+	if _, err := loadMedalEngine(builtinText, medalsFile); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+//go:embed text
+var builtinTextFS embed.FS
+
+// builtinText is the snark, kill streaks and medals built into the binary, so it runs from anywhere.
+var builtinText, _ = fs.Sub(builtinTextFS, "text")
+
+// Files in the state directory, unpublished posts are saved on shutdown and queued again on start.
+const (
+	highScoresFile     = "high_scores.json"
+	orderStoreFile     = "seen_orders.json"
+	publisherQueueFile = "publisher_queue.json"
+)
+
+// Files in the text directory, translations are in a subdirectory named after the locale.
+const (
+	snarkFile     = "memes.txt"
+	multiKillFile = "kill_streaks.txt"
+	medalsFile    = "medals.json"
+)
+
+// Default address for pprof and expvar.
+const defaultDebugListen = "localhost:6060"
+
+// Paths is where the bot keeps its state and finds its text.
+type Paths struct {
+	StateDir string // High scores, seen orders and unpublished posts
+	TextDir  string // Snark, kill streaks and medals, the built in text is used if empty
+}
+
+// Paths works out the state and text directories, in order of preference:
+//
+//	state: state_dir, $STATE_DIRECTORY set by systemd, the working directory if it has high scores from
+//	       before the state directory existed, $XDG_STATE_HOME/rekt or ~/.local/state/rekt
+//	text:  text_dir, ./text if it exists, $XDG_DATA_HOME/rekt/text or ~/.local/share/rekt/text if it exists,
+//	       the text built into the binary
+func (cfg BotConfig) Paths() (Paths, error) {
+	p := Paths{StateDir: cfg.StateDir, TextDir: cfg.TextDir}
+
+	if p.StateDir == "" {
+		// systemd may give a colon separated list, the first is ours
+		p.StateDir, _, _ = strings.Cut(os.Getenv("STATE_DIRECTORY"), ":")
+	}
+
+	if p.StateDir == "" && fileExists(highScoresFile) {
+		p.StateDir = "."
+	}
+
+	if p.StateDir == "" {
+		dir, err := xdgDir("XDG_STATE_HOME", ".local/state")
+		if err != nil {
+			return Paths{}, fmt.Errorf("no state directory: %w", err)
+		}
+		p.StateDir = dir
+	}
+
+	if p.TextDir == "" && fileExists(filepath.Join("text", snarkFile)) {
+		p.TextDir = "text"
+	}
+
+	if p.TextDir == "" {
+		if dir, err := xdgDir("XDG_DATA_HOME", ".local/share"); err == nil && fileExists(filepath.Join(dir, "text", snarkFile)) {
+			p.TextDir = filepath.Join(dir, "text")
+		}
+	}
+
+	return p, nil
+}
+
+// xdgDir returns the rekt directory in an XDG base directory, falling back to the default under the home directory.
+func xdgDir(env, fallback string) (string, error) {
+	if dir := os.Getenv(env); dir != "" {
+		return filepath.Join(dir, "rekt"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, fallback, "rekt"), nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// State returns the path of a file in the state directory.
+func (p Paths) State(name string) string {
+	return filepath.Join(p.StateDir, name)
+}
+
+// Text returns the text files.
+func (p Paths) Text() fs.FS {
+	if p.TextDir == "" {
+		return builtinText
+	}
+
+	return os.DirFS(p.TextDir)
+}
+
+// textFiles returns the text files on disk to watch for changes, none if the built in text is used.
+func (p Paths) textFiles(locales []string) []string {
+	if p.TextDir == "" {
+		return nil
+	}
+
+	files := []string{snarkFile, multiKillFile, medalsFile}
+	for _, locale := range locales {
+		if locale != "" && locale != englishLocale.Name {
+			snark, multiKill := translationFiles(locale)
+			files = append(files, snark, multiKill)
+		}
+	}
+
+	for i, name := range files {
+		files[i] = filepath.Join(p.TextDir, filepath.FromSlash(name))
+	}
+
+	return files
+}
+
+// describe logs where everything is.
+func (p Paths) describe() {
+	text := p.TextDir
+	if text == "" {
+		text = "built in"
+	}
+
+	mainLog.Info("Paths", "state_dir", p.StateDir, "text", text)
+}
+
+// createStateDir makes sure the state directory exists.
+func (p Paths) createStateDir() error {
+	return os.MkdirAll(p.StateDir, 0755)
+}
+
+// translationFiles returns the snark and kill streak files of a locale in <locale>/ of the text.
+func translationFiles(locale string) (snark, multiKill string) {
+	return path.Join(locale, snarkFile), path.Join(locale, multiKillFile)
+}
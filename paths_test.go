@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// chdir changes the working directory for the rest of the test.
+func chdir(t *testing.T, dir string) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestPaths(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+
+	t.Setenv("STATE_DIRECTORY", "")
+	t.Setenv("XDG_STATE_HOME", filepath.Join(dir, "state"))
+	t.Setenv("XDG_DATA_HOME", filepath.Join(dir, "data"))
+
+	paths := func(cfg BotConfig) Paths {
+		t.Helper()
+
+		p, err := cfg.Paths()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return p
+	}
+
+	// Nothing on disk, XDG directories and the built in text
+	if p := paths(BotConfig{}); p.StateDir != filepath.Join(dir, "state", "rekt") || p.TextDir != "" {
+		t.Fatalf("unexpected defaults %+v", p)
+	}
+
+	// Text installed in the XDG data directory
+	installed := filepath.Join(dir, "data", "rekt", "text")
+	if err := os.MkdirAll(installed, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(installed, snarkFile), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if p := paths(BotConfig{}); p.TextDir != installed {
+		t.Fatalf("expected the installed text, got %+v", p)
+	}
+
+	// High scores from before there was a state directory keep being used
+	if err := os.WriteFile(highScoresFile, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if p := paths(BotConfig{}); p.StateDir != "." {
+		t.Fatalf("expected the working directory, got %+v", p)
+	}
+
+	// systemd's StateDirectory= is preferred to that, and the config to everything
+	t.Setenv("STATE_DIRECTORY", "/var/lib/rekt:/var/lib/other")
+	if p := paths(BotConfig{}); p.StateDir != "/var/lib/rekt" {
+		t.Fatalf("expected the systemd state directory, got %+v", p)
+	}
+
+	if p := paths(BotConfig{StateDir: "/srv/rekt", TextDir: "/srv/text"}); p.StateDir != "/srv/rekt" || p.TextDir != "/srv/text" {
+		t.Fatalf("expected the configured directories, got %+v", p)
+	}
+}
+
+func TestConfigEnv(t *testing.T) {
+	path := writeTestConfig(t, `{"state_dir": "/srv/rekt", "debug_listen": "localhost:7070", "admin": {"listen": "localhost:6061"}}`)
+
+	t.Setenv("REKT_STATE_DIR", "/var/lib/rekt")
+	t.Setenv("REKT_DEBUG_LISTEN", "off")
+
+	cfg, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.StateDir != "/var/lib/rekt" || cfg.debugListen() != "" || cfg.Admin.Listen != "localhost:6061" {
+		t.Fatalf("environment not applied: %+v", cfg)
+	}
+}
+
+func TestBuiltinText(t *testing.T) {
+	for _, name := range []string{snarkFile, multiKillFile, medalsFile, "es/memes.txt", "ja/kill_streaks.txt"} {
+		if _, err := fs.Stat(builtinText, name); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// The state loads without any files on disk
+	chdir(t, t.TempDir())
+
+	if _, err := NewState(nil, Paths{StateDir: "."}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Text directories without medal rules use the built in ones
+	os.Mkdir("text", 0755)
+	os.WriteFile(filepath.Join("text", snarkFile), []byte("rekt\n"), 0644)
+	os.WriteFile(filepath.Join("text", multiKillFile), []byte("Double kill\n"), 0644)
+
+	state, err := NewState(nil, Paths{StateDir: ".", TextDir: "text"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if medals := state.Medals.Award(medalContext{Liquidation: testLiquidation("XBTUSD", "Sell", 200000).ToCombined(), SinceLastKill: -1}); len(medals) == 0 {
+		t.Fatal("expected the built in medals")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic replaces a file by writing to a temporary file first, so a crash never leaves a partial file behind.
+// The file keeps its permissions, new files are created 0644.
+func writeFileAtomic(path string, data []byte) error {
+	mode := fs.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	// CreateTemp makes the file 0600
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	// Make sure the data is on disk before it replaces the old file
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+// backupFile copies a file to path.1, shifting the older backups along to path.N.
+func backupFile(path string, backups int) error {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for n := backups - 1; n >= 1; n-- {
+		if err := os.Rename(backupPath(path, n), backupPath(path, n+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return writeFileAtomic(backupPath(path, 1), raw)
+}
+
+// backupPath returns the path of the nth backup of a file.
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%v.%d", path, n)
+}
+
+// syncDir flushes a directory so renames in it survive a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// readFileRotated decodes a file backed up by backupFile, falling back to the newest backup which can be decoded.
+// It returns os.ErrNotExist if neither the file nor any of its backups exist.
+func readFileRotated(path string, backups int, decode func(raw []byte) error) (usedPath string, err error) {
+	var firstErr error
+	for n := 0; n <= backups; n++ {
+		p := path
+		if n > 0 {
+			p = backupPath(path, n)
+		}
+
+		raw, err := os.ReadFile(p)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if err == nil {
+			if err = decode(raw); err == nil {
+				return p, nil
+			}
+		}
+
+		if firstErr == nil {
+			firstErr = fmt.Errorf("%v: %w", p, err)
+		}
+	}
+
+	if firstErr == nil {
+		return "", os.ErrNotExist
+	}
+
+	return "", firstErr
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// writeBackedUp writes a file after backing up the previous version.
+func writeBackedUp(t *testing.T, path string, data string, backups int) {
+	if err := backupFile(path, backups); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeFileAtomic(path, []byte(data)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackupFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+
+	for i := 1; i <= 5; i++ {
+		writeBackedUp(t, path, strconv.Itoa(i), 3)
+	}
+
+	expected := map[string]string{
+		path:                "5",
+		backupPath(path, 1): "4",
+		backupPath(path, 2): "3",
+		backupPath(path, 3): "2",
+	}
+
+	for p, v := range expected {
+		raw, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(raw) != v {
+			t.Errorf("%v: expected %v got %v", p, v, string(raw))
+		}
+	}
+
+	if _, err := os.Stat(backupPath(path, 4)); !os.IsNotExist(err) {
+		t.Fatal("expected only 3 backups", err)
+	}
+
+	// No temporary files are left behind
+	if matches, _ := filepath.Glob(path + ".tmp*"); len(matches) != 0 {
+		t.Fatal("temporary files left behind", matches)
+	}
+}
+
+func TestWriteFileAtomicMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+
+	if err := writeFileAtomic(path, []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0644 {
+		t.Fatal("expected a new file to be 0644", info, err)
+	}
+
+	// The permissions of an existing file are kept
+	if err := os.Chmod(path, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeFileAtomic(path, []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0640 {
+		t.Fatal("expected the mode to be kept", info, err)
+	}
+}
+
+func TestReadFileRotated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+
+	decode := func(v *int) func([]byte) error {
+		return func(raw []byte) error { return json.Unmarshal(raw, v) }
+	}
+
+	var v int
+	if _, err := readFileRotated(path, 3, decode(&v)); !os.IsNotExist(err) {
+		t.Fatal("expected not exist", err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		writeBackedUp(t, path, strconv.Itoa(i), 3)
+	}
+
+	// Corrupt the latest two versions, the newest valid backup is used
+	os.WriteFile(path, []byte("3 trailing garbage"), 0644)
+	os.WriteFile(backupPath(path, 1), []byte("{"), 0644)
+
+	used, err := readFileRotated(path, 3, decode(&v))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if used != backupPath(path, 2) || v != 1 {
+		t.Fatal("expected the second backup", used, v)
+	}
+
+	// Nothing valid left
+	os.WriteFile(backupPath(path, 2), []byte("}"), 0644)
+	if _, err := readFileRotated(path, 3, decode(&v)); err == nil || os.IsNotExist(err) {
+		t.Fatal("expected a decode error", err)
+	}
+}
+
+func TestLoadHighScores(t *testing.T) {
+	dir := t.TempDir()
+
+	// Files from before the schema version are migrated, quantity based records are kept for both sides
+	now := time.Now().UTC()
+	_, week := now.ISOWeek()
+
+	old := filepath.Join(dir, "old.json")
+	os.WriteFile(old, []byte(fmt.Sprintf(`{"scores":{"XBTUSD":{"highest_day":10,"highest_week":20,"highest_month":30,`+
+		`"last_day":%v,"last_week":%v,"last_month":%v}},"kills":{"XBTUSD":{"count":2,"unix_time":1}}}`, now.Day(), week, int(now.Month()))), 0644)
+
+	hs, err := loadHighScores(old, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hs.Version != highScoresVersion || hs.Kills["XBTUSD"].Count != 2 {
+		t.Fatalf("unexpected migration %+v", hs)
+	}
+
+	for _, side := range []SideScores{hs.Scores["XBTUSD"].Long, hs.Scores["XBTUSD"].Short} {
+		if side.Day.USDValue != 10 || side.Week.USDValue != 20 || side.Month.USDValue != 30 || side.DayKey != dayKey(now) || side.MonthKey != monthKey(now) {
+			t.Fatalf("unexpected migration %+v", side)
+		}
+	}
+
+	// The records still stand
+	s := &State{
+		HighScores: hs,
+		Corpus:     Corpus{MultiKill: []string{"Double kill"}},
+		Medals:     testMedalEngine(t),
+	}
+	s.Decorate(testLiquidation("XBTUSD", "Sell", 5).ToCombined())
+
+	if scores, _ := s.SymbolScores("XBTUSD"); scores.Long.Day.USDValue != 10 || scores.Long.Month.USDValue != 30 {
+		t.Fatalf("expected the migrated records to stand %+v", scores.Long)
+	}
+
+	// Files from the future are not understood
+	future := filepath.Join(dir, "future.json")
+	os.WriteFile(future, []byte(`{"version":1000}`), 0644)
+
+	if _, err := loadHighScores(future, time.UTC); err == nil {
+		t.Fatal("expected newer versions to be refused")
+	}
+
+	// Missing files start afresh
+	hs, err = loadHighScores(filepath.Join(dir, "missing.json"), time.UTC)
+	if err != nil || len(hs.Scores) != 0 || hs.Version != highScoresVersion {
+		t.Fatal("expected empty high scores", hs, err)
+	}
+}
+
+func TestStateSaveAndBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "high_scores.json")
+	s := &State{
+		SaveFile:   path,
+		HighScores: newHighScores(),
+		Corpus:     Corpus{MultiKill: []string{"Double kill"}},
+		Medals:     testMedalEngine(t),
+	}
+
+	// Liquidations are saved by the saver rather than each time
+	s.Decorate(testLiquidation("XBTUSD", "Sell", 1000).ToCombined())
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("expected nothing saved yet", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := s.saveChanged(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.Backup(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Backed up once, as nothing changed since
+	if _, err := os.Stat(backupPath(path, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(backupPath(path, 2)); !os.IsNotExist(err) {
+		t.Fatal("expected a single backup", err)
+	}
+
+	hs, err := loadHighScores(path, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hs.Kills["XBTUSD"].Count != 1 {
+		t.Fatalf("expected the kill to be saved %+v", hs.Kills)
+	}
+}
@@ -0,0 +1,729 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/michimani/gotwi"
+	"github.com/michimani/gotwi/tweet/managetweet"
+	ctypes "github.com/michimani/gotwi/tweet/managetweet/types"
+	"github.com/michimani/gotwi/user/userlookup"
+	utypes "github.com/michimani/gotwi/user/userlookup/types"
+)
+
+// Publisher types.
+const (
+	PublisherTwitter = "twitter" // Posts to a Twitter account
+	PublisherLog     = "log"     // Logs what would have been posted
+)
+
+type (
+	// PublisherConfig configures an output liquidations are posted to.
+	PublisherConfig struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+
+		// Twitter credentials, the top level credentials are used if these are empty.
+		TwitterConsumerKey    string `json:"twitter_consumer_key"`
+		TwitterConsumerSecret string `json:"twitter_consumer_secret"`
+		TwitterAccessToken    string `json:"twitter_access_token"`
+		TwitterTokenSecret    string `json:"twitter_token_secret"`
+
+		// Language posts are written in: "en" (default), "es" or "ja".
+		Locale string `json:"locale"`
+
+		// Most posts a day, refilled evenly over the day, 50 if zero. Publishers allowed fewer posts only post
+		// larger liquidations.
+		PostsPerDay int `json:"posts_per_day"`
+
+		Schedule ScheduleConfig `json:"schedule"`
+		Format   FormatConfig   `json:"format"`
+	}
+
+	// Publisher posts prepared liquidations to an output.
+	Publisher interface {
+		Publish(ctx context.Context, post preparedTweet) error
+	}
+
+	// postLengther is implemented by publishers which count the length of posts differently from Twitter.
+	postLengther interface {
+		PostLength(text string) int
+	}
+
+	// twitterPublisher tweets.
+	twitterPublisher struct {
+		client *gotwi.Client
+	}
+
+	// logPublisher only logs, used when there are no credentials.
+	logPublisher struct{}
+
+	// publisherSet is the running publishers, which change when the config is reloaded.
+	publisherSet struct {
+		mu      sync.RWMutex
+		workers []*publisherWorker
+		ctx     context.Context // Publishers added once started run until this is done
+		closed  bool
+	}
+
+	// publisherWorker applies the rate limits and schedule of a publisher to its queue.
+	publisherWorker struct {
+		name      string
+		publisher Publisher
+		schedule  *schedule
+		format    *postFormatter
+		queue     chan preparedTweet
+
+		limiter     *rate.Limiter
+		postsPerDay int
+
+		// Posts left unpublished at shutdown, saved to the publisher queue file
+		pending []preparedTweet
+		done    chan struct{}
+
+		// Paused publishers hold on to their posts until they are resumed, manual posts are still published.
+		// Also guards the digest, and the publisher, schedule and format which are replaced when the config is reloaded.
+		mu     sync.Mutex
+		paused bool
+		held   []preparedTweet
+		digest digest
+		wake   chan struct{}
+		manual chan preparedTweet
+
+		// Publishes which failed in a row and the last error, for the health checks
+		failures  int
+		lastError string
+	}
+)
+
+// publisherConfigs returns the configured publishers, defaulting to a single publisher using the top level credentials.
+func (cfg BotConfig) publisherConfigs() []PublisherConfig {
+	if len(cfg.Publishers) > 0 {
+		return cfg.Publishers
+	}
+
+	if cfg.TwitterConsumerKey != "" {
+		return []PublisherConfig{{Name: "twitter", Type: PublisherTwitter}}
+	}
+
+	return []PublisherConfig{{Name: "log", Type: PublisherLog}}
+}
+
+// newTwitterPublisher logs in to Twitter.
+func newTwitterPublisher(cfg BotConfig, pc PublisherConfig) (*twitterPublisher, error) {
+	creds := cfg.publisherCredentials(pc)
+	in := &gotwi.NewClientInput{
+		AuthenticationMethod: gotwi.AuthenMethodOAuth1UserContext,
+		APIKey:               creds[0],
+		APIKeySecret:         creds[1],
+		OAuthToken:           creds[2],
+		OAuthTokenSecret:     creds[3],
+	}
+
+	client, err := gotwi.NewClient(in)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := userlookup.GetMe(context.Background(), client, &utypes.GetMeInput{})
+	if err != nil {
+		return nil, err
+	}
+
+	publisherLog.Info("Logged in to Twitter", "publisher", pc.Name, "username", *u.Data.Username)
+
+	return &twitterPublisher{client}, nil
+}
+
+// publisherSettings compiles the schedule and format of a publisher, which can be changed while running.
+func publisherSettings(pc PublisherConfig, publisher Publisher) (*schedule, *postFormatter, error) {
+	sched, err := newSchedule(pc.Schedule)
+	if err != nil {
+		return nil, nil, fmt.Errorf("publisher %v: %w", pc.Name, err)
+	}
+
+	locale, err := findLocale(pc.Locale)
+	if err != nil {
+		return nil, nil, fmt.Errorf("publisher %v: %w", pc.Name, err)
+	}
+
+	format, err := newPostFormatter(pc.Format, locale)
+	if err != nil {
+		return nil, nil, fmt.Errorf("publisher %v: format: %w", pc.Name, err)
+	}
+
+	if l, ok := publisher.(postLengther); ok {
+		format.length = l.PostLength
+	}
+
+	return sched, format, nil
+}
+
+// newPublisher connects to the output of a publisher.
+func newPublisher(cfg BotConfig, pc PublisherConfig) (Publisher, error) {
+	switch pc.Type {
+	case PublisherTwitter:
+		p, err := newTwitterPublisher(cfg, pc)
+		if err != nil {
+			return nil, fmt.Errorf("publisher %v: %w", pc.Name, err)
+		}
+		return p, nil
+
+	case PublisherLog:
+		return logPublisher{}, nil
+	}
+
+	return nil, fmt.Errorf("publisher %v: unknown type %q", pc.Name, pc.Type)
+}
+
+// newConfiguredWorker connects a publisher and creates its worker.
+func newConfiguredWorker(cfg BotConfig, pc PublisherConfig) (*publisherWorker, error) {
+	publisher, err := newPublisher(cfg, pc)
+	if err != nil {
+		return nil, err
+	}
+
+	sched, format, err := publisherSettings(pc, publisher)
+	if err != nil {
+		return nil, err
+	}
+
+	w := newPublisherWorker(pc.Name, publisher, sched, format)
+	w.setPostsPerDay(pc.postsPerDay())
+
+	return w, nil
+}
+
+// newPublisherWorkers creates the workers for every configured publisher.
+func newPublisherWorkers(cfg BotConfig) ([]*publisherWorker, error) {
+	var workers []*publisherWorker
+
+	seen := make(map[string]bool)
+	for _, pc := range cfg.publisherConfigs() {
+		if seen[pc.Name] {
+			return nil, fmt.Errorf("duplicate publisher name %q", pc.Name)
+		}
+		seen[pc.Name] = true
+
+		w, err := newConfiguredWorker(cfg, pc)
+		if err != nil {
+			return nil, err
+		}
+
+		workers = append(workers, w)
+	}
+
+	return workers, nil
+}
+
+// newPublisherWorker creates a worker with an empty queue.
+func newPublisherWorker(name string, publisher Publisher, sched *schedule, format *postFormatter) *publisherWorker {
+	return &publisherWorker{
+		name:      name,
+		publisher: publisher,
+		schedule:  sched,
+		format:    format,
+		queue:     make(chan preparedTweet, 10000),
+		done:      make(chan struct{}),
+		wake:      make(chan struct{}, 1),
+		manual:    make(chan preparedTweet, 100),
+
+		limiter: rate.NewLimiter(postsPerDayLimit(defaultPostsPerDay), defaultPostsPerDay),
+	}
+}
+
+// https://developer.twitter.com/en/docs/twitter-api/tweets/manage-tweets/api-reference/post-tweets
+// 200 requests in 15 min
+// 1500 tweets per 30 days on free plan (50 daily)
+//
+// We don't want to blow all of it in a single day, cap at max 50 tweets a day and refil at 1 every 1728s (1500 / 30 days)
+const defaultPostsPerDay = 50
+
+// postsPerDayLimit is the rate posts are refilled at.
+func postsPerDayLimit(n int) rate.Limit {
+	return rate.Every(24 * time.Hour / time.Duration(n))
+}
+
+// postsPerDay returns the most posts a day, defaulting to 50.
+func (pc PublisherConfig) postsPerDay() int {
+	if pc.PostsPerDay > 0 {
+		return pc.PostsPerDay
+	}
+
+	return defaultPostsPerDay
+}
+
+// errPublishersClosed is returned when adding a publisher while shutting down.
+var errPublishersClosed = errors.New("shutting down")
+
+func newPublisherSet(workers []*publisherWorker) *publisherSet {
+	return &publisherSet{workers: workers}
+}
+
+// List returns the publishers.
+func (s *publisherSet) List() []*publisherWorker {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return slices.Clone(s.workers)
+}
+
+// Find returns the publisher with the name, or nil.
+func (s *publisherSet) Find(name string) *publisherWorker {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, w := range s.workers {
+		if w.name == name {
+			return w
+		}
+	}
+
+	return nil
+}
+
+// start runs the publishers until the context is done, including those added later.
+func (s *publisherSet) start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ctx = ctx
+	for _, w := range s.workers {
+		go w.run(ctx)
+	}
+}
+
+// send queues a post on every publisher.
+func (s *publisherSet) send(post preparedTweet) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, w := range s.workers {
+		w.queue <- post
+	}
+}
+
+// close closes the queues once nothing more will be sent, so the publishers stop.
+func (s *publisherSet) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for _, w := range s.workers {
+		close(w.queue)
+	}
+}
+
+// add starts a new publisher.
+func (s *publisherSet) add(w *publisherWorker) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errPublishersClosed
+	}
+
+	s.workers = append(s.workers, w)
+	if s.ctx != nil {
+		go w.run(s.ctx)
+	}
+
+	return nil
+}
+
+// remove stops a publisher once it has published what is in its queue, posts held while paused are dropped.
+func (s *publisherSet) remove(w *publisherWorker) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.Index(s.workers, w)
+	if i < 0 || s.closed {
+		return
+	}
+
+	s.workers = slices.Delete(s.workers, i, i+1)
+	close(w.queue)
+
+	if held, _ := w.Held(); len(held) > 0 {
+		publisherLog.Warn("Removed publisher, dropped the posts held while it was paused", "publisher", w.name, "held", len(held))
+	}
+}
+
+// Publish implements Publisher.
+func (p *twitterPublisher) Publish(ctx context.Context, post preparedTweet) error {
+	input := &ctypes.CreateInput{
+		Text: gotwi.String(post.status),
+	}
+
+	if post.card != nil {
+		mediaID, err := uploadImage(ctx, p.client, post.card, post.altText)
+		if err != nil {
+			publisherLog.Warn("Failed to upload card, tweeting without it", "err", err)
+		} else {
+			input.Media = &ctypes.CreateInputMedia{MediaIDs: []string{mediaID}}
+		}
+	}
+
+	res, err := managetweet.Create(ctx, p.client, input)
+	if err != nil {
+		return err
+	}
+
+	var id string
+	if res.Data.ID != nil {
+		id = *res.Data.ID
+	}
+	publisherLog.Debug("Sent tweet", "tweet_id", id)
+
+	return nil
+}
+
+// PostLength implements postLengther.
+func (p *twitterPublisher) PostLength(text string) int {
+	return postLength(text)
+}
+
+// Publish implements Publisher.
+func (logPublisher) Publish(ctx context.Context, post preparedTweet) error {
+	publisherLog.Debug("Would have tweeted", "status", post.status)
+	return nil
+}
+
+// run publishes everything in the queue until the context is done,
+// then holds on to the rest of the queue until it is closed so it can be saved.
+func (w *publisherWorker) run(ctx context.Context) {
+	defer close(w.done)
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+loop:
+	for {
+		select {
+		case post, ok := <-w.queue:
+			if !ok {
+				return
+			}
+
+			if !w.hold(post) {
+				w.handle(ctx, post)
+			}
+
+		case post := <-w.manual:
+			w.handle(ctx, post)
+
+		case <-w.wake:
+			for _, post := range w.release() {
+				w.handle(ctx, post)
+			}
+
+		case now := <-ticker.C:
+			w.flushDigest(ctx, now)
+
+		case <-ctx.Done():
+			break loop
+		}
+	}
+
+	w.mu.Lock()
+	w.pending = append(w.pending, w.held...)
+	w.held = nil
+	w.mu.Unlock()
+
+	for len(w.manual) > 0 {
+		w.pending = append(w.pending, <-w.manual)
+	}
+
+	for post := range w.queue {
+		w.pending = append(w.pending, post)
+	}
+}
+
+// hold keeps the post for later if the publisher is paused.
+func (w *publisherWorker) hold(post preparedTweet) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.paused {
+		w.held = append(w.held, post)
+	}
+
+	return w.paused
+}
+
+// release returns the held posts once the publisher has been resumed.
+func (w *publisherWorker) release() []preparedTweet {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.paused {
+		return nil
+	}
+
+	held := w.held
+	w.held = nil
+
+	return held
+}
+
+// Pause holds on to new posts until the publisher is resumed.
+func (w *publisherWorker) Pause() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.paused = true
+}
+
+// Resume publishes the held posts and carries on.
+func (w *publisherWorker) Resume() {
+	w.mu.Lock()
+	w.paused = false
+	w.mu.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Paused returns true if the publisher is paused.
+func (w *publisherWorker) Paused() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.paused
+}
+
+// Held returns a copy of the posts held while paused, and the number of posts queued.
+func (w *publisherWorker) Held() ([]preparedTweet, int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return append([]preparedTweet(nil), w.held...), len(w.queue)
+}
+
+// Digest returns what has been held back during quiet hours so far.
+func (w *publisherWorker) Digest() digest {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.digest
+}
+
+// Purge drops the held and queued posts, returning how many were dropped.
+func (w *publisherWorker) Purge() (n int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n = len(w.held)
+	w.held = nil
+
+	for {
+		select {
+		case _, ok := <-w.queue:
+			if !ok {
+				return n
+			}
+			n++
+		default:
+			return n
+		}
+	}
+}
+
+// errQueueFull is returned when there are too many manual posts waiting.
+var errQueueFull = errors.New("queue full")
+
+// Post publishes a message as is, even if the publisher is paused or in quiet hours.
+func (w *publisherWorker) Post(status string) error {
+	select {
+	case w.manual <- preparedTweet{timestamp: time.Now(), status: status, manual: true}:
+		return nil
+	default:
+		return errQueueFull
+	}
+}
+
+// handle applies the schedule and value cap to a post before publishing it, manual posts skip both.
+func (w *publisherWorker) handle(ctx context.Context, post preparedTweet) {
+	if post.manual {
+		w.publish(ctx, post)
+		return
+	}
+
+	if window, ok := w.currentSchedule().Quiet(post.timestamp); ok && post.usdValue < window.MinUSD {
+		if window.Digest {
+			publisherLog.Info("Quiet hours, adding to digest", "publisher", w.name, "usd_value", post.usdValue, "status", post.status)
+			w.mu.Lock()
+			w.digest.Add(post)
+			w.mu.Unlock()
+		} else {
+			publisherLog.Info("Quiet hours, dropped", "publisher", w.name, "usd_value", post.usdValue, "status", post.status)
+		}
+		return
+	}
+
+	var minValue float64
+	switch perDay := w.dailyLimit(); {
+	case perDay < 5:
+		minValue = 5000000
+	case perDay < 10:
+		minValue = 1000000
+	case perDay < 25:
+		minValue = 100000
+	}
+
+	if post.usdValue < minValue {
+		publisherLog.Info("Dropped because of value cap", "publisher", w.name, "usd_value", post.usdValue, "min_usd", minValue)
+		return
+	}
+
+	w.publish(ctx, post)
+}
+
+// publish formats and publishes a post.
+func (w *publisherWorker) publish(ctx context.Context, post preparedTweet) {
+	// Apply the rate limit, keeping the post for later if we are shutting down
+	if err := w.limiter.Wait(ctx); err != nil {
+		w.pending = append(w.pending, post)
+		return
+	}
+
+	format := w.formatter()
+	switch {
+	case post.liquidation != nil:
+		post.status = format.Render(*post.liquidation, post.decoration)
+		if post.withCard {
+			post.card, post.altText = format.RenderCard(*post.liquidation, post.decoration)
+		}
+	case post.cascade != nil:
+		post.status = format.RenderCascade(*post.cascade)
+	}
+
+	lag := time.Since(post.timestamp)
+	if err := w.currentPublisher().Publish(ctx, post); err != nil {
+		if ctx.Err() != nil {
+			w.pending = append(w.pending, post)
+			return
+		}
+
+		publisherLog.Error("Failed to publish", "publisher", w.name, "status", post.status, "err", err)
+		w.recordPublish(err)
+		return
+	}
+
+	w.recordPublish(nil)
+
+	publisherLog.Info("Published", "publisher", w.name, "usd_value", post.usdValue, "bursts", w.limiter.Burst(), "lag", lag, "status", post.status)
+}
+
+// recordPublish counts the publishes which failed in a row.
+func (w *publisherWorker) recordPublish(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err == nil {
+		w.failures = 0
+		w.lastError = ""
+		return
+	}
+
+	w.failures++
+	w.lastError = err.Error()
+}
+
+// Failures returns the number of publishes which failed in a row, and the last error.
+func (w *publisherWorker) Failures() (int, string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.failures, w.lastError
+}
+
+// configure replaces the publisher, schedule and format when the config is reloaded.
+func (w *publisherWorker) configure(publisher Publisher, sched *schedule, format *postFormatter) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.publisher = publisher
+	w.schedule = sched
+	w.format = format
+}
+
+// currentPublisher returns where the posts are published.
+func (w *publisherWorker) currentPublisher() Publisher {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.publisher
+}
+
+// setPostsPerDay changes the rate limit when the config is reloaded.
+func (w *publisherWorker) setPostsPerDay(n int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.postsPerDay = n
+	w.limiter.SetLimit(postsPerDayLimit(n))
+	w.limiter.SetBurst(n)
+}
+
+// dailyLimit returns the most posts a day the publisher is configured for.
+func (w *publisherWorker) dailyLimit() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.postsPerDay > 0 {
+		return w.postsPerDay
+	}
+
+	return defaultPostsPerDay
+}
+
+// currentSchedule returns the publisher's schedule.
+func (w *publisherWorker) currentSchedule() *schedule {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.schedule
+}
+
+// formatter returns the publisher's post format.
+func (w *publisherWorker) formatter() *postFormatter {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.format != nil {
+		return w.format
+	}
+
+	return defaultPostFormatter
+}
+
+// flushDigest posts the digest once the quiet window is over.
+func (w *publisherWorker) flushDigest(ctx context.Context, now time.Time) {
+	if _, ok := w.currentSchedule().Quiet(now); ok {
+		return
+	}
+
+	w.mu.Lock()
+	d := w.digest
+	w.digest = digest{}
+	w.mu.Unlock()
+
+	if d.Count == 0 {
+		return
+	}
+
+	w.handle(ctx, preparedTweet{
+		timestamp: now,
+		usdValue:  d.USDValue,
+		status:    w.formatter().locale.FormatDigest(d),
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+)
+
+type (
+	// savedPost is a preparedTweet as stored in the publisher queue file.
+	savedPost struct {
+		Timestamp time.Time `json:"timestamp"`
+		USDValue  float64   `json:"usd_value"`
+		Status    string    `json:"status"`
+		Manual    bool      `json:"manual,omitempty"`
+
+		Liquidation *CombinedLiquidation `json:"liquidation,omitempty"`
+		Decoration  Decoration           `json:"decoration"`
+		Cascade     *Cascade             `json:"cascade,omitempty"`
+
+		WithCard bool `json:"with_card,omitempty"`
+	}
+
+	// savedQueue is what a publisher had not published yet.
+	savedQueue struct {
+		Posts  []savedPost `json:"posts"`
+		Digest digest      `json:"digest"`
+	}
+)
+
+// saveQueues writes the unpublished posts and digests of the publishers to disk. The digest of every publisher
+// is saved, but the posts only of the workers which have stopped.
+func saveQueues(path string, workers []*publisherWorker) error {
+	queues := make(map[string]savedQueue)
+	for _, w := range workers {
+		var pending []preparedTweet
+		select {
+		case <-w.done:
+			pending = w.pending
+		default:
+		}
+
+		q := savedQueue{Digest: w.Digest()}
+		if len(pending) == 0 && q.Digest.Count == 0 {
+			continue
+		}
+
+		for _, post := range pending {
+			q.Posts = append(q.Posts, savedPost{
+				Timestamp: post.timestamp,
+				USDValue:  post.usdValue,
+				Status:    post.status,
+				Manual:    post.manual,
+
+				Liquidation: post.liquidation,
+				Decoration:  post.decoration,
+				Cascade:     post.cascade,
+
+				WithCard: post.withCard,
+			})
+		}
+
+		stateLog.Info("Saving unpublished posts", "publisher", w.name, "posts", len(q.Posts), "digest", q.Digest.Count)
+		queues[w.name] = q
+	}
+
+	if len(queues) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	raw, err := json.Marshal(queues)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, raw)
+}
+
+// loadQueues queues the posts saved at the last shutdown, the file is removed so they are only loaded once.
+func loadQueues(path string, workers []*publisherWorker) error {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var queues map[string]savedQueue
+	if err := json.Unmarshal(raw, &queues); err != nil {
+		return err
+	}
+
+	for _, w := range workers {
+		q, ok := queues[w.name]
+		if !ok {
+			continue
+		}
+		delete(queues, w.name)
+
+		w.digest = q.Digest
+		for _, post := range q.Posts {
+			select {
+			case w.queue <- preparedTweet{
+				timestamp: post.Timestamp,
+				usdValue:  post.USDValue,
+				status:    post.Status,
+				manual:    post.Manual,
+
+				liquidation: post.Liquidation,
+				decoration:  post.Decoration,
+				cascade:     post.Cascade,
+
+				withCard: post.WithCard,
+			}:
+			default:
+				stateLog.Warn("Queue full, dropped saved post", "publisher", w.name, "status", post.Status)
+			}
+		}
+
+		stateLog.Info("Loaded unpublished posts", "publisher", w.name, "posts", len(q.Posts))
+	}
+
+	for name, q := range queues {
+		stateLog.Warn("Dropping unpublished posts for unknown publisher", "publisher", name, "posts", len(q.Posts))
+	}
+
+	return os.Remove(path)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestPublisherQueueShutdown(t *testing.T) {
+	newWorker := func() *publisherWorker {
+		s, err := newSchedule(ScheduleConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return &publisherWorker{
+			name: "test",
+			publisher: publisherFunc(func(post preparedTweet) {
+				t.Fatal("nothing should be published after shutdown", post)
+			}),
+			schedule: s,
+			queue:    make(chan preparedTweet, 10),
+			done:     make(chan struct{}),
+			limiter:  rate.NewLimiter(rate.Inf, 50),
+			digest:   digest{Count: 2, USDValue: 3000, Largest: 2000},
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Everything queued after shutdown is kept
+	w := newWorker()
+	w.queue <- preparedTweet{timestamp: time.Unix(1700000000, 0), usdValue: 100000, status: "first", withCard: true}
+	w.queue <- preparedTweet{timestamp: time.Unix(1700000001, 0), usdValue: 200000, status: "second"}
+	close(w.queue)
+	w.run(ctx)
+
+	if len(w.pending) != 2 {
+		t.Fatal("expected the queue to be kept", w.pending)
+	}
+
+	path := filepath.Join(t.TempDir(), "queue.json")
+	if err := saveQueues(path, []*publisherWorker{w}); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := newWorker()
+	restarted.digest = digest{}
+	if err := loadQueues(path, []*publisherWorker{restarted}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(restarted.queue) != 2 || restarted.digest.Count != 2 {
+		t.Fatal("expected the queue and digest to be restored", len(restarted.queue), restarted.digest)
+	}
+
+	first := <-restarted.queue
+	if first.status != "first" || first.usdValue != 100000 || !first.withCard || !first.timestamp.Equal(time.Unix(1700000000, 0)) {
+		t.Fatalf("unexpected post %+v", first)
+	}
+
+	// Saved posts are only loaded once
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("expected the queue file to be removed", err)
+	}
+
+	// Publishers which did not stop in time still have their digest saved
+	stuck := newWorker()
+	stuck.pending = []preparedTweet{{status: "in flight"}}
+	if err := saveQueues(path, []*publisherWorker{stuck}); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted = newWorker()
+	restarted.digest = digest{}
+	if err := loadQueues(path, []*publisherWorker{restarted}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(restarted.queue) != 0 || restarted.digest.Count != 2 {
+		t.Fatal("expected only the digest to be restored", len(restarted.queue), restarted.digest)
+	}
+}
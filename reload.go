@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Settings applied when the config is reloaded, everything else needs a restart.
+var reloadablePaths = regexp.MustCompile(`^(filter|log|publishers|combining|cascade|price_context|card_min_usd|twitter_[a-z_]+)(\.|\[|$)`)
+
+// Settings whose values are not logged.
+var secretPaths = regexp.MustCompile(`(key|secret|token)$`)
+
+// liveConfig is the config read by the running pipeline, swapped when it is reloaded.
+type liveConfig struct {
+	p atomic.Pointer[BotConfig]
+}
+
+func newLiveConfig(cfg BotConfig) *liveConfig {
+	var c liveConfig
+	c.Store(cfg)
+	return &c
+}
+
+// Load returns the current config.
+func (c *liveConfig) Load() BotConfig {
+	return *c.p.Load()
+}
+
+// Store replaces the config.
+func (c *liveConfig) Store(cfg BotConfig) {
+	c.p.Store(&cfg)
+}
+
+// reloader applies a changed config and text to the running bot without reconnecting.
+type reloader struct {
+	path       string
+	paths      Paths
+	override   func(*BotConfig) // Applies the command line flags, if any
+	publishers *publisherSet
+	filter     *liquidationFilter
+	state      *State
+	live       *liveConfig // Swapped for the pipeline, if not nil
+
+	mu  sync.Mutex
+	cfg BotConfig
+}
+
+// Reload reads the config and text again, everything is checked before anything is changed.
+func (r *reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := loadConfigFile(r.path)
+	if err != nil {
+		return err
+	}
+
+	if r.override != nil {
+		r.override(&cfg)
+	}
+
+	if err := cfg.resolveSecrets(); err != nil {
+		return err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	if _, err := newLiquidationFilter(cfg.Filter); err != nil {
+		return fmt.Errorf("filter: %w", err)
+	}
+
+	type settings struct {
+		publisher   Publisher
+		schedule    *schedule
+		format      *postFormatter
+		postsPerDay int
+	}
+
+	current := make(map[string]PublisherConfig)
+	for _, pc := range r.cfg.publisherConfigs() {
+		current[pc.Name] = pc
+	}
+
+	// Publishers which log in differently log in again, keeping their queue, held posts, digest and rate limit
+	configs := make(map[string]bool)
+	updates := make(map[*publisherWorker]settings)
+	var added, removed []*publisherWorker
+	var locales []string
+	for _, pc := range cfg.publisherConfigs() {
+		if configs[pc.Name] {
+			return fmt.Errorf("duplicate publisher name %q", pc.Name)
+		}
+		configs[pc.Name] = true
+		locales = append(locales, pc.Locale)
+
+		w := r.publishers.Find(pc.Name)
+		if w == nil {
+			n, err := newConfiguredWorker(cfg, pc)
+			if err != nil {
+				return err
+			}
+			added = append(added, n)
+			continue
+		}
+
+		publisher := w.currentPublisher()
+		if old, ok := current[pc.Name]; !ok || old.Type != pc.Type || r.cfg.publisherCredentials(old) != cfg.publisherCredentials(pc) {
+			var err error
+			if publisher, err = newPublisher(cfg, pc); err != nil {
+				return err
+			}
+		}
+
+		sched, format, err := publisherSettings(pc, publisher)
+		if err != nil {
+			return err
+		}
+		updates[w] = settings{publisher, sched, format, pc.postsPerDay()}
+	}
+
+	for _, w := range r.publishers.List() {
+		if !configs[w.name] {
+			removed = append(removed, w)
+		}
+	}
+
+	if err := r.state.ReloadText(locales); err != nil {
+		return err
+	}
+
+	// Everything checks out, swap it in
+	for _, change := range configDiff(r.cfg, cfg) {
+		reloadLog.Info("Config changed", "change", change)
+	}
+
+	if !reflect.DeepEqual(r.cfg.Filter, cfg.Filter) {
+		if err := r.filter.Replace(cfg.Filter); err != nil {
+			return err
+		}
+	}
+
+	for w, s := range updates {
+		w.configure(s.publisher, s.schedule, s.format)
+		if s.postsPerDay != w.dailyLimit() {
+			w.setPostsPerDay(s.postsPerDay)
+		}
+	}
+
+	for _, w := range removed {
+		reloadLog.Info("Removing publisher", "publisher", w.name)
+		r.publishers.remove(w)
+	}
+
+	for _, w := range added {
+		reloadLog.Info("Adding publisher", "publisher", w.name)
+		if err := r.publishers.add(w); err != nil {
+			return err
+		}
+	}
+
+	if !reflect.DeepEqual(r.cfg.Log, cfg.Log) {
+		if err := configureLogging(cfg.Log); err != nil {
+			return err
+		}
+	}
+
+	if r.live != nil {
+		r.live.Store(cfg)
+	}
+
+	r.cfg = cfg
+	return nil
+}
+
+// run reloads on SIGHUP, and when the files change if there is an interval, until the context is done.
+func (r *reloader) run(ctx context.Context, hup <-chan os.Signal, interval time.Duration) {
+	var poll <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	reload := func() {
+		if err := r.Reload(); err != nil {
+			reloadLog.Error("Failed to reload, keeping the current config", "err", err)
+		}
+	}
+
+	last := r.modTimes()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-hup:
+			reloadLog.Info("Reloading config and text on SIGHUP")
+			reload()
+			last = r.modTimes()
+
+		case <-poll:
+			if curr := r.modTimes(); !maps.Equal(curr, last) {
+				reloadLog.Info("Config or text files changed, reloading")
+				reload()
+				last = curr
+			}
+		}
+	}
+}
+
+// modTimes returns when the config and text files were last modified, zero if they do not exist.
+func (r *reloader) modTimes() map[string]time.Time {
+	r.mu.Lock()
+	var locales []string
+	for _, pc := range r.cfg.publisherConfigs() {
+		locales = append(locales, pc.Locale)
+	}
+	paths := append([]string{r.path}, r.paths.textFiles(locales)...)
+	r.mu.Unlock()
+
+	times := make(map[string]time.Time)
+	for _, path := range paths {
+		var t time.Time
+		if fi, err := os.Stat(path); err == nil {
+			t = fi.ModTime()
+		}
+		times[path] = t
+	}
+
+	return times
+}
+
+// configDiff lists the settings which differ between two configs, the values of secrets are not shown.
+func configDiff(old, new BotConfig) []string {
+	var a, b any
+	for _, v := range []struct {
+		cfg BotConfig
+		out *any
+	}{{old, &a}, {new, &b}} {
+		raw, err := json.Marshal(v.cfg)
+		if err != nil {
+			return []string{"unable to compare: " + err.Error()}
+		}
+		json.Unmarshal(raw, v.out)
+	}
+
+	var diffs []string
+	diffValues("", a, b, &diffs)
+	sort.Strings(diffs)
+
+	return diffs
+}
+
+func diffValues(path string, a, b any, diffs *[]string) {
+	am, aok := a.(map[string]any)
+	bm, bok := b.(map[string]any)
+	if aok && bok {
+		keys := make(map[string]bool)
+		for k := range am {
+			keys[k] = true
+		}
+		for k := range bm {
+			keys[k] = true
+		}
+
+		for k := range keys {
+			child := k
+			if path != "" {
+				child = path + "." + k
+			}
+			diffValues(child, am[k], bm[k], diffs)
+		}
+		return
+	}
+
+	as, aok := a.([]any)
+	bs, bok := b.([]any)
+	if aok && bok {
+		for i := 0; i < max(len(as), len(bs)); i++ {
+			var av, bv any
+			if i < len(as) {
+				av = as[i]
+			}
+			if i < len(bs) {
+				bv = bs[i]
+			}
+			diffValues(fmt.Sprintf("%v[%d]", path, i), av, bv, diffs)
+		}
+		return
+	}
+
+	if reflect.DeepEqual(a, b) {
+		return
+	}
+
+	diff := path + ": changed"
+	if !secretPaths.MatchString(path) {
+		diff = fmt.Sprintf("%v: %v -> %v", path, jsonString(a), jsonString(b))
+	}
+
+	if !reloadablePaths.MatchString(path) {
+		diff += " (restart required)"
+	}
+
+	*diffs = append(*diffs, diff)
+}
+
+// redact hides the values of secrets in a decoded JSON value.
+func redact(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, child := range v {
+			if secretPaths.MatchString(k) && child != "" {
+				out[k] = "<redacted>"
+			} else {
+				out[k] = redact(child)
+			}
+		}
+		return out
+
+	case []any:
+		out := make([]any, len(v))
+		for i, child := range v {
+			out[i] = redact(child)
+		}
+		return out
+	}
+
+	return v
+}
+
+func jsonString(v any) string {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+
+	if err := enc.Encode(redact(v)); err != nil {
+		return fmt.Sprint(v)
+	}
+
+	return strings.TrimSpace(b.String())
+}
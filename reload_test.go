@@ -0,0 +1,180 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/time/rate"
+)
+
+func TestConfigDiff(t *testing.T) {
+	old := BotConfig{
+		BitMexHost:         "www.bitmex.com",
+		TwitterConsumerKey: "old",
+		Filter:             FilterConfig{MinUSD: 1000},
+		Publishers:         []PublisherConfig{{Name: "twitter", Type: PublisherTwitter}},
+	}
+
+	new := old
+	new.BitMexHost = "testnet.bitmex.com"
+	new.TwitterConsumerKey = "new"
+	new.Filter.MinUSD = 5000
+	new.Publishers = []PublisherConfig{
+		{Name: "twitter", Type: PublisherTwitter, Format: FormatConfig{Template: "{{.Symbol}}"}},
+		{Name: "es", Type: PublisherTwitter, Locale: "es", TwitterTokenSecret: "hunter2"},
+	}
+
+	expected := []string{
+		`bitmex_host: "www.bitmex.com" -> "testnet.bitmex.com" (restart required)`,
+		`filter.min_usd: 1000 -> 5000`,
+		`publishers[0].format.template: "" -> "{{.Symbol}}"`,
+		`publishers[1]: null -> {"format":{"fit":null,"max_length":0,"template":""},"locale":"es","name":"es","posts_per_day":0,"schedule":{"quiet":null,"timezone":""},"twitter_access_token":"","twitter_consumer_key":"","twitter_consumer_secret":"","twitter_token_secret":"<redacted>","type":"twitter"}`,
+		`twitter_consumer_key: changed`,
+	}
+
+	if diff := configDiff(old, new); !reflect.DeepEqual(diff, expected) {
+		t.Fatalf("unexpected diff\n%q\n%q", diff, expected)
+	}
+
+	if diff := configDiff(old, old); len(diff) != 0 {
+		t.Fatal("expected no changes", diff)
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+
+	write := func(config string) {
+		if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{"bitmex_host": "www.bitmex.com", "filter": {"min_usd": 1000}, "publishers": [{"name": "log", "type": "log"}]}`)
+	cfg, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filter, err := newLiquidationFilter(cfg.Filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sched, format, err := publisherSettings(cfg.Publishers[0], logPublisher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := newPublisherWorker("log", logPublisher{}, sched, format)
+	w.limiter = rate.NewLimiter(rate.Inf, 50)
+
+	publishers := newPublisherSet([]*publisherWorker{w})
+	live := newLiveConfig(cfg)
+
+	r := &reloader{
+		path:       path,
+		publishers: publishers,
+		filter:     filter,
+		state: &State{
+			SaveFile:   filepath.Join(dir, "high_scores.json"),
+			HighScores: newHighScores(),
+		},
+		live: live,
+		cfg:  cfg,
+	}
+
+	cl := testLiquidation("XBTUSD", "Sell", 2000).ToCombined()
+
+	// Applied without restarting
+	write(`{"bitmex_host": "www.bitmex.com", "filter": {"min_usd": 5000}, "card_min_usd": 100000, "cascade": {"window": "1m", "min_count": 5, "quiet": "30s"},
+		"publishers": [{"name": "log", "type": "log", "locale": "es", "format": {"template": "{{.Position}} {{.Symbol}}"}}, {"name": "other", "type": "log"}]}`)
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg := live.Load(); cfg.CardMinUSD != 100000 || cfg.Cascade.MinCount != 5 {
+		t.Error("thresholds not reloaded", cfg)
+	}
+
+	if list := publishers.List(); len(list) != 2 || list[0] != w || list[1].name != "other" {
+		t.Error("publisher not added", list)
+	}
+
+	if filter.MinUSD("XBTUSD") != 5000 {
+		t.Error("filter not reloaded")
+	}
+
+	if s := w.formatter().Render(cl, Decoration{}); s != "larga XBTUSD" {
+		t.Error("format not reloaded", s)
+	}
+
+	if len(r.state.Snark) == 0 || r.state.Translations["es"] == nil || r.state.Medals == nil {
+		t.Error("text not reloaded")
+	}
+
+	// Publishers without a locale use the English text rather than a translation
+	if _, ok := r.state.Translations[""]; ok || len(r.state.Translations) != 1 {
+		t.Error("expected only the es translation", r.state.Translations)
+	}
+
+	// Nothing changes if the config is invalid
+	for _, config := range []string{
+		`{"bitmex_host": "www.bitmex.com", "filter": {"min_usd": 1}, "publishers": [{"name": "log", "type": "log", "locale": "xx"}]}`,
+		`{"bitmex_host": "www.bitmex.com", "filter": {"min_usd": 1, "include": ["re:("]}}`,
+		`{"bitmex_host": "www.bitmex.com", "filter": {"min_usd": 1}, "publishers": [{"name": "log", "type": "log", "schedule": {"timezone": "Nowhere/Special"}}]}`,
+		`{"bitmex_host": "www.bitmex.com", "filter": {"min_usd": 1}, "records": {"timezone": "Nowhere/Special"}}`,
+		`{"bitmex_host": "www.bitmex.com", "filter": `,
+	} {
+		write(config)
+		if err := r.Reload(); err == nil {
+			t.Errorf("%v: expected an error", config)
+		}
+
+		if filter.MinUSD("XBTUSD") != 5000 {
+			t.Fatal("filter changed by an invalid config", config)
+		}
+
+		if s := w.formatter().Render(cl, Decoration{}); s != "larga XBTUSD" {
+			t.Fatal("format changed by an invalid config", config, s)
+		}
+	}
+
+	// Publishers which log in differently keep their posts
+	w.Pause()
+	w.hold(preparedTweet{usdValue: 1000})
+	w.mu.Lock()
+	w.digest.Add(preparedTweet{usdValue: 2000})
+	w.mu.Unlock()
+
+	write(`{"bitmex_host": "www.bitmex.com", "filter": {"min_usd": 5000}, "publishers": [{"name": "log", "type": "log", "locale": "es", "twitter_consumer_key": "other",
+		"format": {"template": "{{.Position}} {{.Symbol}}"}}, {"name": "other", "type": "log"}]}`)
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if list := publishers.List(); len(list) != 2 || list[0] != w {
+		t.Fatal("publisher replaced", list)
+	}
+
+	if held, _ := w.Held(); len(held) != 1 || w.Digest().Count != 1 {
+		t.Error("expected the held posts and digest to be kept", held, w.Digest())
+	}
+
+	// Removed publishers stop once their queue is empty
+	write(`{"bitmex_host": "www.bitmex.com", "filter": {"min_usd": 5000}, "publishers": [{"name": "other", "type": "log"}]}`)
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if list := publishers.List(); len(list) != 1 || list[0].name != "other" {
+		t.Error("publisher not removed", list)
+	}
+
+	if _, ok := <-w.queue; ok {
+		t.Error("expected the queue of the removed publisher to be closed")
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type (
+	// ScheduleConfig restricts what a publisher posts at certain times of day.
+	ScheduleConfig struct {
+		// IANA timezone the windows are in, e.g. "Europe/London", defaults to UTC.
+		Timezone string `json:"timezone"`
+
+		Quiet []QuietWindow `json:"quiet"`
+	}
+
+	// QuietWindow is a daily window, e.g. 00:00 - 07:00, during which only large liquidations are posted.
+	// Windows may wrap around midnight, one which starts and ends at the same time lasts all day.
+	QuietWindow struct {
+		Start string `json:"start"` // HH:MM
+		End   string `json:"end"`   // HH:MM
+
+		// Liquidations worth at least this much are still posted during the window.
+		MinUSD float64 `json:"min_usd"`
+
+		// Roll the liquidations that are held back into a digest posted when the window ends, otherwise drop them.
+		Digest bool `json:"digest"`
+	}
+
+	// schedule is a compiled ScheduleConfig.
+	schedule struct {
+		loc   *time.Location
+		quiet []quietWindow
+	}
+
+	quietWindow struct {
+		QuietWindow
+		clockWindow
+	}
+
+	// clockWindow is a daily window in minutes from midnight, which may wrap around midnight.
+	// It lasts all day if the start and end are the same.
+	clockWindow struct {
+		start int
+		end   int
+	}
+
+	// digest summarises the liquidations held back during a quiet window.
+	digest struct {
+		Count    int
+		USDValue float64
+		Largest  float64
+		Since    time.Time
+	}
+)
+
+// parseClock parses HH:MM into minutes from midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// parseClockWindow parses HH:MM-HH:MM.
+func parseClockWindow(s string) (clockWindow, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return clockWindow{}, fmt.Errorf("invalid window %q, expected HH:MM-HH:MM", s)
+	}
+
+	start, err := parseClock(from)
+	if err != nil {
+		return clockWindow{}, err
+	}
+
+	end, err := parseClock(to)
+	if err != nil {
+		return clockWindow{}, err
+	}
+
+	return clockWindow{start, end}, nil
+}
+
+func newSchedule(cfg ScheduleConfig) (*schedule, error) {
+	s := schedule{
+		loc: time.UTC,
+	}
+
+	if cfg.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %w", cfg.Timezone, err)
+		}
+		s.loc = loc
+	}
+
+	for _, w := range cfg.Quiet {
+		start, err := parseClock(w.Start)
+		if err != nil {
+			return nil, err
+		}
+
+		end, err := parseClock(w.End)
+		if err != nil {
+			return nil, err
+		}
+
+		s.quiet = append(s.quiet, quietWindow{w, clockWindow{start, end}})
+	}
+
+	return &s, nil
+}
+
+// contains returns true if minutes from midnight is inside the window.
+func (w clockWindow) contains(minutes int) bool {
+	if w.start == w.end {
+		return true
+	}
+
+	if w.start < w.end {
+		return minutes >= w.start && minutes < w.end
+	}
+
+	// Wraps around midnight
+	return minutes >= w.start || minutes < w.end
+}
+
+// Quiet returns the quiet window a time falls in.
+func (s *schedule) Quiet(t time.Time) (QuietWindow, bool) {
+	local := t.In(s.loc)
+	minutes := local.Hour()*60 + local.Minute()
+
+	for _, w := range s.quiet {
+		if w.contains(minutes) {
+			return w.QuietWindow, true
+		}
+	}
+
+	return QuietWindow{}, false
+}
+
+// Add a held back liquidation to the digest.
+func (d *digest) Add(post preparedTweet) {
+	if d.Count == 0 {
+		d.Since = post.timestamp
+	}
+
+	d.Count++
+	d.USDValue += post.usdValue
+	if post.usdValue > d.Largest {
+		d.Largest = post.usdValue
+	}
+}
+
+// String implements Stringer.
+func (d digest) String() string {
+	// Example: While we were away: 23 liquidations worth $4,500,000, the largest was $1,200,000
+	return englishLocale.FormatDigest(d)
+}
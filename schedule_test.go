@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestScheduleQuiet(t *testing.T) {
+	s, err := newSchedule(ScheduleConfig{
+		Timezone: "Asia/Tokyo",
+		Quiet: []QuietWindow{
+			{Start: "23:00", End: "07:00", MinUSD: 1000000, Digest: true},
+			{Start: "12:00", End: "13:00", MinUSD: 500000},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table := []struct {
+		Time   time.Time
+		Quiet  bool
+		MinUSD float64
+	}{
+		{time.Date(2024, 1, 1, 22, 59, 0, 0, tokyo), false, 0},
+		{time.Date(2024, 1, 1, 23, 0, 0, 0, tokyo), true, 1000000},
+		{time.Date(2024, 1, 2, 3, 0, 0, 0, tokyo), true, 1000000},
+		{time.Date(2024, 1, 2, 7, 0, 0, 0, tokyo), false, 0},
+		{time.Date(2024, 1, 2, 12, 30, 0, 0, tokyo), true, 500000},
+
+		// 15:00 UTC is 00:00 in Tokyo
+		{time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC), true, 1000000},
+		{time.Date(2024, 1, 2, 22, 0, 0, 0, time.UTC), false, 0},
+	}
+
+	for _, v := range table {
+		w, ok := s.Quiet(v.Time)
+		if ok != v.Quiet || w.MinUSD != v.MinUSD {
+			t.Errorf("%v: expected quiet %v (%v) got %v (%v)", v.Time, v.Quiet, v.MinUSD, ok, w.MinUSD)
+		}
+	}
+
+	// A window starting and ending at the same time lasts all day
+	allDay, err := newSchedule(ScheduleConfig{Quiet: []QuietWindow{{Start: "09:00", End: "09:00", MinUSD: 1000000}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, hour := range []int{0, 8, 9, 10, 23} {
+		if _, ok := allDay.Quiet(time.Date(2024, 1, 1, hour, 30, 0, 0, time.UTC)); !ok {
+			t.Errorf("%02d:30: expected the all day window to be quiet", hour)
+		}
+	}
+
+	if _, err := newSchedule(ScheduleConfig{Timezone: "Mars/Olympus_Mons"}); err == nil {
+		t.Error("expected an invalid timezone error")
+	}
+
+	if _, err := newSchedule(ScheduleConfig{Quiet: []QuietWindow{{Start: "25:00", End: "07:00"}}}); err == nil {
+		t.Error("expected an invalid time error")
+	}
+}
+
+func TestPublisherWorkerDigest(t *testing.T) {
+	s, err := newSchedule(ScheduleConfig{
+		Quiet: []QuietWindow{{Start: "00:00", End: "07:00", MinUSD: 1000000, Digest: true}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var published []preparedTweet
+	w := &publisherWorker{
+		name:      "test",
+		publisher: publisherFunc(func(post preparedTweet) { published = append(published, post) }),
+		schedule:  s,
+		limiter:   rate.NewLimiter(rate.Inf, 50),
+	}
+
+	night := time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC)
+	w.handle(context.Background(), preparedTweet{timestamp: night, usdValue: 200000, status: "small"})
+	w.handle(context.Background(), preparedTweet{timestamp: night, usdValue: 300000, status: "small"})
+	w.handle(context.Background(), preparedTweet{timestamp: night, usdValue: 2000000, status: "large"})
+
+	if len(published) != 1 || published[0].status != "large" {
+		t.Fatal("expected only the large liquidation to be published", published)
+	}
+
+	// Nothing happens until the window is over
+	w.flushDigest(context.Background(), night.Add(time.Hour))
+	if len(published) != 1 {
+		t.Fatal("digest posted too early")
+	}
+
+	w.flushDigest(context.Background(), night.Add(5*time.Hour))
+	if len(published) != 2 || published[1].status != "While we were away: 2 liquidations worth $500,000, the largest was $300,000" {
+		t.Fatal("expected the digest", published)
+	}
+
+	w.flushDigest(context.Background(), night.Add(6*time.Hour))
+	if len(published) != 2 {
+		t.Fatal("digest posted twice")
+	}
+}
+
+func TestPublisherWorkerValueCap(t *testing.T) {
+	s, err := newSchedule(ScheduleConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var published []preparedTweet
+	w := newPublisherWorker("test", publisherFunc(func(post preparedTweet) { published = append(published, post) }), s, nil)
+	w.setPostsPerDay(20)
+
+	// Publishers allowed fewer posts a day only post larger liquidations
+	w.handle(context.Background(), preparedTweet{usdValue: 50000, status: "small"})
+	w.handle(context.Background(), preparedTweet{usdValue: 200000, status: "large"})
+
+	if len(published) != 1 || published[0].status != "large" {
+		t.Fatal("expected only the large liquidation to be published", published)
+	}
+
+	if w.limiter.Burst() != 20 {
+		t.Error("expected the rate limit to follow the config", w.limiter.Burst())
+	}
+}
+
+// publisherFunc adapts a function to a Publisher.
+type publisherFunc func(post preparedTweet)
+
+func (f publisherFunc) Publish(ctx context.Context, post preparedTweet) error {
+	f(post)
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Keys of the Twitter credentials, in the order returned by twitterCredentials.
+var twitterCredentialKeys = [4]string{"twitter_consumer_key", "twitter_consumer_secret", "twitter_access_token", "twitter_token_secret"}
+
+// twitterCredentials returns the top level Twitter credentials.
+func (cfg BotConfig) twitterCredentials() [4]string {
+	return [4]string{cfg.TwitterConsumerKey, cfg.TwitterConsumerSecret, cfg.TwitterAccessToken, cfg.TwitterTokenSecret}
+}
+
+// twitterCredentials returns the publisher's own Twitter credentials.
+func (pc PublisherConfig) twitterCredentials() [4]string {
+	return [4]string{pc.TwitterConsumerKey, pc.TwitterConsumerSecret, pc.TwitterAccessToken, pc.TwitterTokenSecret}
+}
+
+// publisherCredentials returns the Twitter credentials a publisher logs in with, the top level ones if it has none.
+func (cfg BotConfig) publisherCredentials(pc PublisherConfig) [4]string {
+	if pc.TwitterConsumerKey != "" {
+		return pc.twitterCredentials()
+	}
+
+	return cfg.twitterCredentials()
+}
+
+// secret is a credential in the config.
+type secret struct {
+	key   string
+	value *string
+}
+
+// secrets returns every credential in the config.
+func (cfg *BotConfig) secrets() []secret {
+	secrets := []secret{
+		{"twitter_consumer_key", &cfg.TwitterConsumerKey},
+		{"twitter_consumer_secret", &cfg.TwitterConsumerSecret},
+		{"twitter_access_token", &cfg.TwitterAccessToken},
+		{"twitter_token_secret", &cfg.TwitterTokenSecret},
+		{"admin.token", &cfg.Admin.Token},
+	}
+
+	for i := range cfg.Publishers {
+		pc := &cfg.Publishers[i]
+		prefix := fmt.Sprintf("publishers[%v].", i)
+		secrets = append(secrets,
+			secret{prefix + "twitter_consumer_key", &pc.TwitterConsumerKey},
+			secret{prefix + "twitter_consumer_secret", &pc.TwitterConsumerSecret},
+			secret{prefix + "twitter_access_token", &pc.TwitterAccessToken},
+			secret{prefix + "twitter_token_secret", &pc.TwitterTokenSecret},
+		)
+	}
+
+	return secrets
+}
+
+// checkSecretRefs checks that the secret references can be parsed, without reading the secrets.
+func (cfg *BotConfig) checkSecretRefs() error {
+	var errs []error
+	for _, s := range cfg.secrets() {
+		if err := checkSecretRef(*s.value); err != nil {
+			errs = append(errs, fmt.Errorf("%v: %w", s.key, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// resolveSecrets replaces the secret references in the config with their values, only the run command needs them.
+func (cfg *BotConfig) resolveSecrets() error {
+	var errs []error
+	for _, s := range cfg.secrets() {
+		v, err := resolveSecret(*s.value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%v: %w", s.key, err))
+			continue
+		}
+		*s.value = v
+	}
+
+	return errors.Join(errs...)
+}
+
+// isSecretRef returns true if a credential is a reference to the secret rather than the secret itself.
+func isSecretRef(value string) bool {
+	return strings.HasPrefix(value, "env:") || strings.HasPrefix(value, "file:")
+}
+
+// checkSecretRef checks that a reference names an environment variable or file.
+func checkSecretRef(ref string) error {
+	if name, ok := strings.CutPrefix(ref, "env:"); ok && (name == "" || strings.ContainsAny(name, "= ")) {
+		return fmt.Errorf("%q does not name an environment variable", ref)
+	}
+
+	if path, ok := strings.CutPrefix(ref, "file:"); ok && path == "" {
+		return fmt.Errorf("%q does not name a file", ref)
+	}
+
+	return nil
+}
+
+// resolveSecret returns the value of a credential, which may be a reference instead of the secret itself:
+//
+//	env:NAME        the environment variable NAME
+//	file:/path      the contents of a file, without surrounding whitespace
+//	file:name       a file in $CREDENTIALS_DIRECTORY, as set up by systemd's LoadCredential=
+func resolveSecret(ref string) (string, error) {
+	if name, ok := strings.CutPrefix(ref, "env:"); ok {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %v is not set", name)
+		}
+
+		return v, nil
+	}
+
+	if path, ok := strings.CutPrefix(ref, "file:"); ok {
+		if !filepath.IsAbs(path) {
+			dir := os.Getenv("CREDENTIALS_DIRECTORY")
+			if dir == "" {
+				return "", fmt.Errorf("%v is relative but $CREDENTIALS_DIRECTORY is not set, use LoadCredential= or an absolute path", path)
+			}
+			path = filepath.Join(dir, path)
+		}
+
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+
+		return strings.TrimSpace(string(raw)), nil
+	}
+
+	return ref, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "consumer_secret"), []byte("from file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "token_secret"), []byte("from credentials"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("REKT_TEST_CONSUMER_KEY", "from env")
+	t.Setenv("CREDENTIALS_DIRECTORY", dir)
+
+	path := writeTestConfig(t, `{
+		"twitter_consumer_key": "env:REKT_TEST_CONSUMER_KEY",
+		"twitter_consumer_secret": "file:`+filepath.Join(dir, "consumer_secret")+`",
+		"twitter_access_token": "plain",
+		"publishers": [{"name": "twitter", "type": "twitter", "twitter_token_secret": "file:token_secret"}]
+	}`)
+
+	cfg, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cfg.resolveSecrets(); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.TwitterConsumerKey != "from env" || cfg.TwitterConsumerSecret != "from file" || cfg.TwitterAccessToken != "plain" {
+		t.Fatalf("secrets not resolved: %+v", cfg)
+	}
+
+	if cfg.Publishers[0].TwitterTokenSecret != "from credentials" {
+		t.Fatalf("credential not resolved: %+v", cfg.Publishers[0])
+	}
+
+	// Every missing secret is reported
+	t.Setenv("CREDENTIALS_DIRECTORY", "")
+	path = writeTestConfig(t, `{"admin": {"token": "env:REKT_TEST_MISSING"}, "publishers": [{"name": "twitter", "twitter_access_token": "file:token"}]}`)
+
+	if cfg, err = loadConfigFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	err = cfg.resolveSecrets()
+	for _, expected := range []string{"admin.token: environment variable REKT_TEST_MISSING is not set", "publishers[0].twitter_access_token: token is relative"} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in %v", expected, err)
+		}
+	}
+}
+
+func TestCheckSecretRefs(t *testing.T) {
+	// References are checked without the secrets being there
+	t.Setenv("CREDENTIALS_DIRECTORY", "")
+	cfg := BotConfig{TwitterConsumerKey: "file:twitter_consumer_key", TwitterConsumerSecret: "env:", Admin: AdminConfig{Token: "file:"}}
+
+	err := cfg.checkSecretRefs()
+	for _, expected := range []string{`twitter_consumer_secret: "env:" does not name`, `admin.token: "file:" does not name`} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in %v", expected, err)
+		}
+	}
+
+	if strings.Contains(err.Error(), "twitter_consumer_key") {
+		t.Errorf("expected the file reference to be accepted: %v", err)
+	}
+}
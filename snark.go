@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// SnarkLine is a line of snark, optionally only for some liquidations.
+	//
+	// Conditions go in braces at the start of the line, e.g.
+	//
+	//	{side=short min_usd=1000000 symbols=XBT*,ETH* hours=22:00-06:00 record=week,month} $SIDE squeeze on $SYMBOL
+	//
+	// The placeholders $SYMBOL, $SIDE, $USD, $PRICE and $STREAK are replaced in the language of the corpus.
+	SnarkLine struct {
+		Raw  string // The whole line, used to remember which lines have been used
+		Text string
+
+		Side    string  // "long" or "short", either if empty
+		MinUSD  float64 // Minimum USD value
+		MaxUSD  float64 // Maximum USD value, no maximum if zero
+		Symbols []symbolPattern
+		Hours   *clockWindow // Time of day in the records timezone
+
+		// Only when a record is set, any record if there are no names
+		RecordOnly bool
+		Records    []string
+	}
+
+	// snarkContext is what snark lines are matched against.
+	snarkContext struct {
+		Liquidation CombinedLiquidation
+		Streak      int
+		Records     map[string]bool
+		Time        time.Time // In the records timezone
+	}
+)
+
+// parseSnarkLine parses a line of snark and its conditions.
+func parseSnarkLine(raw string) (SnarkLine, error) {
+	line := SnarkLine{Raw: raw, Text: raw}
+
+	if !strings.HasPrefix(raw, "{") {
+		return line, nil
+	}
+
+	conds, text, ok := strings.Cut(raw[1:], "}")
+	if !ok {
+		return SnarkLine{}, fmt.Errorf("unterminated conditions")
+	}
+	line.Text = strings.TrimSpace(text)
+
+	for _, cond := range strings.Fields(conds) {
+		key, value, _ := strings.Cut(cond, "=")
+
+		var err error
+		switch key {
+		case "side":
+			if value != "long" && value != "short" {
+				return SnarkLine{}, fmt.Errorf("invalid side %q, expected long or short", value)
+			}
+			line.Side = value
+
+		case "min_usd":
+			line.MinUSD, err = strconv.ParseFloat(value, 64)
+
+		case "max_usd":
+			line.MaxUSD, err = strconv.ParseFloat(value, 64)
+
+		case "symbols":
+			line.Symbols, err = compileSymbolPatterns(strings.Split(value, ","))
+
+		case "hours":
+			var w clockWindow
+			w, err = parseClockWindow(value)
+			line.Hours = &w
+
+		case "record":
+			line.RecordOnly = true
+			if value != "" {
+				line.Records = strings.Split(value, ",")
+				for _, r := range line.Records {
+					if !isRecordName(r) {
+						return SnarkLine{}, fmt.Errorf("unknown record %q", r)
+					}
+				}
+			}
+
+		default:
+			return SnarkLine{}, fmt.Errorf("unknown condition %q", key)
+		}
+
+		if err != nil {
+			return SnarkLine{}, fmt.Errorf("%v: %w", key, err)
+		}
+	}
+
+	return line, nil
+}
+
+// parseSnark parses the lines of a snark file, ignoring blank lines.
+func parseSnark(text string) ([]SnarkLine, error) {
+	var lines []SnarkLine
+	for i, raw := range strings.Split(text, "\n") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+
+		line, err := parseSnarkLine(raw)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %w", i+1, err)
+		}
+		lines = append(lines, line)
+	}
+
+	return lines, nil
+}
+
+// Match returns true if the line can be used for a liquidation.
+func (l SnarkLine) Match(ctx snarkContext) bool {
+	cl := ctx.Liquidation
+
+	if l.Side != "" && l.Side != positionName(cl.Side) {
+		return false
+	}
+
+	usd := cl.USDValue()
+	if usd < l.MinUSD || (l.MaxUSD > 0 && usd > l.MaxUSD) {
+		return false
+	}
+
+	if len(l.Symbols) > 0 && !matchAny(l.Symbols, cl.Symbol) {
+		return false
+	}
+
+	if l.Hours != nil && !l.Hours.contains(ctx.Time.Hour()*60+ctx.Time.Minute()) {
+		return false
+	}
+
+	if l.RecordOnly {
+		set := false
+		for name, ok := range ctx.Records {
+			if ok && (len(l.Records) == 0 || containsString(l.Records, name)) {
+				set = true
+			}
+		}
+
+		if !set {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Format replaces the placeholders.
+func (l SnarkLine) Format(ctx snarkContext, loc Locale) string {
+	cl := ctx.Liquidation
+
+	return strings.NewReplacer(
+		"$SYMBOL", string(cl.Symbol),
+		"$SIDE", loc.Position(cl.Side),
+		"$USD", loc.Number(displayUSD(cl.USDValue())),
+		"$PRICE", loc.Number(cl.Largest().DisplayPrice()),
+		"$STREAK", strconv.Itoa(ctx.Streak),
+	).Replace(l.Text)
+}
+
+// locale is the language placeholders are written in.
+func (c *Corpus) locale() Locale {
+	if c.Locale.Name == "" {
+		return englishLocale
+	}
+
+	return c.Locale
+}
+
+// shuffle deals a new deck of every line.
+func (c *Corpus) shuffle() {
+	c.deck = rand.Perm(len(c.Snark))
+}
+
+// restoreDeck continues a saved deck, ignoring lines which are no longer in the corpus.
+func (c *Corpus) restoreDeck(saved []string) {
+	index := make(map[string]int, len(c.Snark))
+	for i, l := range c.Snark {
+		index[l.Raw] = i
+	}
+
+	c.deck = nil
+	for _, raw := range saved {
+		if i, ok := index[raw]; ok {
+			c.deck = append(c.deck, i)
+			delete(index, raw)
+		}
+	}
+
+	if len(c.deck) == 0 {
+		c.shuffle()
+	}
+}
+
+// savedDeck lists the lines left in the deck so it can be continued after a restart.
+func (c *Corpus) savedDeck() []string {
+	saved := make([]string, 0, len(c.deck))
+	for _, i := range c.deck {
+		saved = append(saved, c.Snark[i].Raw)
+	}
+
+	return saved
+}
+
+// nextSnark deals the next line which suits the liquidation. If none of the remaining lines do, the lines already
+// dealt are shuffled in behind them, so the remaining lines keep their place until something they suit comes along.
+// Returns an empty string if no line suits the liquidation.
+func (c *Corpus) nextSnark(ctx snarkContext) string {
+	if i := c.findSuited(ctx); i >= 0 {
+		return c.deal(i, ctx)
+	}
+
+	c.refill()
+
+	if i := c.findSuited(ctx); i >= 0 {
+		return c.deal(i, ctx)
+	}
+
+	return ""
+}
+
+// findSuited returns the position in the deck of the first line which suits the liquidation, or -1.
+func (c *Corpus) findSuited(ctx snarkContext) int {
+	for i, idx := range c.deck {
+		if c.Snark[idx].Match(ctx) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// deal takes the line at position i out of the deck.
+func (c *Corpus) deal(i int, ctx snarkContext) string {
+	line := c.Snark[c.deck[i]]
+	c.deck = append(c.deck[:i:i], c.deck[i+1:]...)
+
+	return line.Format(ctx, c.locale())
+}
+
+// refill shuffles the lines which have been dealt back in after the remaining lines.
+func (c *Corpus) refill() {
+	inDeck := make(map[int]bool, len(c.deck))
+	for _, idx := range c.deck {
+		inDeck[idx] = true
+	}
+
+	for _, idx := range rand.Perm(len(c.Snark)) {
+		if !inDeck[idx] {
+			c.deck = append(c.deck, idx)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseSnarkLine(t *testing.T) {
+	l, err := parseSnarkLine("{side=short min_usd=1000 max_usd=5000 symbols=XBT*,ETHUSD hours=22:00-06:00 record=week} $SIDE on $SYMBOL")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l.Text != "$SIDE on $SYMBOL" || l.Side != "short" || l.MinUSD != 1000 || l.MaxUSD != 5000 ||
+		len(l.Symbols) != 2 || l.Hours == nil || !l.RecordOnly || len(l.Records) != 1 {
+		t.Fatalf("unexpected line %+v", l)
+	}
+
+	for _, raw := range []string{
+		"{side=both} text",
+		"{min_usd=lots} text",
+		"{symbols=re:(} text",
+		"{hours=22:00} text",
+		"{record=decade} text",
+		"{colour=red} text",
+		"{side=long text",
+	} {
+		if _, err := parseSnarkLine(raw); err == nil {
+			t.Errorf("%q: expected an error", raw)
+		}
+	}
+
+	for _, path := range []string{snarkFile, "es/memes.txt", "ja/memes.txt"} {
+		if _, err := loadCorpus(builtinText, path, multiKillFile); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestSnarkMatch(t *testing.T) {
+	short := testLiquidation("XBTUSD", "Buy", 2000).ToCombined()
+	long := testLiquidation("ETHUSD", "Sell", 2000).ToCombined()
+	night := time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC)
+	day := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	table := []struct {
+		Line     string
+		Context  snarkContext
+		Expected bool
+	}{
+		{"any", snarkContext{Liquidation: long}, true},
+		{"{side=short} x", snarkContext{Liquidation: short}, true},
+		{"{side=short} x", snarkContext{Liquidation: long}, false},
+		{"{min_usd=3000} x", snarkContext{Liquidation: short}, false},
+		{"{max_usd=1000} x", snarkContext{Liquidation: short}, false},
+		{"{symbols=XBT*} x", snarkContext{Liquidation: short}, true},
+		{"{symbols=XBT*} x", snarkContext{Liquidation: long}, false},
+		{"{hours=22:00-06:00} x", snarkContext{Liquidation: short, Time: night}, true},
+		{"{hours=22:00-06:00} x", snarkContext{Liquidation: short, Time: day}, false},
+		{"{record} x", snarkContext{Liquidation: short}, false},
+		{"{record} x", snarkContext{Liquidation: short, Records: map[string]bool{RecordDay: true}}, true},
+		{"{record} x", snarkContext{Liquidation: short, Records: map[string]bool{RecordAllTime: false}}, false},
+		{"{record=week} x", snarkContext{Liquidation: short, Records: map[string]bool{RecordDay: true}}, false},
+		{"{record=day,week} x", snarkContext{Liquidation: short, Records: map[string]bool{RecordDay: true}}, true},
+	}
+
+	for _, v := range table {
+		l, err := parseSnarkLine(v.Line)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result := l.Match(v.Context); result != v.Expected {
+			t.Errorf("%q: expected %v got %v", v.Line, v.Expected, result)
+		}
+	}
+}
+
+func TestSnarkFormat(t *testing.T) {
+	cl := testLiquidation("XBTUSD", "Buy", 1500000).ToCombined()
+	ctx := snarkContext{Liquidation: cl, Streak: 3}
+	l := SnarkLine{Text: "$SIDE $SYMBOL $$USD @ $PRICE x$STREAK"}
+
+	if s := l.Format(ctx, englishLocale); s != "short XBTUSD $1,500,000 @ 50,000 x3" {
+		t.Error("unexpected", s)
+	}
+
+	if s := l.Format(ctx, locales["es"]); s != "corta XBTUSD $1.500.000 @ 50.000 x3" {
+		t.Error("unexpected", s)
+	}
+}
+
+func TestSnarkDeck(t *testing.T) {
+	lines, err := parseSnark("a\nb\nc\n{side=short} d\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := Corpus{Snark: lines}
+	c.shuffle()
+
+	long := snarkContext{Liquidation: testLiquidation("XBTUSD", "Sell", 1000).ToCombined()}
+
+	// Every suitable line is dealt once before any repeat
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		s := c.nextSnark(long)
+		if seen[s] || s == "d" {
+			t.Fatal("unexpected snark", s, seen)
+		}
+		seen[s] = true
+	}
+
+	// Continue the deck after a restart, lines removed from the corpus are skipped
+	c = Corpus{Snark: lines}
+	c.restoreDeck([]string{"gone", "b", "a"})
+	if s := c.nextSnark(long); s != "b" {
+		t.Fatal("expected the saved deck to continue", s)
+	}
+
+	if c.savedDeck()[0] != "a" {
+		t.Fatal("unexpected deck", c.savedDeck())
+	}
+
+	// Lines left in the deck keep their place when the dealt lines are shuffled back in
+	c = Corpus{Snark: lines}
+	c.restoreDeck([]string{"{side=short} d"})
+	if s := c.nextSnark(long); s == "d" || s == "" {
+		t.Fatal("unexpected snark", s)
+	}
+
+	if saved := c.savedDeck(); len(saved) != 3 || saved[0] != "{side=short} d" {
+		t.Fatal("expected the short line to stay at the top of the deck", saved)
+	}
+
+	if s := c.nextSnark(snarkContext{Liquidation: testLiquidation("XBTUSD", "Buy", 1000).ToCombined()}); s != "d" {
+		t.Fatal("unexpected snark", s)
+	}
+
+	// Nothing suits
+	short := Corpus{Snark: lines[:1]}
+	short.shuffle()
+	if s := short.nextSnark(snarkContext{Liquidation: testLiquidation("XBTUSD", "Buy", 1000).ToCombined()}); s != "a" {
+		t.Fatal("unexpected snark", s)
+	}
+
+	only := Corpus{Snark: lines[3:]}
+	only.shuffle()
+	if s := only.nextSnark(long); s != "" {
+		t.Fatal("expected no snark", s)
+	}
+}
+
+func TestSnarkDeckSaved(t *testing.T) {
+	lines, err := parseSnark("a\nb\nc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "high_scores.json")
+	s := &State{
+		SaveFile:   path,
+		HighScores: newHighScores(),
+		Corpus:     Corpus{Snark: lines},
+	}
+	s.Corpus.shuffle()
+
+	ctx := snarkContext{Liquidation: testLiquidation("XBTUSD", "Sell", 1000).ToCombined()}
+	first := s.dealSnark(&s.Corpus, ctx)
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	hs, err := loadHighScores(path, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := Corpus{Snark: lines}
+	c.restoreDeck(hs.Snark["en"])
+	for i := 0; i < 2; i++ {
+		if s := c.nextSnark(ctx); s == first {
+			t.Fatal("repeated snark after a restart", s)
+		}
+	}
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
 	"math/rand"
 	"os"
 	"strconv"
@@ -13,26 +17,81 @@ import (
 type (
 	// State tracks of the largest liquidations as well as kill streaks.
 	State struct {
-		SaveFile   string
+		SaveFile   string // Kept in memory only if empty
 		HighScores HighScores
 
-		Snark      []string
-		SnarkIndex int
+		// Records roll over at midnight in this timezone.
+		Location *time.Location
 
-		MultiKill []string
+		// Clock used for records and streaks, time.Now if nil.
+		Clock func() time.Time
+
+		// Where the snark, kill streaks and medals are loaded from, the built in text if nil
+		Text fs.FS
+
+		// Snark and kill streaks in English, and other languages by locale name
+		Corpus
+		Translations map[string]*Corpus
+
+		Medals *medalEngine
+
+		// Changed since the last save, and saved since the last backup
+		dirty      bool
+		unbackedUp bool
 
 		sync.Mutex
 	}
 
-	// Scores for a particular symbol.
-	Scores struct {
-		HighestDay   float64 `json:"highest_day"`
-		HighestWeek  float64 `json:"highest_week"`
-		HighestMonth float64 `json:"highest_month"`
+	// Corpus is the snark and kill streak text for a language.
+	Corpus struct {
+		Locale    Locale // Language of the placeholders, English if empty
+		Snark     []SnarkLine
+		MultiKill []string
+
+		// Snark left in the current shuffle, indexes into Snark
+		deck []int
+	}
 
-		LastDay   int        `json:"last_day"`
-		LastWeek  int        `json:"last_week"`
-		LastMonth time.Month `json:"last_month"`
+	// Scores for a particular symbol, liquidated longs and shorts hold separate records.
+	Scores struct {
+		Long  SideScores `json:"long"`
+		Short SideScores `json:"short"`
+
+		// Version 1 records by quantity for both sides, only read to migrate
+		HighestDay   float64    `json:"highest_day,omitempty"`
+		HighestWeek  float64    `json:"highest_week,omitempty"`
+		HighestMonth float64    `json:"highest_month,omitempty"`
+		LastDay      int        `json:"last_day,omitempty"`
+		LastWeek     int        `json:"last_week,omitempty"`
+		LastMonth    time.Month `json:"last_month,omitempty"`
+	}
+
+	// SideScores are the records for one side of a symbol.
+	SideScores struct {
+		Day     Record `json:"day"`
+		Week    Record `json:"week"`
+		Month   Record `json:"month"`
+		Year    Record `json:"year"`
+		AllTime Record `json:"all_time"`
+
+		// The periods the records were set in
+		DayKey   string `json:"day_key"`   // 2006-01-02
+		WeekKey  string `json:"week_key"`  // ISO week, 2006-W01
+		MonthKey string `json:"month_key"` // 2006-01
+		YearKey  string `json:"year_key"`  // 2006
+
+		// Version 2 periods which did not include the year, only read to migrate
+		LastDay   int        `json:"last_day,omitempty"`
+		LastWeek  int        `json:"last_week,omitempty"`
+		LastMonth time.Month `json:"last_month,omitempty"`
+		LastYear  int        `json:"last_year,omitempty"`
+	}
+
+	// Record is the largest single liquidation in a period.
+	Record struct {
+		USDValue float64 `json:"usd_value"`
+		Price    float64 `json:"price"`
+		UnixTime int64   `json:"unix_time"`
 	}
 
 	// Kill stores the last time a position was liquidated on a symbol.
@@ -44,322 +103,659 @@ type (
 
 	// HighScores defines a data structure that store high scores.
 	HighScores struct {
+		Version int `json:"version"` // Schema version, see highScoresVersion
+
 		Scores map[Symbol]Scores `json:"scores"`
 		Kills  map[Symbol]Kill   `json:"kills"`
-	}
 
-	// A Medal is awarded to the liquidation if it breaks a high score.
-	Medal int32
+		// Snark left in the current shuffle by locale, so lines are not repeated after a restart
+		Snark map[string][]string `json:"snark,omitempty"`
+	}
 
 	// Decoration attached to a liquidation.
 	Decoration struct {
-		Streak string  // Multikills
-		Medals []Medal // Medals
-		Snark  string  // Snarky meme text to salt the wound
+		PriceContext string  // Recent price move, e.g. "(-4.2% in 15m)"
+		Streak       string  // Multikills
+		Medals       []Medal // Medals, highest priority first
+		Snark        string  // Snarky meme text to salt the wound
+
+		// Streak and snark in other languages by locale name
+		Localized map[string]LocalizedText
+	}
+
+	// LocalizedText is the decoration text in another language.
+	LocalizedText struct {
+		Streak string
+		Snark  string
 	}
 )
 
-// Medals a liqudiation can win.
+// High scores schema version, bumped when the format changes so older files can be migrated.
+//
+//	1: added the version
+//	2: records by USD value per side instead of by quantity
+//	3: records are kept for calendar periods including the year
+const highScoresVersion = 3
+
+// Number of previous high scores files kept in case the latest is corrupt.
+const highScoresBackups = 5
+
+// How often the high scores are saved and backed up if they have changed.
 const (
-	MedalLargestToday Medal = iota
-	MedalLargestWeek
-	MedalLargestMonth
+	highScoresSaveInterval   = 5 * time.Second
+	highScoresBackupInterval = time.Hour
+)
 
-	Medal100k      // Awarded for every 100k
-	MedalStreak    // Killed as part of a kill streak
-	MedalSecKilled // Killed within two seconds of the previous
+// NewState loads the state from the paths, records roll over in the given timezone.
+func NewState(loc *time.Location, paths Paths) (*State, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
 
-	MedalOne // For the very small 1 position liquidations
+	var state State
 
-	// TODO: More to come
-)
+	// Load high scores
+	hs, err := loadHighScores(paths.State(highScoresFile), loc)
+	if err != nil {
+		return nil, err
+	}
+	state.HighScores = hs
+	state.SaveFile = paths.State(highScoresFile)
+	state.Location = loc
+	state.Text = paths.Text()
+
+	// Load memes and multi-kill
+	if state.Corpus, err = loadCorpus(state.Text, snarkFile, multiKillFile); err != nil {
+		return nil, err
+	}
+	state.Corpus.Locale = englishLocale
+	state.Corpus.restoreDeck(hs.Snark[englishLocale.Name])
+
+	// Load medal rules
+	if state.Medals, err = loadMedals(state.Text); err != nil {
+		return nil, err
+	}
 
-// Twitter has extended the length limit.
-const twitterLengthLimit = 280
-
-var medalMap = map[Medal]string{
-	MedalLargestToday: "", // Disabled since liquidations are pretty rare
-	MedalLargestWeek:  "\U0001F3C5",
-	MedalLargestMonth: "\U0001F3C6",
-	Medal100k:         "\U0001F4AF",
-	MedalStreak:       "\U0001F525",
-	MedalSecKilled:    "\U000026A1",
-	MedalOne:          "\U0001F947",
+	return &state, nil
 }
 
-// NewState returns a new state object.
-func NewState() (*State, error) {
-	// TODO: move hardcoded files out of here.
-	highScoresFile := "high_scores.json"
-	snarkFile := "text/memes.txt"
-	multiKillFile := "text/kill_streaks.txt"
+// loadTranslation loads the snark and kill streaks of a locale.
+func loadTranslation(fsys fs.FS, locale string) (Corpus, error) {
+	loc, err := findLocale(locale)
+	if err != nil {
+		return Corpus{}, err
+	}
 
-	var state State
+	snark, multiKill := translationFiles(locale)
+	c, err := loadCorpus(fsys, snark, multiKill)
+	if err != nil {
+		return Corpus{}, err
+	}
+	c.Locale = loc
 
-	// Load high scores
-	if f, err := os.Open(highScoresFile); err != nil {
-		state.HighScores = HighScores{
-			make(map[Symbol]Scores),
-			make(map[Symbol]Kill),
+	return c, nil
+}
+
+// LoadTranslation loads the snark and kill streaks for a locale from <locale>/ of the text.
+func (s *State) LoadTranslation(locale string) error {
+	c, err := loadTranslation(s.text(), locale)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	c.restoreDeck(s.HighScores.Snark[locale])
+
+	if s.Translations == nil {
+		s.Translations = make(map[string]*Corpus)
+	}
+	s.Translations[locale] = &c
+
+	return nil
+}
+
+// ReloadText loads the snark, kill streaks and medals again, with the translations for the locales.
+// Nothing is replaced unless all of them load.
+func (s *State) ReloadText(locales []string) error {
+	c, err := loadCorpus(s.text(), snarkFile, multiKillFile)
+	if err != nil {
+		return err
+	}
+	c.Locale = englishLocale
+
+	medals, err := loadMedals(s.text())
+	if err != nil {
+		return err
+	}
+
+	translations := make(map[string]*Corpus)
+	for _, name := range locales {
+		locale, err := findLocale(name)
+		if err != nil {
+			return err
 		}
-	} else {
-		defer f.Close()
 
-		if err := json.NewDecoder(f).Decode(&state.HighScores); err != nil {
-			return nil, err
+		if locale.Name == englishLocale.Name || translations[locale.Name] != nil {
+			continue
 		}
+
+		t, err := loadTranslation(s.text(), locale.Name)
+		if err != nil {
+			return fmt.Errorf("%v: %w", locale.Name, err)
+		}
+		translations[locale.Name] = &t
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	var medalRules int
+	if s.Medals != nil {
+		medalRules = len(s.Medals.rules)
+	}
+
+	reloadLog.Info("Reloaded text",
+		"snark", len(c.Snark), "was_snark", len(s.Snark),
+		"kill_streaks", len(c.MultiKill), "was_kill_streaks", len(s.MultiKill),
+		"medal_rules", len(medals.rules), "was_medal_rules", medalRules,
+		"translations", len(translations), "was_translations", len(s.Translations))
+
+	c.restoreDeck(s.HighScores.Snark[englishLocale.Name])
+	s.Corpus = c
+	s.Medals = medals
+
+	for locale, t := range translations {
+		t.restoreDeck(s.HighScores.Snark[locale])
 	}
-	state.SaveFile = highScoresFile
+	s.Translations = translations
+
+	return nil
+}
+
+// loadCorpus loads the snark and kill streaks, one per line.
+func loadCorpus(fsys fs.FS, snarkFile, multiKillFile string) (Corpus, error) {
+	var c Corpus
 
 	// Load memes
-	snarkText, err := os.ReadFile(snarkFile)
+	snarkText, err := fs.ReadFile(fsys, snarkFile)
 	if err != nil {
-		return nil, err
+		return Corpus{}, err
 	}
-	state.Snark = strings.Split(strings.TrimSpace(string(snarkText)), "\n")
-
-	// Shuffle
-	state.resetSnark()
+	if c.Snark, err = parseSnark(string(snarkText)); err != nil {
+		return Corpus{}, fmt.Errorf("%v: %w", snarkFile, err)
+	}
+	c.shuffle()
 
 	// Load multi-kill
-	multiKillText, err := os.ReadFile(multiKillFile)
+	multiKillText, err := fs.ReadFile(fsys, multiKillFile)
 	if err != nil {
-		return nil, err
+		return Corpus{}, err
 	}
-	state.MultiKill = strings.Split(strings.TrimSpace(string(multiKillText)), "\n")
+	c.MultiKill = strings.Split(strings.TrimSpace(string(multiKillText)), "\n")
 
-	return &state, nil
+	return c, nil
+}
+
+// streak returns the kill streak text for a number of positions liquidated in a row.
+func (c *Corpus) streak(count int, symbol Symbol) string {
+	var streakStrRaw string
+	count -= 2
+	if count < 0 {
+		// No streak
+	} else if count >= len(c.MultiKill) {
+		streakStrRaw = c.MultiKill[len(c.MultiKill)-1] + " x" + strconv.Itoa(count+2)
+	} else {
+		streakStrRaw = c.MultiKill[count]
+	}
+
+	return strings.Replace(streakStrRaw, "$SYMBOL", string(symbol), -1)
 }
 
-// resetSnark shuffles the snark array and resets the counter.
-func (s *State) resetSnark() {
-	s.SnarkIndex = 0
-	for i := range s.Snark {
-		j := rand.Intn(i + 1)
-		s.Snark[i], s.Snark[j] = s.Snark[j], s.Snark[i]
+// newHighScores returns empty high scores.
+func newHighScores() HighScores {
+	return HighScores{
+		Version: highScoresVersion,
+		Scores:  make(map[Symbol]Scores),
+		Kills:   make(map[Symbol]Kill),
 	}
 }
 
+// loadHighScores loads the high scores, falling back to the newest backup if the file is corrupt.
+func loadHighScores(path string, loc *time.Location) (HighScores, error) {
+	var hs HighScores
+	usedPath, err := readFileRotated(path, highScoresBackups, func(raw []byte) error {
+		var v HighScores
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return err
+		}
+
+		if err := v.migrate(loc); err != nil {
+			return err
+		}
+
+		hs = v
+		return nil
+	})
+
+	if errors.Is(err, os.ErrNotExist) {
+		return newHighScores(), nil
+	} else if err != nil {
+		return HighScores{}, err
+	}
+
+	if usedPath != path {
+		stateLog.Warn("High scores file is missing or corrupt, restored from a backup", "file", path, "backup", usedPath)
+	}
+
+	return hs, nil
+}
+
+// migrate upgrades high scores written by older versions to the current schema.
+func (hs *HighScores) migrate(loc *time.Location) error {
+	if hs.Version > highScoresVersion {
+		return fmt.Errorf("high scores version %v is newer than supported version %v", hs.Version, highScoresVersion)
+	}
+
+	if hs.Scores == nil {
+		hs.Scores = make(map[Symbol]Scores)
+	}
+
+	// Version 1 records were by quantity for both sides
+	if hs.Version < 2 {
+		now := time.Now().In(loc)
+		for symbol, scores := range hs.Scores {
+			scores.migrateQuantities(now)
+			hs.Scores[symbol] = scores
+		}
+	}
+
+	// Version 2 periods are missing the year, take them from when the records were set instead
+	if hs.Version < 3 {
+		for symbol, scores := range hs.Scores {
+			scores.Long.migratePeriods(loc)
+			scores.Short.migratePeriods(loc)
+			hs.Scores[symbol] = scores
+		}
+	}
+	if hs.Kills == nil {
+		hs.Kills = make(map[Symbol]Kill)
+	}
+
+	hs.Version = highScoresVersion
+	return nil
+}
+
 // save stores the high scores back to disk.
 func (s *State) save() error {
-	f, err := os.OpenFile(s.SaveFile, os.O_WRONLY|os.O_CREATE, 0644)
+	if s.SaveFile == "" {
+		return nil
+	}
+
+	raw, err := json.Marshal(s.HighScores)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	if err := json.NewEncoder(f).Encode(s.HighScores); err != nil {
+	if err := writeFileAtomic(s.SaveFile, raw); err != nil {
 		return err
 	}
 
+	s.dirty = false
+	s.unbackedUp = true
 	return nil
 }
 
-// Linear interpolation
-func lerp(x, y, z, start, end float64) float64 {
-	return start + ((z-x)/(y-x))*(end-start)
+// saveChanged stores the high scores if they have changed since they were last saved.
+func (s *State) saveChanged() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.dirty {
+		return nil
+	}
+
+	return s.save()
 }
 
-// Decorate a new liquidation.
-func (s *State) Decorate(cl CombinedLiquidation) Decoration {
+// Backup copies the high scores to the first backup if they have been saved since the last one.
+func (s *State) Backup() error {
 	s.Lock()
 	defer s.Unlock()
 
-	// Save the state
-	defer s.save()
-
-	// Hand out medals
-	var medals []Medal
+	if s.SaveFile == "" || !s.unbackedUp {
+		return nil
+	}
 
-	// Issue the 1 medal
-	if cl.MinQuantity() == 1 {
-		medals = append(medals, MedalOne)
+	if err := backupFile(s.SaveFile, highScoresBackups); err != nil {
+		return err
 	}
 
-	scores := s.HighScores.Scores[cl.Symbol]
+	s.unbackedUp = false
+	return nil
+}
 
-	// Expire the scores if their time has reached
-	now := time.Now()
-	if now.Day() != scores.LastDay {
-		scores.LastDay = now.Day()
-		scores.HighestDay = 0
-	}
+// RunSaver periodically saves and backs up the high scores which have changed until the context is done.
+func (s *State) RunSaver(ctx context.Context, saveInterval, backupInterval time.Duration) {
+	save := time.NewTicker(saveInterval)
+	defer save.Stop()
 
-	_, week := now.ISOWeek()
-	if week != scores.LastWeek {
-		scores.LastWeek = week
-		scores.HighestWeek = 0
+	backup := time.NewTicker(backupInterval)
+	defer backup.Stop()
+
+	for {
+		select {
+		case <-save.C:
+			if err := s.saveChanged(); err != nil {
+				stateLog.Error("Failed to save high scores", "err", err)
+			}
+
+		case <-backup.C:
+			if err := s.Backup(); err != nil {
+				stateLog.Error("Failed to back up high scores", "err", err)
+			}
+
+		case <-ctx.Done():
+			return
+		}
 	}
+}
+
+// Save stores the high scores back to disk.
+func (s *State) Save() error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.save()
+}
+
+// SymbolScores returns the records and kill streak of a symbol.
+func (s *State) SymbolScores(symbol Symbol) (Scores, Kill) {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.HighScores.Scores[symbol], s.HighScores.Kills[symbol]
+}
+
+// SetSymbolScores replaces the records of a symbol.
+func (s *State) SetSymbolScores(symbol Symbol, scores Scores) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.HighScores.Scores[symbol] = scores
+	return s.save()
+}
 
-	if now.Month() != scores.LastMonth {
-		scores.LastMonth = now.Month()
-		scores.HighestMonth = 0
+// ResetSymbolScores forgets the records and kill streak of a symbol.
+func (s *State) ResetSymbolScores(symbol Symbol) error {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.HighScores.Scores, symbol)
+	delete(s.HighScores.Kills, symbol)
+	return s.save()
+}
+
+// dealSnark deals the next snark from a corpus and remembers the rest of the deck.
+func (s *State) dealSnark(c *Corpus, ctx snarkContext) string {
+	text := c.nextSnark(ctx)
+
+	if s.HighScores.Snark == nil {
+		s.HighScores.Snark = make(map[string][]string)
 	}
+	s.HighScores.Snark[c.locale().Name] = c.savedDeck()
 
-	maxQuantity := cl.MaxQuantity()
+	return text
+}
 
-	// Issue medal for each of the periods
-	if maxQuantity >= scores.HighestWeek {
-		scores.HighestWeek = maxQuantity
-		medals = append(medals, MedalLargestWeek)
+// text returns where the snark, kill streaks and medals are loaded from.
+func (s *State) text() fs.FS {
+	if s.Text != nil {
+		return s.Text
 	}
 
-	if maxQuantity >= scores.HighestMonth {
-		scores.HighestMonth = maxQuantity
-		medals = append(medals, MedalLargestMonth)
+	return builtinText
+}
+
+// now returns the current time from the clock.
+func (s *State) now() time.Time {
+	if s.Clock != nil {
+		return s.Clock()
 	}
 
-	// Award the 100k medals
-	for i := int64(0); i < int64(cl.USDValue()/100000); i++ {
-		medals = append(medals, Medal100k)
+	return time.Now()
+}
+
+// location returns the timezone records roll over in.
+func (s *State) location() *time.Location {
+	if s.Location != nil {
+		return s.Location
 	}
 
-	s.HighScores.Scores[cl.Symbol] = scores
+	return time.UTC
+}
+
+// Period keys identify the calendar period a time falls in.
+func dayKey(t time.Time) string   { return t.Format("2006-01-02") }
+func monthKey(t time.Time) string { return t.Format("2006-01") }
+func yearKey(t time.Time) string  { return t.Format("2006") }
 
-	// Issue the streak
-	streak := s.HighScores.Kills[cl.Symbol]
+func weekKey(t time.Time) string {
+	year, week := t.ISOWeek()
+	return fmt.Sprintf("%04d-W%02d", year, week)
+}
 
-	if now.Unix()-streak.UnixTime > 60 {
-		streak.Count = 0
+// expire resets the records from earlier periods, now must be in the records timezone.
+func (ss *SideScores) expire(now time.Time) {
+	if key := dayKey(now); key != ss.DayKey {
+		ss.DayKey = key
+		ss.Day = Record{}
 	}
-	streak.Count += len(cl.Liquidations)
-	if streak.Count >= 2 {
-		medals = append(medals, MedalStreak)
+
+	if key := weekKey(now); key != ss.WeekKey {
+		ss.WeekKey = key
+		ss.Week = Record{}
 	}
 
-	// Issue the medal for being Seckilled
-	if now.Unix()-streak.UnixTime <= 10 {
-		medals = append(medals, MedalSecKilled)
+	if key := monthKey(now); key != ss.MonthKey {
+		ss.MonthKey = key
+		ss.Month = Record{}
 	}
 
-	streak.UnixTime = now.Unix()
-	s.HighScores.Kills[cl.Symbol] = streak
+	if key := yearKey(now); key != ss.YearKey {
+		ss.YearKey = key
+		ss.Year = Record{}
+	}
+}
 
-	// Issue the snark
-	// Because we have limited text, we will not be able to issue snark every single time.
+// migrateQuantities converts the version 1 records to records of both sides, counting each contract as a dollar
+// as it is for the inverse USD contracts. They were set on the last day seen, the latest day of that date up to now.
+func (sc *Scores) migrateQuantities(now time.Time) {
+	var set int64
+	if sc.LastMonth != 0 && sc.LastDay != 0 {
+		t := time.Date(now.Year(), sc.LastMonth, sc.LastDay, 0, 0, 0, 0, now.Location())
+		if t.After(now) {
+			t = t.AddDate(-1, 0, 0)
+		}
+		set = t.Unix()
+	}
 
-	// USD value:    0 -------- 100k ------------ 500k ------------ 2.25m --------->
-	// Snark prob:        0%            5%-13%           13%-40%
-	var issueSnark bool
+	record := func(quantity float64) Record {
+		if quantity == 0 {
+			return Record{}
+		}
+		return Record{USDValue: quantity, UnixTime: set}
+	}
 
-	usdVal := cl.USDValue()
-	switch {
-	case usdVal <= 100000:
-		issueSnark = false
-	case usdVal <= 500000:
-		issueSnark = lerp(50000, 500000, usdVal, 0.05, 0.13) > rand.Float64()
-	default:
-		issueSnark = lerp(500000, 2250000, usdVal, 0.13, 0.40) > rand.Float64()
+	side := SideScores{
+		Day:   record(sc.HighestDay),
+		Week:  record(sc.HighestWeek),
+		Month: record(sc.HighestMonth),
 	}
+	sc.Long, sc.Short = side, side
 
-	var snark string
+	sc.HighestDay, sc.HighestWeek, sc.HighestMonth = 0, 0, 0
+	sc.LastDay, sc.LastWeek, sc.LastMonth = 0, 0, 0
+}
 
-	if issueSnark {
-		s.SnarkIndex = (s.SnarkIndex + 1) % len(s.Snark)
-		// Check if we've wrapped around now
-		if s.SnarkIndex == 0 {
-			s.resetSnark()
+// migratePeriods sets the period keys from when the version 2 records were set.
+func (ss *SideScores) migratePeriods(loc *time.Location) {
+	key := func(r Record, f func(time.Time) string) string {
+		if r.UnixTime == 0 {
+			return ""
 		}
-		snark = s.Snark[s.SnarkIndex]
+		return f(time.Unix(r.UnixTime, 0).In(loc))
 	}
 
-	// TODO: refactor this
+	ss.DayKey = key(ss.Day, dayKey)
+	ss.WeekKey = key(ss.Week, weekKey)
+	ss.MonthKey = key(ss.Month, monthKey)
+	ss.YearKey = key(ss.Year, yearKey)
 
-	var streakStrRaw string
-	streak.Count -= 2
-	if streak.Count < 0 {
-		// No streak
-	} else if streak.Count >= len(s.MultiKill) {
-		streakStrRaw = s.MultiKill[len(s.MultiKill)-1] + " x" + strconv.Itoa(streak.Count+2)
-	} else {
-		streakStrRaw = s.MultiKill[streak.Count]
+	ss.LastDay, ss.LastWeek, ss.LastMonth, ss.LastYear = 0, 0, 0, 0
+}
+
+// updateRecords sets the records of a contract broken by its largest liquidation, marking them in records.
+func (s *State) updateRecords(c CombinedLiquidation, now time.Time, records map[string]bool) {
+	scores := s.HighScores.Scores[c.Symbol]
+	side := &scores.Long
+	if c.Side == "Buy" {
+		side = &scores.Short
 	}
-	streakStr := strings.Replace(streakStrRaw, "$SYMBOL", string(cl.Symbol), -1)
-	snarkStr := strings.Replace(snark, "$SYMBOL", string(cl.Symbol), -1)
 
-	return Decoration{
-		Streak: streakStr,
-		Medals: medals,
-		Snark:  snarkStr,
+	// Expire the scores if their time has reached
+	side.expire(now.In(s.location()))
+
+	largest := c.Largest()
+	record := Record{
+		USDValue: largest.TotalUSDValue,
+		Price:    largest.Price,
+		UnixTime: now.Unix(),
 	}
-}
 
-func (d Decoration) hasMedals() bool {
-	return len(d.Medals) > 0
-}
+	periods := []struct {
+		name   string
+		record *Record
+	}{
+		{RecordDay, &side.Day},
+		{RecordWeek, &side.Week},
+		{RecordMonth, &side.Month},
+		{RecordYear, &side.Year},
+		{RecordAllTime, &side.AllTime},
+	}
 
-func (d Decoration) hasSnark() bool {
-	return d.Snark != ""
+	for _, p := range periods {
+		if record.USDValue < p.record.USDValue {
+			continue
+		}
+
+		// The first liquidation seen is not much of an all-time record
+		records[p.name] = records[p.name] || p.name != RecordAllTime || p.record.UnixTime != 0
+		*p.record = record
+	}
+
+	s.HighScores.Scores[c.Symbol] = scores
 }
 
-func (d Decoration) hasStreak() bool {
-	return d.Streak != ""
+// Linear interpolation
+func lerp(x, y, z, start, end float64) float64 {
+	return start + ((z-x)/(y-x))*(end-start)
 }
 
-func (d Decoration) medalsRunes() (result []rune) {
-	if !d.hasMedals() {
-		return
+// Decorate a new liquidation.
+func (s *State) Decorate(cl CombinedLiquidation) Decoration {
+	s.Lock()
+	defer s.Unlock()
+
+	// Records broken, used to hand out medals
+	records := make(map[string]bool)
+
+	// Each contract keeps its own records and streak, any record broken counts and the primary symbol's streak is used
+	now := s.now()
+	contracts := cl.Contracts()
+	kills := make([]Kill, len(contracts))
+	for i, c := range contracts {
+		s.updateRecords(c, now, records)
+
+		kill := s.HighScores.Kills[c.Symbol]
+		if now.Unix()-kill.UnixTime > 60 {
+			kill.Count = 0
+		}
+		kill.Count += len(c.Liquidations)
+		kills[i] = kill
 	}
 
-	result = append(result, ' ')
-	for _, medal := range d.Medals {
-		result = append(result, []rune(medalMap[medal])...)
+	// Issue the streak
+	streak := kills[0]
+
+	sinceLastKill := time.Duration(now.Unix()-streak.UnixTime) * time.Second
+	if streak.UnixTime == 0 {
+		sinceLastKill = -1
 	}
-	return
-}
 
-func (d Decoration) streakRunes() []rune {
-	if !d.hasStreak() {
-		return nil
+	// Hand out medals
+	medals := s.Medals.Award(medalContext{
+		Liquidation:   cl,
+		Streak:        streak.Count,
+		SinceLastKill: sinceLastKill,
+		Records:       records,
+	})
+
+	for i, c := range contracts {
+		kills[i].UnixTime = now.Unix()
+		s.HighScores.Kills[c.Symbol] = kills[i]
 	}
 
-	return append([]rune(" ~ "), []rune(d.Streak)...)
-}
+	// Issue the snark
+	// Because we have limited text, we will not be able to issue snark every single time.
 
-func (d Decoration) snarkRunes() []rune {
-	if !d.hasSnark() {
-		return nil
+	// USD value:    0 -------- 100k ------------ 500k ------------ 2.25m --------->
+	// Snark prob:        0%            5%-13%           13%-40%
+	var issueSnark bool
+
+	usdVal := cl.USDValue()
+	switch {
+	case usdVal <= 100000:
+		issueSnark = false
+	case usdVal <= 500000:
+		issueSnark = lerp(50000, 500000, usdVal, 0.05, 0.13) > rand.Float64()
+	default:
+		issueSnark = lerp(500000, 2250000, usdVal, 0.13, 0.40) > rand.Float64()
 	}
 
-	return append([]rune(" ~ "), []rune(d.Snark)...)
-}
+	d := Decoration{
+		PriceContext: cl.PriceMove.String(),
+		Streak:       s.streak(streak.Count, cl.Symbol),
+		Medals:       medals,
+	}
 
-// Apply the decoratino to a liquidation string.
-func (d Decoration) Apply(liquidation string) string {
-	// We need to fit our string into the Twitter length
-	// However Twitter documentation is full of shit
-	//     https://developer.twitter.com/en/docs/basics/counting-characters.html
-	// They do not count emojis which are a single unicode codepoint as a single character, they count it as two
-	// The fact is, they've complicated this so much it requires a library (twitter-text) to figure out exactly what length they'll calculate this to be
-	// So erring on the side of safety, we'll count all text in medals as two characters
-	// This leave us with a safety margin of three characters created by ` ~ ` for any emojis in the snark itself
-	base := []rune(liquidation)
-
-	if len(base)+len(d.medalsRunes())*2+len(d.streakRunes())+len(d.snarkRunes()) <= twitterLengthLimit {
-		// It just works
-		base = append(base, d.medalsRunes()...)
-		base = append(base, d.streakRunes()...)
-		base = append(base, d.snarkRunes()...)
-		return string(base)
-	}
-
-	if len(base)+len(d.medalsRunes())*2+len(d.snarkRunes()) <= twitterLengthLimit {
-		// We'll do without the streak then
-		base = append(base, d.medalsRunes()...)
-		base = append(base, d.snarkRunes()...)
-		return string(base)
-	}
-
-	if len(base)+len(d.snarkRunes()) <= twitterLengthLimit {
-		medalLength := (twitterLengthLimit - (len(base) + len(d.snarkRunes()))) / 2
-
-		// We'll trim the medals so that we use up the entire text, unless the medals get trimmed to nothing
-		if medalLength > 3 {
-			base = append(base, d.medalsRunes()[:medalLength]...)
+	snark := snarkContext{
+		Liquidation: cl,
+		Streak:      streak.Count,
+		Records:     records,
+		Time:        now.In(s.location()),
+	}
+
+	if issueSnark {
+		d.Snark = s.dealSnark(&s.Corpus, snark)
+	}
+
+	// The same decoration in other languages
+	for locale, c := range s.Translations {
+		if d.Localized == nil {
+			d.Localized = make(map[string]LocalizedText)
 		}
-		base = append(base, d.snarkRunes()...)
-		return string(base)
+
+		lt := LocalizedText{Streak: c.streak(streak.Count, cl.Symbol)}
+		if issueSnark {
+			lt.Snark = s.dealSnark(c, snark)
+		}
+		d.Localized[locale] = lt
 	}
 
-	return string(base)
+	// Saved by RunSaver, and on shutdown
+	s.dirty = true
+
+	return d
 }
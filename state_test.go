@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"math/rand"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -10,22 +14,29 @@ import (
 // TODO: fix these tests so they work without inspection
 
 func verify(result string, t *testing.T) {
-	if len([]rune(result)) > twitterLengthLimit {
+	if postLength(result) > twitterLengthLimit {
 		t.Fatal("longer than the length limit")
 	}
 }
 
 func TestSymbolLiquidator(t *testing.T) {
-	s, err := NewState()
+	s, err := NewState(time.UTC, Paths{StateDir: t.TempDir()})
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	liqChan := make(chan Liquidation)
 	tweetChan := make(chan preparedTweet)
-	go symbolLiquidator(s, liqChan, tweetChan)
+	done := make(chan struct{})
 
+	// Wait for the flush on close, it writes the high scores into the temporary directory
 	go func() {
+		defer close(tweetChan)
+		symbolLiquidator(context.Background(), newLiveConfig(BotConfig{}), s, liqChan, nil, tweetChan)
+	}()
+
+	go func() {
+		defer close(done)
 		for result := range tweetChan {
 			// It is a lot easier to test by inspection
 			log.Println(result)
@@ -48,6 +59,7 @@ func TestSymbolLiquidator(t *testing.T) {
 		liqChan <- l
 	}
 	close(liqChan)
+	<-done
 }
 
 func TestStateSimple(t *testing.T) {
@@ -57,7 +69,7 @@ func TestStateSimple(t *testing.T) {
 		2: "XBJ24H",
 	}
 
-	s, err := NewState()
+	s, err := NewState(time.UTC, Paths{StateDir: t.TempDir()})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -73,7 +85,7 @@ func TestStateSimple(t *testing.T) {
 			Symbol: symbols[i%len(symbols)],
 			Side:   "Buy",
 		}
-		result := s.Decorate(l.ToCombined()).Apply(l.String())
+		result := defaultPostFormatter.Render(l.ToCombined(), s.Decorate(l.ToCombined()))
 
 		// It is a lot easier to test by inspection
 		log.Println(result)
@@ -82,7 +94,7 @@ func TestStateSimple(t *testing.T) {
 }
 
 func TestStreaks(t *testing.T) {
-	s, err := NewState()
+	s, err := NewState(time.UTC, Paths{StateDir: t.TempDir()})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -98,7 +110,7 @@ func TestStreaks(t *testing.T) {
 			Side:   "Buy",
 		}
 
-		result := s.Decorate(l.ToCombined()).Apply(l.String())
+		result := defaultPostFormatter.Render(l.ToCombined(), s.Decorate(l.ToCombined()))
 		log.Println(result)
 		verify(result, t)
 	}
@@ -115,7 +127,7 @@ func TestStreaks(t *testing.T) {
 			Symbol: "BTCUSD",
 			Side:   "Buy",
 		}
-		result := s.Decorate(l.ToCombined()).Apply(l.String())
+		result := defaultPostFormatter.Render(l.ToCombined(), s.Decorate(l.ToCombined()))
 
 		log.Println(result)
 		verify(result, t)
@@ -133,7 +145,7 @@ func TestStreaks(t *testing.T) {
 			Symbol: "BTCUSD",
 			Side:   "Buy",
 		}
-		result := s.Decorate(l.ToCombined()).Apply(l.String())
+		result := defaultPostFormatter.Render(l.ToCombined(), s.Decorate(l.ToCombined()))
 
 		time.Sleep(3 * time.Second)
 
@@ -143,7 +155,7 @@ func TestStreaks(t *testing.T) {
 }
 
 func Test10m(t *testing.T) {
-	s, err := NewState()
+	s, err := NewState(time.UTC, Paths{StateDir: t.TempDir()})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -158,7 +170,7 @@ func Test10m(t *testing.T) {
 		Side:   "Buy",
 	}
 
-	result := s.Decorate(l.ToCombined()).Apply(l.String())
+	result := defaultPostFormatter.Render(l.ToCombined(), s.Decorate(l.ToCombined()))
 
 	log.Println(result)
 	verify(result, t)
@@ -173,7 +185,7 @@ func Test10m(t *testing.T) {
 		Side:   "Buy",
 	}
 
-	result = s.Decorate(l.ToCombined()).Apply(l.String())
+	result = defaultPostFormatter.Render(l.ToCombined(), s.Decorate(l.ToCombined()))
 
 	log.Println(result)
 	verify(result, t)
@@ -188,8 +200,211 @@ func Test10m(t *testing.T) {
 		Side:   "Buy",
 	}
 
-	result = s.Decorate(l.ToCombined()).Apply(l.String())
+	result = defaultPostFormatter.Render(l.ToCombined(), s.Decorate(l.ToCombined()))
 
 	log.Println(result)
 	verify(result, t)
 }
+
+func TestStateRecordsBySide(t *testing.T) {
+	s := &State{
+		SaveFile:   filepath.Join(t.TempDir(), "high_scores.json"),
+		HighScores: newHighScores(),
+		Corpus:     Corpus{Snark: []SnarkLine{{Raw: "snark", Text: "snark"}}, MultiKill: []string{"Double kill"}},
+		Medals:     testMedalEngine(t),
+	}
+
+	hasMedal := func(d Decoration, name string) bool {
+		for _, v := range d.Medals {
+			if v.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	liq := func(side string, usd float64) CombinedLiquidation {
+		cl := testLiquidation("XBTUSD", side, usd).ToCombined()
+		cl.Liquidations[0].Price = usd / 10
+		return cl
+	}
+
+	// The first liquidation sets the records but is not an all-time record
+	d := s.Decorate(liq("Sell", 50000))
+	if !hasMedal(d, "largest_week") || !hasMedal(d, "largest_year") || hasMedal(d, "largest_all_time") {
+		t.Fatal("unexpected medals", d.Medals)
+	}
+
+	// Smaller in USD, even though it may be more contracts
+	if d := s.Decorate(liq("Sell", 40000)); hasMedal(d, "largest_week") {
+		t.Fatal("unexpected medals", d.Medals)
+	}
+
+	// Shorts have their own records
+	if d := s.Decorate(liq("Buy", 10000)); !hasMedal(d, "largest_week") || hasMedal(d, "largest_all_time") {
+		t.Fatal("unexpected medals", d.Medals)
+	}
+
+	d = s.Decorate(liq("Sell", 60000))
+	if !hasMedal(d, "largest_month") || !hasMedal(d, "largest_all_time") {
+		t.Fatal("expected an all-time record", d.Medals)
+	}
+
+	scores := s.HighScores.Scores["XBTUSD"]
+	if scores.Long.AllTime.USDValue != 60000 || scores.Long.AllTime.Price != 6000 || scores.Long.AllTime.UnixTime == 0 {
+		t.Fatalf("unexpected long record %+v", scores.Long.AllTime)
+	}
+
+	if scores.Short.AllTime.USDValue != 10000 {
+		t.Fatalf("unexpected short record %+v", scores.Short.AllTime)
+	}
+}
+
+func TestStateRecordPeriods(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table := []struct {
+		Name     string
+		Location *time.Location
+		First    time.Time
+		Second   time.Time
+		Expected []string // Medals for a smaller second liquidation
+	}{
+		{"same day", time.UTC, time.Date(2024, 1, 15, 1, 0, 0, 0, time.UTC), time.Date(2024, 1, 15, 23, 0, 0, 0, time.UTC), nil},
+		{"same day of the next month", time.UTC, time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC), time.Date(2024, 2, 15, 12, 0, 0, 0, time.UTC), []string{"largest_month", "largest_week"}},
+		{"same week number next year", time.UTC, time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC), time.Date(2025, 1, 29, 12, 0, 0, 0, time.UTC), []string{"largest_year", "largest_month", "largest_week"}},
+		{"same month next year", time.UTC, time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), time.Date(2025, 3, 31, 12, 0, 0, 0, time.UTC), []string{"largest_year", "largest_month", "largest_week"}},
+		{"ISO week spanning the new year", time.UTC, time.Date(2024, 12, 30, 12, 0, 0, 0, time.UTC), time.Date(2025, 1, 2, 12, 0, 0, 0, time.UTC), []string{"largest_year", "largest_month"}},
+		{"new year in another timezone", newYork, time.Date(2024, 12, 31, 20, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 4, 0, 0, 0, time.UTC), nil},
+		{"new year reached in the timezone", newYork, time.Date(2024, 12, 31, 20, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 6, 0, 0, 0, time.UTC), []string{"largest_year", "largest_month"}},
+		{"spring forward", newYork, time.Date(2024, 3, 10, 6, 59, 0, 0, time.UTC), time.Date(2024, 3, 11, 3, 59, 0, 0, time.UTC), nil},
+		{"fall back", newYork, time.Date(2024, 11, 3, 4, 30, 0, 0, time.UTC), time.Date(2024, 11, 4, 4, 30, 0, 0, time.UTC), nil},
+	}
+
+	for _, v := range table {
+		t.Run(v.Name, func(t *testing.T) {
+			now := v.First
+			s := &State{
+				SaveFile:   filepath.Join(t.TempDir(), "high_scores.json"),
+				HighScores: newHighScores(),
+				Location:   v.Location,
+				Clock:      func() time.Time { return now },
+				Corpus:     Corpus{Snark: []SnarkLine{{Raw: "snark", Text: "snark"}}, MultiKill: []string{"Double kill"}},
+				Medals:     testMedalEngine(t),
+			}
+
+			s.Decorate(testLiquidation("XBTUSD", "Sell", 100000).ToCombined())
+
+			now = v.Second
+			var medals []string
+			for _, m := range s.Decorate(testLiquidation("XBTUSD", "Sell", 50000).ToCombined()).Medals {
+				if strings.HasPrefix(m.Name, "largest_") {
+					medals = append(medals, m.Name)
+				}
+			}
+
+			if fmt.Sprint(medals) != fmt.Sprint(v.Expected) {
+				t.Fatalf("expected %v got %v", v.Expected, medals)
+			}
+		})
+	}
+}
+
+func TestMigrateRecordPeriods(t *testing.T) {
+	set := time.Date(2024, 1, 31, 23, 0, 0, 0, time.UTC)
+	hs := HighScores{
+		Version: 2,
+		Scores: map[Symbol]Scores{
+			"XBTUSD": {Long: SideScores{
+				Day:       Record{USDValue: 1, UnixTime: set.Unix()},
+				Week:      Record{USDValue: 1, UnixTime: set.Unix()},
+				Month:     Record{USDValue: 1, UnixTime: set.Unix()},
+				Year:      Record{USDValue: 1, UnixTime: set.Unix()},
+				LastDay:   31,
+				LastWeek:  5,
+				LastMonth: time.January,
+				LastYear:  2024,
+			}},
+		},
+	}
+
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := hs.migrate(tokyo); err != nil {
+		t.Fatal(err)
+	}
+
+	// Already the next day in Tokyo
+	long := hs.Scores["XBTUSD"].Long
+	if long.DayKey != "2024-02-01" || long.WeekKey != "2024-W05" || long.MonthKey != "2024-02" || long.YearKey != "2024" || long.LastDay != 0 {
+		t.Fatalf("unexpected migration %+v", long)
+	}
+
+	if short := hs.Scores["XBTUSD"].Short; short.DayKey != "" {
+		t.Fatalf("unexpected migration %+v", short)
+	}
+}
+
+func testMedalEngine(t *testing.T) *medalEngine {
+	e, err := loadMedalEngine(builtinText, medalsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return e
+}
+
+func TestStateRelatedContracts(t *testing.T) {
+	s := &State{
+		SaveFile:   filepath.Join(t.TempDir(), "high_scores.json"),
+		HighScores: newHighScores(),
+		Corpus:     Corpus{MultiKill: []string{"Double kill", "Triple kill"}},
+		Medals:     testMedalEngine(t),
+	}
+
+	cl := testLiquidation("XBTUSD", "Sell", 10000).ToCombined()
+	cl.Underlying = "XBT"
+	cl.Related = []CombinedLiquidation{testLiquidation("XBTUSDT", "Sell", 50000).ToCombined()}
+
+	// Each contract counts towards its own streak and records
+	d := s.Decorate(cl)
+	if kill := s.HighScores.Kills["XBTUSD"]; kill.Count != 1 || d.Streak != "" {
+		t.Fatalf("expected no streak %+v %q", kill, d.Streak)
+	}
+
+	if kill := s.HighScores.Kills["XBTUSDT"]; kill.Count != 1 {
+		t.Fatalf("expected the related contract's kill %+v", kill)
+	}
+
+	if record := s.HighScores.Scores["XBTUSD"].Long.Week; record.USDValue != 10000 {
+		t.Fatalf("expected the primary contract's record %+v", record)
+	}
+
+	if record := s.HighScores.Scores["XBTUSDT"].Long.Week; record.USDValue != 50000 {
+		t.Fatalf("expected the related contract's record %+v", record)
+	}
+
+	// A record broken by the related contract alone still counts
+	cl = testLiquidation("XBTUSD", "Sell", 1000).ToCombined()
+	cl.Underlying = "XBT"
+	cl.Related = []CombinedLiquidation{testLiquidation("XBTUSDT", "Sell", 60000).ToCombined()}
+
+	d = s.Decorate(cl)
+	if kill := s.HighScores.Kills["XBTUSD"]; kill.Count != 2 || d.Streak != "Double kill" {
+		t.Fatalf("expected a double kill %+v %q", kill, d.Streak)
+	}
+
+	if record := s.HighScores.Scores["XBTUSD"].Long.Week; record.USDValue != 10000 {
+		t.Fatalf("expected the primary contract's record to stand %+v", record)
+	}
+
+	if len(d.Medals) == 0 || d.Medals[0].Name != "largest_all_time" {
+		t.Fatal("expected a medal for the related contract's record", d.Medals)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+
+	"github.com/michimani/gotwi"
+)
+
+// Media endpoints, gotwi does not wrap these.
+// https://docs.x.com/x-api/media/media-upload
+const (
+	mediaUploadEndpoint   = "https://api.twitter.com/2/media/upload"
+	mediaMetadataEndpoint = "https://api.twitter.com/2/media/metadata"
+)
+
+// signedRequest creates a request signed with the client's OAuth 1.0a user context.
+// Only query parameters are included in the signature, so the body must not be form encoded.
+func signedRequest(ctx context.Context, client *gotwi.Client, method, endpoint string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := gotwi.CreateOAuthSignature(&gotwi.CreateOAuthSignatureInput{
+		HTTPMethod:       method,
+		RawEndpoint:      endpoint,
+		OAuthConsumerKey: client.OAuthConsumerKey(),
+		OAuthToken:       client.OAuthToken(),
+		SigningKey:       client.SigningKey(),
+		ParameterMap:     map[string]string{},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf(`OAuth oauth_consumer_key="%s",oauth_nonce="%s",oauth_signature="%s",oauth_signature_method="%s",oauth_timestamp="%s",oauth_token="%s",oauth_version="%s"`,
+		url.QueryEscape(client.OAuthConsumerKey()),
+		url.QueryEscape(sig.OAuthNonce),
+		url.QueryEscape(sig.OAuthSignature),
+		url.QueryEscape(sig.OAuthSignatureMethod),
+		url.QueryEscape(sig.OAuthTimestamp),
+		url.QueryEscape(client.OAuthToken()),
+		url.QueryEscape(sig.OAuthVersion),
+	))
+
+	return req, nil
+}
+
+// doSigned executes a signed request and decodes the JSON response into res.
+func doSigned(client *gotwi.Client, req *http.Request, res any) error {
+	resp, err := client.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("%v: %v", resp.Status, string(raw))
+	}
+
+	if res == nil || len(raw) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(raw, res)
+}
+
+// uploadImage uploads a PNG image with alt text, returning the media ID to attach to a tweet.
+func uploadImage(ctx context.Context, client *gotwi.Client, image []byte, altText string) (string, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+
+	if err := w.WriteField("media_category", "tweet_image"); err != nil {
+		return "", err
+	}
+
+	part, err := w.CreateFormFile("media", "card.png")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := part.Write(image); err != nil {
+		return "", err
+	}
+
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := signedRequest(ctx, client, http.MethodPost, mediaUploadEndpoint, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+
+	var upload struct {
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := doSigned(client, req, &upload); err != nil {
+		return "", fmt.Errorf("could not upload media: %w", err)
+	}
+
+	if upload.Data.ID == "" {
+		return "", fmt.Errorf("media upload returned no ID")
+	}
+
+	if altText == "" {
+		return upload.Data.ID, nil
+	}
+
+	var metadata struct {
+		ID       string `json:"id"`
+		Metadata struct {
+			AltText struct {
+				Text string `json:"text"`
+			} `json:"alt_text"`
+		} `json:"metadata"`
+	}
+	metadata.ID = upload.Data.ID
+	metadata.Metadata.AltText.Text = altText
+
+	raw, err := json.Marshal(metadata)
+	if err != nil {
+		return "", err
+	}
+
+	req, err = signedRequest(ctx, client, http.MethodPost, mediaMetadataEndpoint, bytes.NewReader(raw))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json;charset=UTF-8")
+
+	if err := doSigned(client, req, nil); err != nil {
+		return "", fmt.Errorf("could not set alt text: %w", err)
+	}
+
+	return upload.Data.ID, nil
+}
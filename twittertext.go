@@ -0,0 +1,215 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Weighted length of a post as counted by Twitter, following twitter-text v3.
+// https://github.com/twitter/twitter-text/blob/master/config/v3.json
+const (
+	twitterLengthLimit   = 280
+	twitterURLLength     = 23 // Every URL is shortened to a t.co link
+	twitterDefaultWeight = 2
+	twitterEmojiWeight   = 2
+)
+
+// Code points in these ranges weigh one, everything else weighs two.
+var twitterWeightOne = [][2]rune{
+	{0x0000, 0x10ff}, // Latin, Greek, Cyrillic, Hebrew, Arabic, ...
+	{0x2000, 0x200d}, // Spaces
+	{0x2010, 0x201f}, // Dashes and quotes
+	{0x2032, 0x2037}, // Primes
+}
+
+// Links with a scheme, or ASCII domains with an optional port and path which are checked by isBareURL.
+var urlPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"]+|\b(?:[a-z0-9](?:[a-z0-9-]*[a-z0-9])?\.)+[a-z]{2,}\b(?::[0-9]+)?(?:/[^\s<>"]*)?`)
+
+// Punctuation at the end of a link is not part of it.
+const urlTrailingPunctuation = `.,;:!?'")]}`
+
+// A bare domain straight after one of these characters is not a link.
+const urlInvalidPreceding = `-_./@#$`
+
+// Top level domains of bare links. Every country code is listed, only the common generic ones are.
+var (
+	genericTLDs = tldSet(`
+		aero app art asia bank bar best bet bid bio biz blog blue build business buzz cafe capital cash casino cat center
+		chat city click cloud club codes coffee com community company computer cool coop credit dating deals design dev
+		digital direct domains earth edu email energy estate events exchange expert express finance financial fit fun fund
+		games gold golf gov group guide guru health host house inc info ink institute int international investments jobs
+		land life link live loan ltd market marketing media mil mobi money museum name net network news ninja now one online
+		org page partners pizza place plus poker press pro pub red rocks run sale school science services shop show site
+		social software solutions space store stream studio support systems team tech technology tel tips today tools top
+		trade trading travel university vip vision watch website wiki win work works world wtf xxx xyz zone`)
+
+	countryTLDs = tldSet(`
+		ac ad ae af ag ai al am ao aq ar as at au aw ax az ba bb bd be bf bg bh bi bj bl bm bn bo bq br bs bt bv bw by bz
+		ca cc cd cf cg ch ci ck cl cm cn co cr cu cv cw cx cy cz de dj dk dm do dz ec ee eg eh er es et eu fi fj fk fm fo
+		fr ga gb gd ge gf gg gh gi gl gm gn gp gq gr gs gt gu gw gy hk hm hn hr ht hu id ie il im in io iq ir is it je jm
+		jo jp ke kg kh ki km kn kp kr kw ky kz la lb lc li lk lr ls lt lu lv ly ma mc md me mf mg mh mk ml mm mn mo mp mq
+		mr ms mt mu mv mw mx my mz na nc ne nf ng ni nl no np nr nu nz om pa pe pf pg ph pk pl pm pn pr ps pt pw py qa re
+		ro rs ru rw sa sb sc sd se sg sh si sj sk sl sm sn so sr ss st su sv sx sy sz tc td tf tg th tj tk tl tm tn to tr
+		tt tv tw tz ua ug uk um us uy uz va vc ve vg vi vn vu wf ws ye yt za zm zw`)
+)
+
+func tldSet(list string) map[string]bool {
+	set := make(map[string]bool)
+	for _, tld := range strings.Fields(list) {
+		set[tld] = true
+	}
+
+	return set
+}
+
+// isBareURL reports whether a domain matched without a scheme is a link, following twitter-text. The domain must end
+// in a known top level domain, and a single name under a country code, such as example.io, needs a path unless
+// it is a .co or .tv domain like t.co.
+func isBareURL(text string, start int, url string) bool {
+	if start > 0 && strings.ContainsRune(urlInvalidPreceding, rune(text[start-1])) {
+		return false
+	}
+
+	host, path, _ := strings.Cut(url, "/")
+	host, _, _ = strings.Cut(host, ":")
+	labels := strings.Split(strings.ToLower(host), ".")
+
+	switch tld := labels[len(labels)-1]; {
+	case genericTLDs[tld]:
+		return true
+	case !countryTLDs[tld]:
+		return false
+	case len(labels) > 2 || path != "" || strings.HasSuffix(url, "/"):
+		return true
+	default:
+		return tld == "co" || tld == "tv"
+	}
+}
+
+// postLength is the length of a post as counted by Twitter.
+//
+// Links count as 23, emoji count as two including flags, keycaps, skin tones and ZWJ sequences, then each code point
+// is weighted by its range. The text is NFC normalised first, as Twitter does.
+func postLength(text string) (n int) {
+	text = norm.NFC.String(text)
+	for _, m := range urlPattern.FindAllStringIndex(text, -1) {
+		url := strings.TrimRight(text[m[0]:m[1]], urlTrailingPunctuation)
+		if !strings.Contains(url, "://") && !isBareURL(text, m[0], url) {
+			continue
+		}
+
+		n += twitterURLLength - weightedLength(url)
+	}
+
+	return n + weightedLength(text)
+}
+
+// weightedLength weighs each emoji sequence and code point.
+func weightedLength(text string) (n int) {
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		if end := emojiSequenceEnd(runes, i); end > i {
+			n += twitterEmojiWeight
+			i = end
+			continue
+		}
+
+		n += runeWeight(runes[i])
+		i++
+	}
+
+	return n
+}
+
+func runeWeight(r rune) int {
+	for _, v := range twitterWeightOne {
+		if r >= v[0] && r <= v[1] {
+			return 1
+		}
+	}
+
+	return twitterDefaultWeight
+}
+
+// emojiSequenceEnd returns the end of the emoji sequence starting at i, or i if there is none.
+func emojiSequenceEnd(runes []rune, i int) int {
+	at := func(j int) rune {
+		if j < len(runes) {
+			return runes[j]
+		}
+		return -1
+	}
+
+	r := runes[i]
+	switch {
+	case isRegionalIndicator(r):
+		// Flags are pairs of regional indicators
+		if isRegionalIndicator(at(i + 1)) {
+			return i + 2
+		}
+		return i + 1
+
+	case r >= '0' && r <= '9', r == '#', r == '*':
+		// Keycaps, e.g. 1️⃣
+		j := i + 1
+		if at(j) == 0xfe0f {
+			j++
+		}
+		if at(j) == 0x20e3 {
+			return j + 1
+		}
+		return i
+
+	case !isPictographic(r) && !(at(i+1) == 0xfe0f && isEmojiPresentation(r)):
+		return i
+	}
+
+	// Modifiers, and more emoji joined with ZWJ
+	j := i + 1
+	for {
+		switch c := at(j); {
+		case c == 0xfe0f, c == 0xfe0e, c == 0x20e3, c >= 0x1f3fb && c <= 0x1f3ff, c >= 0xe0020 && c <= 0xe007f:
+			j++
+		case c == 0x200d && (isPictographic(at(j+1)) || at(j+2) == 0xfe0f && isEmojiPresentation(at(j+1))):
+			j += 2
+		default:
+			return j
+		}
+	}
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
+
+// isEmojiPresentation reports whether r is shown as an emoji when followed by U+FE0F, e.g. ©️ or ↔️.
+func isEmojiPresentation(r rune) bool {
+	switch {
+	case isPictographic(r):
+		return true
+	case r == 0x00a9, r == 0x00ae, r == 0x2122, r == 0x2139, r == 0x24c2, r == 0x2934, r == 0x2935:
+		return true
+	case r >= 0x2194 && r <= 0x2199, r == 0x21a9, r == 0x21aa:
+		return true
+	case r == 0x25aa, r == 0x25ab, r == 0x25b6, r == 0x25c0, r >= 0x25fb && r <= 0x25fe:
+		return true
+	}
+
+	return false
+}
+
+// isPictographic approximates the Extended_Pictographic property.
+func isPictographic(r rune) bool {
+	switch {
+	case r >= 0x1f000 && r <= 0x1faff, r >= 0x1fc00 && r <= 0x1fffd:
+		return true
+	case r >= 0x2300 && r <= 0x23ff, r >= 0x2600 && r <= 0x27bf, r >= 0x2b00 && r <= 0x2bff:
+		return true
+	case r == 0x203c, r == 0x2049, r == 0x3030, r == 0x303d, r == 0x3297, r == 0x3299:
+		return true
+	}
+
+	return false
+}